@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// asciicastMaxLine is the longest event line read when replaying a recording
+const asciicastMaxLine = 1024 * 1024
+
+// asciicastHeader is the first line of an asciicast v2 file
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// asciicastWriter records console output as asciicast v2 output events
+// Recording errors are logged once and do not interrupt the console session
+type asciicastWriter struct {
+	mu      sync.Mutex
+	file    *os.File
+	start   time.Time
+	pending []byte // Incomplete UTF-8 sequence at the end of the last write
+	failed  bool
+}
+
+// getConsoleRecordingPath returns a new recording path of a VM with console.record set
+func getConsoleRecordingPath(vmName string) (string, error) {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		return "", err
+	}
+
+	recordingsDir := filepath.Join(instanceDir, "recordings")
+	if err := os.MkdirAll(recordingsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create recordings directory: %w", err)
+	}
+
+	name := fmt.Sprintf("console-%s.cast", time.Now().Format("20060102-150405"))
+	return filepath.Join(recordingsDir, name), nil
+}
+
+// createAsciicast creates a recording and writes its header, sized like the terminal on stdout
+func createAsciicast(path string, title string) (*asciicastWriter, error) {
+	logger.Printf("Recording console to: %s", path)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+
+	header := asciicastHeader{
+		Version:   2,
+		Width:     80,
+		Height:    24,
+		Timestamp: time.Now().Unix(),
+		Title:     title,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+	}
+	if width, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		header.Width = width
+		header.Height = height
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to encode recording header: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write recording: %w", err)
+	}
+
+	return &asciicastWriter{file: file, start: time.Now()}, nil
+}
+
+// Write records p as an output event, keeping an incomplete UTF-8 sequence for the next write
+func (w *asciicastWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := append(w.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	w.pending = append([]byte(nil), data[cut:]...)
+
+	if cut > 0 {
+		w.writeEvent(time.Since(w.start), string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+// writeEvent appends an output event with its time from the start of the recording
+func (w *asciicastWriter) writeEvent(elapsed time.Duration, output string) {
+	event, err := json.Marshal([]interface{}{float64(elapsed.Microseconds()) / 1e6, "o", output})
+	if err == nil {
+		_, err = w.file.Write(append(event, '\n'))
+	}
+	if err != nil && !w.failed {
+		w.failed = true
+		logger.Printf("Warning: failed to write recording: %v", err)
+	}
+}
+
+// Close flushes the pending bytes and closes the recording
+func (w *asciicastWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) > 0 {
+		w.writeEvent(time.Since(w.start), string(w.pending))
+		w.pending = nil
+	}
+	return w.file.Close()
+}
+
+// replayAsciicast plays the output events of an asciicast v2 recording on stdout
+// speed divides the delays between events, idleLimit caps them (0 for no cap)
+func replayAsciicast(path string, speed float64, idleLimit time.Duration) error {
+	logger.Printf("Replaying recording: %s (speed %.2f, idle limit %s)", path, speed, idleLimit)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), asciicastMaxLine)
+
+	if !scanner.Scan() {
+		return fmt.Errorf("empty recording: %s", path)
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("invalid recording header: %w", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version: %d (expected 2)", header.Version)
+	}
+
+	var last float64
+	for line := 2; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid event on line %d of recording", line)
+		}
+		at, ok1 := event[0].(float64)
+		kind, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("invalid event on line %d of recording", line)
+		}
+
+		// Input, marker and resize events are not played back
+		if kind != "o" {
+			continue
+		}
+
+		delay := time.Duration((at - last) / speed * float64(time.Second))
+		if idleLimit > 0 && delay > idleLimit {
+			delay = idleLimit
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		last = at
+
+		os.Stdout.WriteString(data)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read recording: %w", err)
+	}
+
+	return nil
+}
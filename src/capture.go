@@ -0,0 +1,669 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultSnaplen is the default maximum number of bytes captured per packet
+const defaultSnaplen = 262144
+
+// CaptureTarget is the interface captured by "network capture"
+// Bridge, TAP and macvtap interfaces are captured on the host (Interface), user-mode and
+// rootless NICs only exist inside QEMU and are captured with a filter-dump object (NetdevID)
+type CaptureTarget struct {
+	VM        string
+	Network   string
+	Interface string
+	NetdevID  string
+}
+
+// String describes a capture target, for example: "qt-0123456789ab (web on frontend)"
+func (t CaptureTarget) String() string {
+	if t.VM == "" {
+		return fmt.Sprintf("%s (network %s)", t.Interface, t.Network)
+	}
+	if t.Interface == "" {
+		return fmt.Sprintf("netdev %s (%s on %s)", t.NetdevID, t.VM, t.Network)
+	}
+	return fmt.Sprintf("%s (%s on %s)", t.Interface, t.VM, t.Network)
+}
+
+// resolveCaptureTarget returns the interface to capture for a network or a VM
+// For a VM, networkName selects the interface (default: its first network, or the user-mode NIC
+// of VMs without networks); "user" selects the user-mode NIC used for SSH access
+func resolveCaptureTarget(name string, networkName string, config *ComposeConfig) (CaptureTarget, error) {
+	vm, isVM := config.VMs[name]
+	network, isNetwork := config.Networks[name]
+
+	switch {
+	case isVM && isNetwork:
+		return CaptureTarget{}, fmt.Errorf("%s is both a VM and a network", name)
+
+	case isNetwork:
+		if networkName != "" {
+			return CaptureTarget{}, fmt.Errorf("--network only applies to VM targets")
+		}
+		if isRootlessNetwork(network) {
+			return CaptureTarget{}, fmt.Errorf("network %s uses the %s driver and has no host interface, capture one of its VMs instead", name, getNetworkDriver(network))
+		}
+		if isMacvtapNetwork(network) {
+			return CaptureTarget{}, fmt.Errorf("network %s uses macvtap on %s, capture one of its VMs instead", name, network.Parent)
+		}
+		return CaptureTarget{Network: name, Interface: resolveBridgeName(name, network)}, nil
+
+	case isVM:
+		networkNames := getVMNetworkNames(vm)
+		if networkName == "" {
+			networkName = "user"
+			if len(networkNames) > 0 {
+				networkName = networkNames[0]
+			}
+		}
+
+		for i, vmNetworkName := range networkNames {
+			if vmNetworkName != networkName {
+				continue
+			}
+			target := CaptureTarget{VM: name, Network: networkName}
+			if isRootlessNetwork(config.Networks[networkName]) {
+				target.NetdevID = fmt.Sprintf("net%d", i)
+			} else {
+				target.Interface = getTAPName(name, i)
+			}
+			return target, nil
+		}
+
+		if networkName == "user" {
+			if !usesManagementNIC(vm) {
+				return CaptureTarget{}, fmt.Errorf("VM %s has no user-mode NIC", name)
+			}
+			// The user-mode NIC comes after the network interfaces (see buildQEMUCommand)
+			return CaptureTarget{VM: name, Network: "user", NetdevID: fmt.Sprintf("net%d", len(vm.Networks))}, nil
+		}
+
+		return CaptureTarget{}, fmt.Errorf("VM %s is not attached to network %s", name, networkName)
+
+	default:
+		return CaptureTarget{}, fmt.Errorf("no VM or network named %s", name)
+	}
+}
+
+// capturedPacket is a captured Ethernet frame
+type capturedPacket struct {
+	Timestamp time.Time
+	Data      []byte // Possibly truncated to the snaplen
+	Length    int    // Original length on the wire
+}
+
+// packetSink receives captured packets
+type packetSink interface {
+	WritePacket(packet capturedPacket) error
+}
+
+// captureSession tracks the packets of a capture and when to stop it
+type captureSession struct {
+	sink  packetSink
+	limit int // 0 for no limit
+	count int
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// newCaptureSession creates a capture session writing to a sink, stopping after limit packets if set
+func newCaptureSession(sink packetSink, limit int) *captureSession {
+	return &captureSession{sink: sink, limit: limit, stop: make(chan struct{})}
+}
+
+// Stop ends the capture
+func (s *captureSession) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// stopped returns true once the capture is ended
+func (s *captureSession) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle writes a packet to the sink and stops the capture when the packet limit is reached
+func (s *captureSession) handle(packet capturedPacket) error {
+	if s.limit > 0 && s.count >= s.limit {
+		return nil
+	}
+	if err := s.sink.WritePacket(packet); err != nil {
+		return err
+	}
+	s.count++
+	if s.limit > 0 && s.count >= s.limit {
+		s.Stop()
+	}
+	return nil
+}
+
+// runCapture captures a target until the session is stopped
+func runCapture(target CaptureTarget, snaplen int, session *captureSession) error {
+	if target.Interface != "" {
+		return captureInterface(target.Interface, snaplen, session)
+	}
+	return captureNetdev(target.VM, target.NetdevID, snaplen, session)
+}
+
+// htons converts a 16-bit value to network byte order
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
+
+// captureInterface captures all frames sent and received on a host interface with an AF_PACKET socket
+func captureInterface(ifaceName string, snaplen int, session *captureSession) error {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("interface %s not found (is the network up and the VM running?): %w", ifaceName, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(syscall.ETH_P_ALL)))
+	if err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("failed to open packet socket: %w (requires CAP_NET_RAW, run with sudo or grant cap_net_raw)", err)
+		}
+		return fmt.Errorf("failed to open packet socket: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	address := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: iface.Index}
+	if err := syscall.Bind(fd, address); err != nil {
+		return fmt.Errorf("failed to bind packet socket to %s: %w", ifaceName, err)
+	}
+
+	// Wake up regularly to notice when the capture is stopped
+	timeout := syscall.NsecToTimeval((200 * time.Millisecond).Nanoseconds())
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return fmt.Errorf("failed to set packet socket timeout: %w", err)
+	}
+
+	logger.Printf("Capturing on %s (index %d, snaplen %d)", ifaceName, iface.Index, snaplen)
+
+	buffer := make([]byte, snaplen)
+	for !session.stopped() {
+		// MSG_TRUNC returns the original length of frames larger than the buffer
+		length, _, err := syscall.Recvfrom(fd, buffer, syscall.MSG_TRUNC)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+				continue
+			}
+			return fmt.Errorf("failed to read from %s: %w", ifaceName, err)
+		}
+
+		captured := length
+		if captured > len(buffer) {
+			captured = len(buffer)
+		}
+		data := make([]byte, captured)
+		copy(data, buffer[:captured])
+
+		if err := session.handle(capturedPacket{Timestamp: time.Now(), Data: data, Length: length}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// captureNetdev captures a QEMU network backend with a filter-dump object added over QMP
+// QEMU writes the frames to a pcap file in the instance directory, which is followed until the
+// capture is stopped; the filter and the file are removed afterwards. The QMP socket is only held
+// to add and remove the filter, other commands can use it during the capture
+func captureNetdev(vmName string, netdevID string, snaplen int, session *captureSession) error {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		return err
+	}
+
+	filterID := fmt.Sprintf("qc-capture-%d", os.Getpid())
+	dumpPath := filepath.Join(instanceDir, fmt.Sprintf("capture-%d.pcap", os.Getpid()))
+
+	client, err := connectQMP(vmName)
+	if err != nil {
+		return err
+	}
+	_, err = client.Execute("object-add", map[string]interface{}{
+		"qom-type": "filter-dump",
+		"id":       filterID,
+		"netdev":   netdevID,
+		"file":     dumpPath,
+		"maxlen":   snaplen,
+	})
+	client.Close()
+	if err != nil {
+		return fmt.Errorf("failed to add filter-dump on %s: %w", netdevID, err)
+	}
+	logger.Printf("Added filter-dump %s on %s of VM %s, writing to %s", filterID, netdevID, vmName, dumpPath)
+
+	defer os.Remove(dumpPath)
+	defer func() {
+		client, err := connectQMP(vmName)
+		if err != nil {
+			logger.Printf("Warning: failed to remove filter-dump %s: %v", filterID, err)
+			return
+		}
+		defer client.Close()
+
+		if _, err := client.Execute("object-del", map[string]interface{}{"id": filterID}); err != nil {
+			logger.Printf("Warning: failed to remove filter-dump %s: %v", filterID, err)
+		}
+	}()
+
+	file, err := os.Open(dumpPath)
+	if err != nil {
+		return fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer file.Close()
+
+	reader := &followReader{file: file, follow: func() bool { return !session.stopped() }}
+
+	byteOrder, err := readPcapHeader(reader)
+	if err != nil {
+		return err
+	}
+
+	for {
+		packet, err := readPcapRecord(reader, byteOrder)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := session.handle(packet); err != nil {
+			return err
+		}
+		if session.limit > 0 && session.count >= session.limit {
+			return nil
+		}
+	}
+}
+
+// followReader reads a file that is still being written, like tail -f, while follow returns true
+type followReader struct {
+	file   *os.File
+	follow func() bool
+}
+
+// Read reads from the file, waiting for more data at the end of the file
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if !r.follow() {
+			return 0, io.EOF
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// pcapMagic is the magic number of pcap files with microsecond timestamps
+const pcapMagic = 0xa1b2c3d4
+
+// pcapLinkTypeEthernet is the pcap link type of Ethernet frames
+const pcapLinkTypeEthernet = 1
+
+// readPcapHeader reads the global header of a pcap file and returns its byte order
+func readPcapHeader(reader io.Reader) (binary.ByteOrder, error) {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+
+	switch {
+	case binary.LittleEndian.Uint32(header[0:4]) == pcapMagic:
+		return binary.LittleEndian, nil
+	case binary.BigEndian.Uint32(header[0:4]) == pcapMagic:
+		return binary.BigEndian, nil
+	default:
+		return nil, fmt.Errorf("invalid pcap header")
+	}
+}
+
+// readPcapRecord reads the next packet of a pcap file
+func readPcapRecord(reader io.Reader, byteOrder binary.ByteOrder) (capturedPacket, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return capturedPacket{}, err
+	}
+
+	seconds := byteOrder.Uint32(header[0:4])
+	microseconds := byteOrder.Uint32(header[4:8])
+	data := make([]byte, byteOrder.Uint32(header[8:12]))
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return capturedPacket{}, err
+	}
+
+	return capturedPacket{
+		Timestamp: time.Unix(int64(seconds), int64(microseconds)*1000),
+		Data:      data,
+		Length:    int(byteOrder.Uint32(header[12:16])),
+	}, nil
+}
+
+// pcapWriter writes captured packets in pcap format, readable by tcpdump and Wireshark
+type pcapWriter struct {
+	writer io.Writer
+}
+
+// newPcapWriter writes the pcap global header and returns a writer for the packets
+func newPcapWriter(writer io.Writer, snaplen int) (*pcapWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:6], 2) // Version 2.4
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], uint32(snaplen))
+	binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeEthernet)
+
+	if _, err := writer.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write pcap header: %w", err)
+	}
+	return &pcapWriter{writer: writer}, nil
+}
+
+// WritePacket writes a packet record
+func (w *pcapWriter) WritePacket(packet capturedPacket) error {
+	record := make([]byte, 16, 16+len(packet.Data))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(packet.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(packet.Timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(packet.Data)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(packet.Length))
+
+	if _, err := w.writer.Write(append(record, packet.Data...)); err != nil {
+		return fmt.Errorf("failed to write packet: %w", err)
+	}
+	return nil
+}
+
+// textWriter prints a one-line summary of each captured packet
+type textWriter struct {
+	writer io.Writer
+}
+
+// WritePacket prints the summary of a packet
+func (w *textWriter) WritePacket(packet capturedPacket) error {
+	_, err := fmt.Fprintf(w.writer, "%s %s\n", packet.Timestamp.Format("15:04:05.000000"), summarizePacket(packet.Data))
+	return err
+}
+
+// summarizePacket returns a tcpdump-like summary of an Ethernet frame, for example:
+// "52:54:00:12:34:56 > ff:ff:ff:ff:ff:ff, UDP 0.0.0.0:68 > 255.255.255.255:67 DHCP DISCOVER"
+func summarizePacket(frame []byte) string {
+	if len(frame) < 14 {
+		return fmt.Sprintf("truncated frame, length %d", len(frame))
+	}
+
+	prefix := fmt.Sprintf("%s > %s, ", net.HardwareAddr(frame[6:12]), net.HardwareAddr(frame[0:6]))
+	etherType := binary.BigEndian.Uint16(frame[12:14])
+	payload := frame[14:]
+
+	// 802.1Q tagged frame
+	if etherType == 0x8100 && len(payload) >= 4 {
+		prefix += fmt.Sprintf("vlan %d, ", binary.BigEndian.Uint16(payload[0:2])&0x0fff)
+		etherType = binary.BigEndian.Uint16(payload[2:4])
+		payload = payload[4:]
+	}
+
+	switch etherType {
+	case 0x0806:
+		return prefix + summarizeARP(payload)
+	case 0x0800:
+		return prefix + summarizeIPv4(payload)
+	case 0x86dd:
+		return prefix + summarizeIPv6(payload)
+	default:
+		return prefix + fmt.Sprintf("ethertype 0x%04x, length %d", etherType, len(frame))
+	}
+}
+
+// summarizeARP summarizes an ARP request or reply
+func summarizeARP(packet []byte) string {
+	if len(packet) < 28 || binary.BigEndian.Uint16(packet[2:4]) != 0x0800 {
+		return "ARP"
+	}
+
+	senderMAC := net.HardwareAddr(packet[8:14])
+	senderIP := net.IP(packet[14:18])
+	targetIP := net.IP(packet[24:28])
+
+	switch binary.BigEndian.Uint16(packet[6:8]) {
+	case 1:
+		return fmt.Sprintf("ARP who-has %s tell %s", targetIP, senderIP)
+	case 2:
+		return fmt.Sprintf("ARP reply %s is-at %s", senderIP, senderMAC)
+	default:
+		return "ARP"
+	}
+}
+
+// summarizeIPv4 summarizes an IPv4 packet
+func summarizeIPv4(packet []byte) string {
+	if len(packet) < 20 {
+		return "IPv4 truncated"
+	}
+
+	headerLength := int(packet[0]&0x0f) * 4
+	if headerLength < 20 || len(packet) < headerLength {
+		return "IPv4 truncated"
+	}
+
+	return summarizeTransport("IPv4", packet[9], net.IP(packet[12:16]), net.IP(packet[16:20]), packet[headerLength:])
+}
+
+// summarizeIPv6 summarizes an IPv6 packet (extension headers are not decoded)
+func summarizeIPv6(packet []byte) string {
+	if len(packet) < 40 {
+		return "IPv6 truncated"
+	}
+
+	return summarizeTransport("IPv6", packet[6], net.IP(packet[8:24]), net.IP(packet[24:40]), packet[40:])
+}
+
+// icmpTypes names common ICMP and ICMPv6 message types
+var icmpTypes = map[string]map[byte]string{
+	"ICMP":   {0: "echo reply", 3: "unreachable", 8: "echo request", 11: "time exceeded"},
+	"ICMPv6": {1: "unreachable", 3: "time exceeded", 128: "echo request", 129: "echo reply", 133: "router solicitation", 134: "router advertisement", 135: "neighbor solicitation", 136: "neighbor advertisement"},
+}
+
+// summarizeTransport summarizes the TCP, UDP or ICMP payload of an IP packet
+func summarizeTransport(family string, protocol byte, src net.IP, dst net.IP, payload []byte) string {
+	switch protocol {
+	case 6:
+		if len(payload) < 20 {
+			return fmt.Sprintf("TCP %s > %s truncated", src, dst)
+		}
+		srcPort := binary.BigEndian.Uint16(payload[0:2])
+		dstPort := binary.BigEndian.Uint16(payload[2:4])
+		dataOffset := int(payload[12]>>4) * 4
+		dataLength := len(payload) - dataOffset
+		if dataLength < 0 {
+			dataLength = 0
+		}
+		return fmt.Sprintf("TCP %s > %s [%s], length %d", joinHostPort(src, srcPort), joinHostPort(dst, dstPort), tcpFlags(payload[13]), dataLength)
+
+	case 17:
+		if len(payload) < 8 {
+			return fmt.Sprintf("UDP %s > %s truncated", src, dst)
+		}
+		srcPort := binary.BigEndian.Uint16(payload[0:2])
+		dstPort := binary.BigEndian.Uint16(payload[2:4])
+		summary := fmt.Sprintf("UDP %s > %s", joinHostPort(src, srcPort), joinHostPort(dst, dstPort))
+		if application := summarizeUDPApplication(srcPort, dstPort, payload[8:]); application != "" {
+			return summary + " " + application
+		}
+		return summary + fmt.Sprintf(", length %d", len(payload)-8)
+
+	case 1, 58:
+		name := "ICMP"
+		if protocol == 58 {
+			name = "ICMPv6"
+		}
+		if len(payload) < 1 {
+			return fmt.Sprintf("%s %s > %s truncated", name, src, dst)
+		}
+		description, known := icmpTypes[name][payload[0]]
+		if !known {
+			description = fmt.Sprintf("type %d", payload[0])
+		}
+		return fmt.Sprintf("%s %s > %s %s", name, src, dst, description)
+
+	default:
+		return fmt.Sprintf("%s %s > %s protocol %d, length %d", family, src, dst, protocol, len(payload))
+	}
+}
+
+// joinHostPort formats an address and a port, with brackets for IPv6 addresses
+func joinHostPort(ip net.IP, port uint16) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// tcpFlags formats TCP flags like tcpdump: S (SYN), F (FIN), R (RST), P (PSH), . (ACK)
+func tcpFlags(flags byte) string {
+	var result strings.Builder
+	for _, flag := range []struct {
+		mask   byte
+		letter string
+	}{{0x02, "S"}, {0x01, "F"}, {0x04, "R"}, {0x08, "P"}, {0x10, "."}} {
+		if flags&flag.mask != 0 {
+			result.WriteString(flag.letter)
+		}
+	}
+	if result.Len() == 0 {
+		return "none"
+	}
+	return result.String()
+}
+
+// dhcpMessageTypes names DHCPv4 message types (option 53)
+var dhcpMessageTypes = map[byte]string{
+	1: "DISCOVER", 2: "OFFER", 3: "REQUEST", 4: "DECLINE", 5: "ACK", 6: "NAK", 7: "RELEASE", 8: "INFORM",
+}
+
+// dhcp6MessageTypes names DHCPv6 message types
+var dhcp6MessageTypes = map[byte]string{
+	1: "SOLICIT", 2: "ADVERTISE", 3: "REQUEST", 4: "CONFIRM", 5: "RENEW", 6: "REBIND", 7: "REPLY", 8: "RELEASE", 11: "INFORMATION-REQUEST",
+}
+
+// dnsTypes names common DNS record types
+var dnsTypes = map[uint16]string{
+	1: "A", 2: "NS", 5: "CNAME", 6: "SOA", 12: "PTR", 15: "MX", 16: "TXT", 28: "AAAA", 33: "SRV", 255: "ANY",
+}
+
+// summarizeUDPApplication decodes DHCP, DHCPv6 and DNS messages, the usual suspects on project networks
+func summarizeUDPApplication(srcPort uint16, dstPort uint16, payload []byte) string {
+	isPort := func(port uint16) bool { return srcPort == port || dstPort == port }
+
+	switch {
+	case isPort(67) || isPort(68):
+		return summarizeDHCP(payload)
+	case isPort(546) || isPort(547):
+		if len(payload) < 1 {
+			return "DHCPv6"
+		}
+		if name, known := dhcp6MessageTypes[payload[0]]; known {
+			return "DHCPv6 " + name
+		}
+		return fmt.Sprintf("DHCPv6 type %d", payload[0])
+	case isPort(53):
+		return summarizeDNS(payload)
+	default:
+		return ""
+	}
+}
+
+// summarizeDHCP returns the message type of a DHCPv4 message, and the offered address if any
+func summarizeDHCP(payload []byte) string {
+	if len(payload) < 240 || binary.BigEndian.Uint32(payload[236:240]) != 0x63825363 {
+		return "BOOTP"
+	}
+
+	summary := "DHCP"
+	options := payload[240:]
+	for len(options) > 0 && options[0] != 255 {
+		if options[0] == 0 {
+			options = options[1:]
+			continue
+		}
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			break
+		}
+		if options[0] == 53 && options[1] == 1 {
+			if name, known := dhcpMessageTypes[options[2]]; known {
+				summary += " " + name
+			}
+		}
+		options = options[2+int(options[1]):]
+	}
+
+	// yiaddr: the address offered or acknowledged by the server
+	if yourIP := net.IP(payload[16:20]); !yourIP.IsUnspecified() {
+		summary += " " + yourIP.String()
+	}
+
+	return summary
+}
+
+// summarizeDNS returns the type and first question of a DNS message
+func summarizeDNS(payload []byte) string {
+	if len(payload) < 12 {
+		return "DNS truncated"
+	}
+
+	kind := "query"
+	if payload[2]&0x80 != 0 {
+		kind = "response"
+		if rcode := payload[3] & 0x0f; rcode != 0 {
+			kind = fmt.Sprintf("response rcode %d", rcode)
+		}
+	}
+
+	if binary.BigEndian.Uint16(payload[4:6]) == 0 {
+		return "DNS " + kind
+	}
+
+	// First question: a sequence of labels, then type and class
+	var labels []string
+	offset := 12
+	for offset < len(payload) && payload[offset] != 0 {
+		length := int(payload[offset])
+		if length&0xc0 != 0 || offset+1+length > len(payload) {
+			return "DNS " + kind
+		}
+		labels = append(labels, string(payload[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	if offset+3 > len(payload) {
+		return "DNS " + kind
+	}
+
+	recordType := binary.BigEndian.Uint16(payload[offset+1 : offset+3])
+	typeName, known := dnsTypes[recordType]
+	if !known {
+		typeName = fmt.Sprintf("type %d", recordType)
+	}
+
+	return fmt.Sprintf("DNS %s %s %s", kind, typeName, strings.Join(labels, ".")+".")
+}
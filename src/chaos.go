@@ -0,0 +1,417 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+// getPartitionSettings returns the link settings putting targets in the same partition
+// The partition is named after the targets, so that each "network chaos --partition" makes its own
+func getPartitionSettings(names []string) LinkSettings {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	return LinkSettings{Partition: strings.Join(sorted, ",")}
+}
+
+// isEmpty returns true if no impairment is set
+func (l LinkSettings) isEmpty() bool {
+	return l.Partition == "" && l.Delay == "" && l.Jitter == "" && l.Loss == "" && l.Corrupt == "" && l.Rate == ""
+}
+
+// String returns a tc-like description of link settings, for example: "delay 100ms jitter 10ms loss 1%"
+func (l LinkSettings) String() string {
+	if l.Partition != "" {
+		return "partition " + l.Partition
+	}
+
+	var parts []string
+	if l.Delay != "" {
+		parts = append(parts, "delay "+l.Delay)
+	}
+	if l.Jitter != "" {
+		parts = append(parts, "jitter "+l.Jitter)
+	}
+	if l.Loss != "" {
+		parts = append(parts, "loss "+l.Loss)
+	}
+	if l.Corrupt != "" {
+		parts = append(parts, "corrupt "+l.Corrupt)
+	}
+	if l.Rate != "" {
+		parts = append(parts, "rate "+l.Rate)
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseLinkSettings converts link settings to netem attributes
+func parseLinkSettings(settings LinkSettings) (netlink.NetemQdiscAttrs, error) {
+	var attrs netlink.NetemQdiscAttrs
+
+	if settings.Delay != "" {
+		delay, err := time.ParseDuration(settings.Delay)
+		if err != nil || delay < 0 {
+			return attrs, fmt.Errorf("invalid delay %q (expected a duration like 100ms)", settings.Delay)
+		}
+		attrs.Latency = uint32(delay.Microseconds())
+	}
+
+	if settings.Jitter != "" {
+		jitter, err := time.ParseDuration(settings.Jitter)
+		if err != nil || jitter < 0 {
+			return attrs, fmt.Errorf("invalid jitter %q (expected a duration like 10ms)", settings.Jitter)
+		}
+		if attrs.Latency == 0 {
+			return attrs, fmt.Errorf("jitter requires a delay")
+		}
+		attrs.Jitter = uint32(jitter.Microseconds())
+	}
+
+	var err error
+	if attrs.Loss, err = parsePercentage("loss", settings.Loss); err != nil {
+		return attrs, err
+	}
+	if attrs.CorruptProb, err = parsePercentage("corrupt", settings.Corrupt); err != nil {
+		return attrs, err
+	}
+
+	if settings.Rate != "" {
+		if attrs.Rate64, err = parseRate(settings.Rate); err != nil {
+			return attrs, err
+		}
+	}
+
+	return attrs, nil
+}
+
+// parsePercentage parses a percentage like "1%", "0.5%" or "1"
+func parsePercentage(name string, value string) (float32, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	percentage, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 32)
+	if err != nil || percentage < 0 || percentage > 100 {
+		return 0, fmt.Errorf("invalid %s %q (expected a percentage like 1%%)", name, value)
+	}
+	return float32(percentage), nil
+}
+
+// rateUnits maps tc rate units to bytes per second
+var rateUnits = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"gbit", 1e9 / 8},
+	{"mbit", 1e6 / 8},
+	{"kbit", 1e3 / 8},
+	{"bit", 1.0 / 8},
+	{"gbps", 1e9},
+	{"mbps", 1e6},
+	{"kbps", 1e3},
+	{"bps", 1},
+}
+
+// parseRate parses a tc-style rate ("10mbit", "512kbit", "1mbps") into bytes per second
+func parseRate(value string) (uint64, error) {
+	rate := strings.ToLower(strings.TrimSpace(value))
+	for _, unit := range rateUnits {
+		if !strings.HasSuffix(rate, unit.suffix) {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSuffix(rate, unit.suffix), 64)
+		if err != nil || number <= 0 {
+			break
+		}
+		return uint64(math.Ceil(number * unit.bytes)), nil
+	}
+	return 0, fmt.Errorf("invalid rate %q (expected a rate like 10mbit, 512kbit or 1mbps)", value)
+}
+
+// applyLinkSettings replaces the root qdisc of a TAP device with netem
+// The qdisc shapes the traffic delivered to the VM: between two VMs of a network, each direction
+// goes through the netem of the receiving VM. A partition leaves the link clean, its traffic is
+// filtered on the bridge by setupPartitionRules
+func applyLinkSettings(tapName string, settings LinkSettings) error {
+	attrs, err := parseLinkSettings(settings)
+	if err != nil {
+		return err
+	}
+
+	if settings.Partition != "" {
+		return clearLinkSettings(tapName)
+	}
+
+	link, err := netlink.LinkByName(tapName)
+	if err != nil {
+		return fmt.Errorf("failed to find TAP device %s: %w", tapName, err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", tapName, err)
+	}
+
+	netem := netlink.NewNetem(netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    netlink.MakeHandle(1, 0),
+		Parent:    netlink.HANDLE_ROOT,
+	}, attrs)
+
+	if err := netlink.QdiscReplace(netem); err != nil {
+		return fmt.Errorf("failed to apply netem on %s: %w", tapName, err)
+	}
+
+	logger.Printf("Applied link settings on %s: %s", tapName, settings.String())
+	return nil
+}
+
+// clearLinkSettings removes the netem root qdisc of a TAP device, if any
+func clearLinkSettings(tapName string) error {
+	link, err := netlink.LinkByName(tapName)
+	if err != nil {
+		return fmt.Errorf("failed to find TAP device %s: %w", tapName, err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", tapName, err)
+	}
+
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("failed to list qdiscs of %s: %w", tapName, err)
+	}
+
+	for _, qdisc := range qdiscs {
+		if qdisc.Attrs().Parent != netlink.HANDLE_ROOT || qdisc.Type() != "netem" {
+			continue
+		}
+		if err := netlink.QdiscDel(qdisc); err != nil {
+			return fmt.Errorf("failed to remove netem from %s: %w", tapName, err)
+		}
+		logger.Printf("Cleared link settings on %s", tapName)
+	}
+
+	return nil
+}
+
+// ChaosTarget is a VM interface affected by "network chaos"
+type ChaosTarget struct {
+	VM      string
+	Network string
+	TAP     string
+}
+
+// resolveChaosTargets returns the TAP-backed interfaces of VMs or networks
+// Each name is a VM (all its bridge interfaces) or a network (the interfaces of all its VMs)
+func resolveChaosTargets(names []string, config *ComposeConfig) ([]ChaosTarget, error) {
+	var targets []ChaosTarget
+	seen := make(map[string]bool)
+
+	addTarget := func(vmName string, networkIndex int, networkName string) {
+		network := config.Networks[networkName]
+		if getNetworkDriver(network) != "bridge" {
+			return
+		}
+		tapName := getTAPName(vmName, networkIndex)
+		if seen[tapName] {
+			return
+		}
+		seen[tapName] = true
+		targets = append(targets, ChaosTarget{VM: vmName, Network: networkName, TAP: tapName})
+	}
+
+	for _, name := range names {
+		vm, isVM := config.VMs[name]
+		_, isNetwork := config.Networks[name]
+
+		switch {
+		case isVM && isNetwork:
+			return nil, fmt.Errorf("%s is both a VM and a network", name)
+		case isVM:
+			for i, networkName := range getVMNetworkNames(vm) {
+				addTarget(name, i, networkName)
+			}
+		case isNetwork:
+			for _, vmName := range getSortedVMNames(config) {
+				for i, networkName := range getVMNetworkNames(config.VMs[vmName]) {
+					if networkName == name {
+						addTarget(vmName, i, networkName)
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("no VM or network named %s", name)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no TAP interface found for %s (only bridge networks support link settings)", strings.Join(names, ", "))
+	}
+
+	return targets, nil
+}
+
+// recordLinkSettings records the link settings set by "network chaos" for a VM on a network
+// Empty settings remove the entry, so the network's link settings apply again
+func recordLinkSettings(networkName, vmName string, settings LinkSettings) error {
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return err
+	}
+
+	netMeta := metadata[networkName]
+	if settings.isEmpty() {
+		delete(netMeta.Chaos, vmName)
+	} else {
+		if netMeta.Chaos == nil {
+			netMeta.Chaos = make(map[string]LinkSettings)
+		}
+		netMeta.Chaos[vmName] = settings
+	}
+	metadata[networkName] = netMeta
+
+	return saveNetworkMetadata(metadata)
+}
+
+// getEffectiveLinkSettings returns the link settings of a VM interface:
+// those set by "network chaos" if any, otherwise the link settings of the network
+func getEffectiveLinkSettings(networkName, vmName string, config *ComposeConfig) LinkSettings {
+	if metadata, err := loadNetworkMetadata(); err == nil {
+		if settings, exists := metadata[networkName].Chaos[vmName]; exists {
+			return settings
+		}
+	}
+
+	if link := config.Networks[networkName].Link; link != nil {
+		return *link
+	}
+	return LinkSettings{}
+}
+
+// getPartitionChainName returns the ebtables chain holding the partition rules of a bridge
+func getPartitionChainName(bridgeName string) string {
+	return "qemu-compose-" + bridgeName
+}
+
+// getPartitionRules returns the ebtables rules of a network partition: frames are dropped between
+// the interfaces of VMs in different partitions, VMs without partition being the rest of the network
+// The host (DHCP, DNS, NAT) stays reachable from every partition
+func getPartitionRules(networkName string, config *ComposeConfig) [][]string {
+	type member struct {
+		tap       string
+		partition string
+	}
+
+	var members []member
+	hasPartition := false
+	for _, vmName := range getSortedVMNames(config) {
+		for i, name := range getVMNetworkNames(config.VMs[vmName]) {
+			if name != networkName {
+				continue
+			}
+			partition := getEffectiveLinkSettings(networkName, vmName, config).Partition
+			hasPartition = hasPartition || partition != ""
+			members = append(members, member{tap: getTAPName(vmName, i), partition: partition})
+		}
+	}
+
+	if !hasPartition {
+		return nil
+	}
+
+	var rules [][]string
+	for _, from := range members {
+		for _, to := range members {
+			if from.partition != to.partition {
+				rules = append(rules, []string{"-i", from.tap, "-o", to.tap, "-j", "DROP"})
+			}
+		}
+	}
+	return rules
+}
+
+// setupPartitionRules applies the partitions of a bridge network with ebtables: the rules are
+// rebuilt in a chain of the bridge, jumped to from FORWARD. Without partition, the chain is removed
+func setupPartitionRules(networkName string, config *ComposeConfig) error {
+	bridgeName := resolveBridgeName(networkName, config.Networks[networkName])
+	rules := getPartitionRules(networkName, config)
+	if len(rules) == 0 {
+		cleanupPartitionRules(bridgeName)
+		return nil
+	}
+
+	chain := getPartitionChainName(bridgeName)
+	logger.Printf("Setting up %d partition rule(s) for network %s (chain: %s)", len(rules), networkName, chain)
+
+	if err := exec.Command("sudo", "ebtables", "-t", "filter", "-L", chain).Run(); err != nil {
+		if output, err := exec.Command("sudo", "ebtables", "-t", "filter", "-N", chain, "-P", "RETURN").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create ebtables chain %s: %w\nOutput: %s", chain, err, string(output))
+		}
+	}
+
+	if output, err := exec.Command("sudo", "ebtables", "-t", "filter", "-F", chain).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to flush ebtables chain %s: %w\nOutput: %s", chain, err, string(output))
+	}
+
+	for _, rule := range rules {
+		args := append([]string{"ebtables", "-t", "filter", "-A", chain}, rule...)
+		if output, err := exec.Command("sudo", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add partition rule %s: %w\nOutput: %s", strings.Join(rule, " "), err, string(output))
+		}
+	}
+
+	if !hasPartitionJump(bridgeName) {
+		jump := []string{"ebtables", "-t", "filter", "-A", "FORWARD", "--logical-in", bridgeName, "-j", chain}
+		if output, err := exec.Command("sudo", jump...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add ebtables jump to %s: %w\nOutput: %s", chain, err, string(output))
+		}
+	}
+
+	return nil
+}
+
+// hasPartitionJump returns true if the FORWARD chain jumps to the partition chain of a bridge
+func hasPartitionJump(bridgeName string) bool {
+	output, err := exec.Command("sudo", "ebtables", "-t", "filter", "-L", "FORWARD").Output()
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[len(fields)-1] == getPartitionChainName(bridgeName) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupPartitionRules removes the partition chain of a bridge and its jump, if any
+func cleanupPartitionRules(bridgeName string) {
+	chain := getPartitionChainName(bridgeName)
+	if err := exec.Command("sudo", "ebtables", "-t", "filter", "-L", chain).Run(); err != nil {
+		return
+	}
+
+	logger.Printf("Removing partition chain %s", chain)
+	for hasPartitionJump(bridgeName) {
+		jump := []string{"ebtables", "-t", "filter", "-D", "FORWARD", "--logical-in", bridgeName, "-j", chain}
+		if output, err := exec.Command("sudo", jump...).CombinedOutput(); err != nil {
+			logger.Printf("Warning: failed to remove ebtables jump to %s: %v\nOutput: %s", chain, err, string(output))
+			break
+		}
+	}
+
+	if output, err := exec.Command("sudo", "ebtables", "-t", "filter", "-X", chain).CombinedOutput(); err != nil {
+		logger.Printf("Warning: failed to remove ebtables chain %s: %v\nOutput: %s", chain, err, string(output))
+	}
+}
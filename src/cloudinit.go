@@ -106,12 +106,19 @@ func getProjectSSHPublicKey() (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
+// CloudInitInterface describes a VM network interface for cloud-init network-config
+type CloudInitInterface struct {
+	MAC   string
+	MTU   int  // Interface MTU, 0 keeps the DHCP/driver default
+	DHCP6 bool // Also configure the interface with DHCPv6/RA
+}
+
 // CloudInitData holds all data needed for cloud-init template rendering
 type CloudInitData struct {
 	VMName       string
 	OSUser       string
 	SSHPublicKey string
-	MACAddresses []string
+	Interfaces   []CloudInitInterface
 	VolumeMounts []VMVolumeMount
 	Has9pMounts  bool
 }
@@ -142,18 +149,24 @@ chpasswd:
   list: |
     {{.OSUser}}:password
 ssh_pwauth: true
-{{- if .MACAddresses}}
+{{- if .Interfaces}}
 network:
   version: 2
   ethernets:
-{{- range $i, $mac := .MACAddresses}}
+{{- range $i, $iface := .Interfaces}}
     net{{$i}}:
       match:
-        macaddress: "{{$mac}}"
+        macaddress: "{{$iface.MAC}}"
       dhcp4: true
+{{- if $iface.DHCP6}}
+      dhcp6: true
+{{- end}}{{/* if $iface.DHCP6 */}}
+{{- if $iface.MTU}}
+      mtu: {{$iface.MTU}}
+{{- end}}{{/* if $iface.MTU */}}
       set-name: net{{$i}}
-{{- end}}{{/* range .MACAddresses */}}
-{{- end}}{{/* if .MACAddresses */}}
+{{- end}}{{/* range .Interfaces */}}
+{{- end}}{{/* if .Interfaces */}}
 {{- if .VolumeMounts}}
 {{- if .Has9pMounts}}
 packages:
@@ -183,7 +196,7 @@ mounts:
 {{- end}}{{/* if .VolumeMounts */}}`
 
 // generateCloudInitISOWithVolumes creates a cloud-init NoCloud ISO with user-data, meta-data, and volume mounts
-func generateCloudInitISOWithVolumes(vmName string, imageURL string, macAddresses []string, volumeMounts []VMVolumeMount) (string, error) {
+func generateCloudInitISOWithVolumes(vmName string, imageURL string, interfaces []CloudInitInterface, volumeMounts []VMVolumeMount) (string, error) {
 	logger.Printf("Generating cloud-init ISO for VM: %s", vmName)
 
 	instanceDir, err := getInstanceDir(vmName)
@@ -214,7 +227,7 @@ func generateCloudInitISOWithVolumes(vmName string, imageURL string, macAddresse
 		VMName:       vmName,
 		OSUser:       defaultUser,
 		SSHPublicKey: sshPublicKey,
-		MACAddresses: macAddresses,
+		Interfaces:   interfaces,
 		VolumeMounts: volumeMounts,
 		Has9pMounts:  has9pMounts(volumeMounts),
 	}
@@ -223,7 +236,7 @@ func generateCloudInitISOWithVolumes(vmName string, imageURL string, macAddresse
 	tmpl, err := template.New("cloud-init").
 		Funcs(template.FuncMap{
 			"indexToLetter": func(i int) string {
-				return string('b' + i)
+				return string(rune('b' + i))
 			},
 			"add": func(a, b int) int {
 				return a + b
@@ -255,22 +268,29 @@ func generateCloudInitISOWithVolumes(vmName string, imageURL string, macAddresse
 	}
 
 	// Create network-config file if we have network configuration
-	if len(macAddresses) > 0 {
+	if len(interfaces) > 0 {
 		networkConfigPath := filepath.Join(cloudInitDir, "network-config")
 		// Build network config YAML
 		var networkConfigBuilder strings.Builder
 		networkConfigBuilder.WriteString("network:\n  version: 2\n  ethernets:\n")
-		for i, macAddr := range macAddresses {
+		for i, iface := range interfaces {
 			ifName := fmt.Sprintf("net%d", i)
-			networkConfigBuilder.WriteString(fmt.Sprintf("    %s:\n      match:\n        macaddress: \"%s\"\n      dhcp4: true\n      set-name: %s\n", ifName, macAddr, ifName))
-			logger.Printf("Added network interface to cloud-init: %s (MAC: %s)", ifName, macAddr)
+			networkConfigBuilder.WriteString(fmt.Sprintf("    %s:\n      match:\n        macaddress: \"%s\"\n      dhcp4: true\n", ifName, iface.MAC))
+			if iface.DHCP6 {
+				networkConfigBuilder.WriteString("      dhcp6: true\n")
+			}
+			if iface.MTU != 0 {
+				networkConfigBuilder.WriteString(fmt.Sprintf("      mtu: %d\n", iface.MTU))
+			}
+			networkConfigBuilder.WriteString(fmt.Sprintf("      set-name: %s\n", ifName))
+			logger.Printf("Added network interface to cloud-init: %s (MAC: %s, MTU: %d, dhcp6: %v)", ifName, iface.MAC, iface.MTU, iface.DHCP6)
 		}
 
 		networkConfigContent := networkConfigBuilder.String()
 		if err := os.WriteFile(networkConfigPath, []byte(networkConfigContent), 0644); err != nil {
 			return "", fmt.Errorf("failed to write network-config: %w", err)
 		}
-		logger.Printf("Created network-config with %d interface(s)", len(macAddresses))
+		logger.Printf("Created network-config with %d interface(s)", len(interfaces))
 	}
 
 	// Create ISO using genisoimage or mkisofs
@@ -278,7 +298,7 @@ func generateCloudInitISOWithVolumes(vmName string, imageURL string, macAddresse
 
 	// Build file list for ISO
 	isoFiles := []string{userDataPath, metaDataPath}
-	if len(macAddresses) > 0 {
+	if len(interfaces) > 0 {
 		networkConfigPath := filepath.Join(cloudInitDir, "network-config")
 		isoFiles = append(isoFiles, networkConfigPath)
 	}
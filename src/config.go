@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 )
 
@@ -15,8 +16,29 @@ type ComposeConfig struct {
 
 // Network represents a network configuration
 type Network struct {
-	Driver string `yaml:"driver"`
-	Subnet string `yaml:"subnet"`
+	Driver     string        `yaml:"driver"` // "bridge" (default), "macvtap", or "user"/"socket" for rootless networking
+	Subnet     string        `yaml:"subnet"`
+	SubnetV6   string        `yaml:"subnet_v6,omitempty"`   // "auto" or IPv6 CIDR (e.g., "fd00:1:2:3::/64")
+	EnableIPv6 bool          `yaml:"enable_ipv6,omitempty"` // Enable dual-stack with an auto-allocated ULA /64
+	External   bool          `yaml:"external,omitempty"`    // Use an existing host bridge, never created or deleted
+	Name       string        `yaml:"name,omitempty"`        // Host bridge name for external networks (default: network key)
+	Parent     string        `yaml:"parent,omitempty"`      // Host interface for macvtap networks (e.g., "eth0")
+	Mode       string        `yaml:"mode,omitempty"`        // macvtap mode: "bridge" (default), "vepa", "private" or "passthru"
+	Connect    []string      `yaml:"connect,omitempty"`     // Allowed paths to other networks: NETWORK[:PORT[/PROTOCOL]]
+	Link       *LinkSettings `yaml:"link,omitempty"`        // Optional: impairments applied to the VM interfaces (netem)
+}
+
+// LinkSettings describes degraded link conditions applied to VM interfaces
+type LinkSettings struct {
+	Delay   string `yaml:"delay,omitempty" json:"delay,omitempty"`     // e.g. "100ms"
+	Jitter  string `yaml:"jitter,omitempty" json:"jitter,omitempty"`   // e.g. "10ms", requires delay
+	Loss    string `yaml:"loss,omitempty" json:"loss,omitempty"`       // e.g. "1%"
+	Corrupt string `yaml:"corrupt,omitempty" json:"corrupt,omitempty"` // e.g. "0.1%"
+	Rate    string `yaml:"rate,omitempty" json:"rate,omitempty"`       // e.g. "10mbit", "512kbit", "1mbps"
+
+	// Partition names the group of VMs the interface can still reach on its network, only set
+	// by "network chaos --partition"
+	Partition string `yaml:"-" json:"partition,omitempty"`
 }
 
 // Volume represents a volume configuration
@@ -26,18 +48,27 @@ type Volume struct {
 
 // VM represents a virtual machine configuration
 type VM struct {
-	Image       string        `yaml:"image"`
-	CPU         int           `yaml:"cpu"`
-	Memory      int           `yaml:"memory"`
-	Networks    []string      `yaml:"networks,omitempty"`
-	Ports       []string      `yaml:"ports,omitempty"`
-	DependsOn   []string      `yaml:"depends_on,omitempty"`
-	Volumes     []VolumeMount `yaml:"volumes,omitempty"`
-	Environment []string      `yaml:"environment,omitempty"`
-	Provision   []Provision   `yaml:"provision,omitempty"`
-	Disk        *Disk         `yaml:"disk,omitempty"`
-	Healthcheck *Healthcheck  `yaml:"healthcheck,omitempty"`
-	SSH         *SSH          `yaml:"ssh,omitempty"`
+	Image           string        `yaml:"image"`
+	CPU             int           `yaml:"cpu"`
+	Memory          int           `yaml:"memory"`
+	Networks        []VMNetwork   `yaml:"networks,omitempty"`
+	Ports           []string      `yaml:"ports,omitempty"`
+	DependsOn       []string      `yaml:"depends_on,omitempty"`
+	Volumes         []VolumeMount `yaml:"volumes,omitempty"`
+	Environment     []string      `yaml:"environment,omitempty"`
+	Provision       []Provision   `yaml:"provision,omitempty"`
+	Disk            *Disk         `yaml:"disk,omitempty"`
+	Healthcheck     *Healthcheck  `yaml:"healthcheck,omitempty"`
+	SSH             *SSH          `yaml:"ssh,omitempty"`
+	Console         *Console      `yaml:"console,omitempty"`
+	ManagementNIC   *bool         `yaml:"management_nic,omitempty"`    // Optional: add the user-mode NIC used for SSH (default: true)
+	StopGracePeriod string        `yaml:"stop_grace_period,omitempty"` // Optional: time to wait for a graceful stop (default: 60s)
+	Restart         string        `yaml:"restart,omitempty"`           // Optional: "no" (default), "on-failure", "always" or "unless-stopped"
+	CPUQuota        string        `yaml:"cpu_quota,omitempty"`         // Optional: host CPU time cap, e.g. "150%" (1.5 CPUs)
+	CPUWeight       int           `yaml:"cpu_weight,omitempty"`        // Optional: CPU share under contention, 1-10000 (systemd default 100)
+	MemoryMax       string        `yaml:"memory_max,omitempty"`        // Optional: hard memory cap of the QEMU process, e.g. "4G"
+	IOWeight        int           `yaml:"io_weight,omitempty"`         // Optional: block IO share under contention, 1-10000 (systemd default 100)
+	TasksMax        int           `yaml:"tasks_max,omitempty"`         // Optional: maximum number of QEMU threads
 }
 
 // VolumeMount represents a volume mount specification
@@ -101,6 +132,118 @@ func (v *VolumeMount) parseShortForm(spec string) error {
 	return nil
 }
 
+// VMNetwork represents the attachment of a VM to a network
+// It can be unmarshaled from either a string (short form: the network name) or a map (long form)
+type VMNetwork struct {
+	Name       string   `yaml:"name"`
+	MACAddress string   `yaml:"mac_address,omitempty"` // Optional: override the generated MAC address
+	Model      string   `yaml:"model,omitempty"`       // Optional: virtio (default), e1000e or rtl8139
+	MTU        int      `yaml:"mtu,omitempty"`         // Optional: interface MTU (default: 1500)
+	Queues     int      `yaml:"queues,omitempty"`      // Optional: virtio-net multiqueue queue pairs (default: 1)
+	Aliases    []string `yaml:"aliases,omitempty"`     // Optional: extra DNS names of the VM on this network
+}
+
+// UnmarshalYAML implements custom unmarshaling for VMNetwork
+// Supports both short form (string) and long form (map)
+func (n *VMNetwork) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// Try to unmarshal as string (short form)
+	var shortForm string
+	if err := unmarshal(&shortForm); err == nil {
+		*n = VMNetwork{Name: shortForm}
+		return nil
+	}
+
+	// Try to unmarshal as map (long form)
+	type vmNetworkAlias VMNetwork
+	var longForm vmNetworkAlias
+	if err := unmarshal(&longForm); err != nil {
+		return err
+	}
+
+	if longForm.Name == "" {
+		return fmt.Errorf("network entry requires a name")
+	}
+
+	*n = VMNetwork(longForm)
+	return nil
+}
+
+// getVMNetworkNames returns the names of the networks a VM is attached to, in interface order
+func getVMNetworkNames(vm VM) []string {
+	names := make([]string, 0, len(vm.Networks))
+	for _, vmNetwork := range vm.Networks {
+		names = append(names, vmNetwork.Name)
+	}
+	return names
+}
+
+// getSortedVMNames returns the names of the VMs of a project in alphabetical order
+func getSortedVMNames(config *ComposeConfig) []string {
+	names := make([]string, 0, len(config.VMs))
+	for vmName := range config.VMs {
+		names = append(names, vmName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getVMStartOrder sorts VMs so that each VM comes after the VMs it depends on (depends_on)
+// Only the given VMs are ordered, dependencies outside of them are not added
+func getVMStartOrder(config *ComposeConfig, vmNames []string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, vmName := range vmNames {
+		selected[vmName] = true
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var order []string
+
+	var visit func(vmName string, path []string) error
+	visit = func(vmName string, path []string) error {
+		switch state[vmName] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("circular depends_on: %s", strings.Join(append(path, vmName), " -> "))
+		}
+
+		vm, exists := config.VMs[vmName]
+		if !exists && len(path) == 0 {
+			return fmt.Errorf("VM not found in compose file: %s", vmName)
+		}
+		if !exists {
+			return fmt.Errorf("VM %s depends on unknown VM: %s", path[len(path)-1], vmName)
+		}
+
+		state[vmName] = visiting
+		for _, dependency := range vm.DependsOn {
+			if err := visit(dependency, append(path, vmName)); err != nil {
+				return err
+			}
+		}
+		state[vmName] = visited
+
+		if selected[vmName] {
+			order = append(order, vmName)
+		}
+		return nil
+	}
+
+	sorted := append([]string(nil), vmNames...)
+	sort.Strings(sorted)
+	for _, vmName := range sorted {
+		if err := visit(vmName, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
 // Provision represents provisioning configuration
 type Provision struct {
 	Type   string `yaml:"type"`
@@ -122,5 +265,11 @@ type Healthcheck struct {
 
 // SSH represents SSH configuration
 type SSH struct {
-	Port int `yaml:"port,omitempty"` // Optional: manual port override
+	Port int    `yaml:"port,omitempty"` // Optional: manual port override
+	Via  string `yaml:"via,omitempty"`  // Optional: "user" (default, user-mode NIC) or "bridge" (VM address, no user-mode NIC)
+}
+
+// Console represents serial console configuration
+type Console struct {
+	Record bool `yaml:"record,omitempty"` // Optional: record console sessions as asciicast files in .qemu-compose/<vm-name>/recordings
 }
@@ -6,51 +6,132 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/term"
 )
 
-// attachToConsole attaches to a VM's serial console via Unix socket
-func attachToConsole(vmName string) error {
-	logger.Printf("Attaching to console for VM: %s", vmName)
+// defaultConsoleEscape is the detach key of the console
+const defaultConsoleEscape = "ctrl-]"
+
+// consoleNoTTYIdle is how long the console keeps printing output after stdin ends in --no-tty mode
+const consoleNoTTYIdle = 2 * time.Second
+
+// ConsoleOptions contains options for attaching to a console
+type ConsoleOptions struct {
+	Escape int    // Detach key, -1 to disable
+	NoTTY  bool   // Stdin is not a terminal: no raw mode, stdin is sent as is
+	Record string // Asciicast file to record the console output to (empty: no recording)
+}
+
+// parseConsoleEscape parses a detach key: ctrl-<key> or ^<key> (ctrl-], ^a), or none
+func parseConsoleEscape(value string) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "none" {
+		return -1, nil
+	}
+
+	var key string
+	switch {
+	case strings.HasPrefix(value, "ctrl-"):
+		key = strings.TrimPrefix(value, "ctrl-")
+	case strings.HasPrefix(value, "ctrl+"):
+		key = strings.TrimPrefix(value, "ctrl+")
+	case strings.HasPrefix(value, "^"):
+		key = strings.TrimPrefix(value, "^")
+	}
 
+	// Control characters are the keys from @ to _ with the upper bits cleared
+	if len(key) != 1 || strings.ToUpper(key)[0] < '@' || strings.ToUpper(key)[0] > '_' {
+		return 0, fmt.Errorf("invalid escape key: %s (expected ctrl-<key> such as ctrl-], ^a, or none)", value)
+	}
+	return int(strings.ToUpper(key)[0] & 0x1f), nil
+}
+
+// formatConsoleEscape returns the name of a detach key, as shown to the user
+func formatConsoleEscape(escape int) string {
+	if escape < 0 {
+		return "none"
+	}
+	return fmt.Sprintf("Ctrl+%c", byte(escape)|0x40)
+}
+
+// activityWriter signals every write on a channel
+type activityWriter struct {
+	w        io.Writer
+	activity chan struct{}
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	select {
+	case a.activity <- struct{}{}:
+	default:
+	}
+	return a.w.Write(p)
+}
+
+// dialConsole connects to the console socket of a running VM
+// Without replay, the live socket is used: only output printed after connecting is received
+func dialConsole(vmName string, replay bool) (net.Conn, error) {
 	// Check if VM is running
 	running, err := isVMRunning(vmName)
 	if err != nil {
-		return fmt.Errorf("failed to check VM status: %w", err)
+		return nil, fmt.Errorf("failed to check VM status: %w", err)
 	}
 
 	if !running {
-		return fmt.Errorf("VM is not running: %s", vmName)
+		return nil, fmt.Errorf("VM is not running: %s", vmName)
 	}
 
 	// Get console socket path
 	socketPath := getConsoleSocketPath(vmName)
+	if !replay {
+		socketPath = getConsoleLiveSocketPath(vmName)
+	}
 
 	// Check if socket exists
 	if _, err := os.Stat(socketPath); os.IsNotExist(err) {
-		return fmt.Errorf("console socket not found: %s (VM may still be starting)", socketPath)
+		return nil, fmt.Errorf("console socket not found: %s (VM may still be starting, or was started by an older version: restart it)", socketPath)
 	}
 
 	// Connect to Unix socket
 	conn, err := net.Dial("unix", socketPath)
 	if err != nil {
-		return fmt.Errorf("failed to connect to console socket: %w", err)
+		return nil, fmt.Errorf("failed to connect to console socket: %w", err)
 	}
-	defer conn.Close()
 
 	logger.Printf("Connected to console socket: %s", socketPath)
+	return conn, nil
+}
 
-	fmt.Printf("Connected to VM console: %s\n", vmName)
-	fmt.Printf("Press Ctrl+] to detach\n\n")
+// attachToConsole attaches to a VM's serial console via Unix socket
+func attachToConsole(vmName string, options ConsoleOptions) error {
+	logger.Printf("Attaching to console for VM: %s (escape: %s, no-tty: %v, record: %s)", vmName, formatConsoleEscape(options.Escape), options.NoTTY, options.Record)
 
-	// Put terminal in raw mode
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if !options.NoTTY && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("stdin is not a terminal, use --no-tty to send piped input to the console")
+	}
+
+	// Piped input is meant for what the console prints next, not for the replayed scrollback
+	conn, err := dialConsole(vmName, !options.NoTTY)
 	if err != nil {
-		return fmt.Errorf("failed to set terminal to raw mode: %w", err)
+		return err
+	}
+	defer conn.Close()
+
+	// Record what is copied to stdout
+	var stdout io.Writer = os.Stdout
+	if options.Record != "" {
+		recording, err := createAsciicast(options.Record, fmt.Sprintf("qemu-compose console %s", vmName))
+		if err != nil {
+			return err
+		}
+		defer recording.Close()
+		stdout = io.MultiWriter(os.Stdout, recording)
+		fmt.Fprintf(os.Stderr, "Recording console to: %s\n", options.Record)
 	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState)
 
 	// Handle Ctrl+C gracefully
 	sigCh := make(chan os.Signal, 1)
@@ -59,17 +140,54 @@ func attachToConsole(vmName string) error {
 	// Channel to signal completion
 	done := make(chan error, 1)
 
+	if options.NoTTY {
+		// Keep stdout for the console output
+		fmt.Fprintf(os.Stderr, "Connected to VM console: %s\n", vmName)
+		attachConsoleNoTTY(conn, stdout, done)
+	} else {
+		fmt.Printf("Connected to VM console: %s\n", vmName)
+		if options.Escape >= 0 {
+			fmt.Printf("Press %s to detach\n\n", formatConsoleEscape(options.Escape))
+		} else {
+			fmt.Printf("No detach key, stop with: kill %d\n\n", os.Getpid())
+		}
+
+		// Put terminal in raw mode
+		oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return fmt.Errorf("failed to set terminal to raw mode: %w", err)
+		}
+		defer term.Restore(int(os.Stdin.Fd()), oldState)
+
+		attachConsoleTTY(conn, stdout, options.Escape, done)
+	}
+
+	// Wait for completion or signal
+	select {
+	case err := <-done:
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("console error: %w", err)
+		}
+		return nil
+	case <-sigCh:
+		if !options.NoTTY {
+			fmt.Println("\nDetaching from console...")
+		}
+		return nil
+	}
+}
+
+// attachConsoleTTY copies between the console and a raw terminal until the escape key is pressed
+func attachConsoleTTY(conn net.Conn, stdout io.Writer, escape int, done chan<- error) {
 	// Copy from socket to stdout
 	go func() {
-		_, err := io.Copy(os.Stdout, conn)
+		_, err := io.Copy(stdout, conn)
 		done <- err
 	}()
 
-	// Copy from stdin to socket with escape sequence detection
+	// Copy from stdin to socket with escape key detection
 	go func() {
 		buf := make([]byte, 1)
-		escapeCount := 0
-		const escapeChar = 29 // Ctrl+]
 
 		for {
 			n, err := os.Stdin.Read(buf)
@@ -79,16 +197,10 @@ func attachToConsole(vmName string) error {
 			}
 
 			if n > 0 {
-				// Check for escape sequence (Ctrl+])
-				if buf[0] == escapeChar {
-					escapeCount++
-					if escapeCount >= 1 {
-						fmt.Println("\nDetaching from console...")
-						done <- nil
-						return
-					}
-				} else {
-					escapeCount = 0
+				if escape >= 0 && int(buf[0]) == escape {
+					fmt.Println("\nDetaching from console...")
+					done <- nil
+					return
 				}
 
 				// Write to socket
@@ -99,16 +211,33 @@ func attachToConsole(vmName string) error {
 			}
 		}
 	}()
+}
 
-	// Wait for completion or signal
-	select {
-	case err := <-done:
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("console error: %w", err)
+// attachConsoleNoTTY sends stdin to the console as is, then prints the output until it is idle
+func attachConsoleNoTTY(conn net.Conn, stdout io.Writer, done chan<- error) {
+	activity := make(chan struct{}, 1)
+
+	// Copy from socket to stdout
+	go func() {
+		_, err := io.Copy(&activityWriter{w: stdout, activity: activity}, conn)
+		done <- err
+	}()
+
+	// Copy from stdin to socket, then wait for the answers to the last input
+	go func() {
+		if _, err := io.Copy(conn, os.Stdin); err != nil {
+			done <- err
+			return
 		}
-		return nil
-	case <-sigCh:
-		fmt.Println("\nDetaching from console...")
-		return nil
-	}
+		logger.Printf("End of stdin, waiting until the console is idle for %s", consoleNoTTYIdle)
+
+		for {
+			select {
+			case <-activity:
+			case <-time.After(consoleNoTTYIdle):
+				done <- nil
+				return
+			}
+		}
+	}()
 }
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// consoleScrollback is how much recent console output the multiplexer replays to new clients
+const consoleScrollback = 64 * 1024
+
+// consoleClientQueue is how many pending output chunks a client may lag behind before it is dropped
+const consoleClientQueue = 256
+
+// consoleInputBusyNotice is sent to a client typing while another client holds the console input
+const consoleInputBusyNotice = "\r\n[qemu-compose: console input is held by another client, output only]\r\n"
+
+// consoleRing keeps the most recent console output
+type consoleRing struct {
+	data []byte
+	size int
+}
+
+// Write appends output, dropping the oldest bytes beyond the ring size
+func (r *consoleRing) Write(p []byte) {
+	r.data = append(r.data, p...)
+	if len(r.data) > r.size {
+		r.data = append([]byte(nil), r.data[len(r.data)-r.size:]...)
+	}
+}
+
+// Bytes returns a copy of the kept output
+func (r *consoleRing) Bytes() []byte {
+	return append([]byte(nil), r.data...)
+}
+
+// consoleClient is a connection attached to the console multiplexer
+type consoleClient struct {
+	conn     net.Conn
+	output   chan []byte
+	notified bool // Already told that another client holds the input
+}
+
+// consoleMux shares the serial console of a VM between several clients
+// All clients receive the output, replayed from the scrollback on attach to the console socket
+// (clients of the live socket only get the output that follows); the first client typing
+// holds the input until it detaches, input of the other clients is dropped
+type consoleMux struct {
+	mu      sync.Mutex
+	serial  net.Conn
+	ring    consoleRing
+	clients map[*consoleClient]bool
+	writer  *consoleClient
+}
+
+// getSerialSocketPath returns the path of the QEMU serial socket of a VM, served by the console multiplexer
+func getSerialSocketPath(vmName string) string {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		// Fallback to /tmp if we can't get instance dir
+		return fmt.Sprintf("/tmp/qemu-compose-%s-serial.sock", vmName)
+	}
+	return filepath.Join(instanceDir, "serial.sock")
+}
+
+// getConsoleLiveSocketPath returns the path of the console socket without scrollback replay
+func getConsoleLiveSocketPath(vmName string) string {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		// Fallback to /tmp if we can't get instance dir
+		return fmt.Sprintf("/tmp/qemu-compose-%s-console-live.sock", vmName)
+	}
+	return filepath.Join(instanceDir, "console-live.sock")
+}
+
+// getConsoleMuxCommand wraps a QEMU command in the console multiplexer of a VM
+// The multiplexer is the main process of the VM unit and exits with the exit code of QEMU
+func getConsoleMuxCommand(vmName string, qemuArgs []string) ([]string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine executable path: %w", err)
+	}
+
+	// The unit environment may have another PATH
+	qemuArgs = append([]string(nil), qemuArgs...)
+	if path, err := exec.LookPath(qemuArgs[0]); err == nil {
+		qemuArgs[0] = path
+	}
+
+	args := []string{execPath}
+	if debug {
+		args = append(args, "--debug")
+	}
+	args = append(args, "console-mux",
+		"--serial", getSerialSocketPath(vmName),
+		"--socket", getConsoleSocketPath(vmName),
+		"--live-socket", getConsoleLiveSocketPath(vmName),
+		"--",
+	)
+	return append(args, qemuArgs...), nil
+}
+
+// runConsoleMux runs QEMU and shares its serial socket on socketPath until QEMU exits
+// Clients of liveSocketPath, if not empty, get no scrollback replay
+// Returns the exit code of QEMU
+func runConsoleMux(serialPath string, socketPath string, liveSocketPath string, qemuArgs []string) (int, error) {
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return 1, fmt.Errorf("failed to listen on console socket: %w", err)
+	}
+	defer os.Remove(socketPath)
+	defer listener.Close()
+
+	var liveListener net.Listener
+	if liveSocketPath != "" {
+		os.Remove(liveSocketPath)
+		liveListener, err = net.Listen("unix", liveSocketPath)
+		if err != nil {
+			return 1, fmt.Errorf("failed to listen on live console socket: %w", err)
+		}
+		defer os.Remove(liveSocketPath)
+		defer liveListener.Close()
+	}
+
+	qemu := exec.Command(qemuArgs[0], qemuArgs[1:]...)
+	qemu.Stdout = os.Stdout
+	qemu.Stderr = os.Stderr
+	if err := qemu.Start(); err != nil {
+		return 1, fmt.Errorf("failed to start QEMU: %w", err)
+	}
+	logger.Printf("Started QEMU (pid %d)", qemu.Process.Pid)
+
+	// systemd stops the unit by signaling its main process: pass the signal on to QEMU
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			logger.Printf("Received %s, forwarding to QEMU", sig)
+			qemu.Process.Signal(sig)
+		}
+	}()
+
+	var waitErr error
+	done := make(chan struct{})
+	go func() {
+		waitErr = qemu.Wait()
+		close(done)
+	}()
+
+	mux := &consoleMux{
+		ring:    consoleRing{size: consoleScrollback},
+		clients: make(map[*consoleClient]bool),
+	}
+
+	// QEMU creates the serial socket shortly after starting
+	go func() {
+		serial, err := dialSerialSocket(serialPath, done)
+		if err != nil {
+			logger.Printf("Warning: console multiplexer not connected: %v", err)
+			return
+		}
+		mux.serve(serial, listener, liveListener)
+	}()
+
+	<-done
+	signal.Stop(signals)
+	close(signals)
+	listener.Close()
+	if liveListener != nil {
+		liveListener.Close()
+	}
+	mux.close()
+
+	if waitErr == nil {
+		return 0, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exitErr.ExitCode(), nil
+	}
+	return 1, waitErr
+}
+
+// dialSerialSocket connects to the serial socket of QEMU, retrying until it exists or QEMU exits
+func dialSerialSocket(serialPath string, done <-chan struct{}) (net.Conn, error) {
+	for {
+		conn, err := net.Dial("unix", serialPath)
+		if err == nil {
+			logger.Printf("Connected to serial socket: %s", serialPath)
+			return conn, nil
+		}
+
+		select {
+		case <-done:
+			return nil, fmt.Errorf("QEMU exited before its serial socket was ready")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// serve copies the serial output to the clients and accepts clients until the listeners are closed
+// liveListener may be nil
+func (m *consoleMux) serve(serial net.Conn, listener net.Listener, liveListener net.Listener) {
+	m.mu.Lock()
+	m.serial = serial
+	m.mu.Unlock()
+
+	go m.readSerial()
+
+	if liveListener != nil {
+		go m.accept(liveListener, false)
+	}
+	m.accept(listener, true)
+}
+
+// accept attaches the clients of a listener until it is closed
+func (m *consoleMux) accept(listener net.Listener, replay bool) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		m.attach(conn, replay)
+	}
+}
+
+// readSerial keeps and broadcasts the serial output until QEMU closes the socket
+func (m *consoleMux) readSerial() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := m.serial.Read(buf)
+		if n > 0 {
+			chunk := append([]byte(nil), buf[:n]...)
+
+			m.mu.Lock()
+			m.ring.Write(chunk)
+			for client := range m.clients {
+				select {
+				case client.output <- chunk:
+				default:
+					// A client too slow to keep up would hold the console back
+					logger.Printf("Dropping slow console client")
+					m.detachLocked(client)
+				}
+			}
+			m.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// attach registers a client, replays the scrollback to it if replay is set and starts copying its input
+func (m *consoleMux) attach(conn net.Conn, replay bool) {
+	client := &consoleClient{conn: conn, output: make(chan []byte, consoleClientQueue)}
+
+	m.mu.Lock()
+	if scrollback := m.ring.Bytes(); replay && len(scrollback) > 0 {
+		client.output <- scrollback
+	}
+	m.clients[client] = true
+	logger.Printf("Console client attached (%d attached)", len(m.clients))
+	m.mu.Unlock()
+
+	go func() {
+		for chunk := range client.output {
+			if _, err := conn.Write(chunk); err != nil {
+				m.detach(client)
+				return
+			}
+		}
+	}()
+
+	go m.readClient(client)
+}
+
+// readClient forwards the input of a client to the serial console while it holds the input
+func (m *consoleMux) readClient(client *consoleClient) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := client.conn.Read(buf)
+		if n > 0 {
+			m.mu.Lock()
+			// A detached client (e.g. dropped for being slow) has a closed output channel
+			if !m.clients[client] {
+				m.mu.Unlock()
+				return
+			}
+			if m.writer == nil {
+				m.writer = client
+			}
+			if m.writer == client {
+				if _, err := m.serial.Write(buf[:n]); err != nil {
+					logger.Printf("Warning: failed to write to serial console: %v", err)
+				}
+			} else if !client.notified {
+				client.notified = true
+				select {
+				case client.output <- []byte(consoleInputBusyNotice):
+				default:
+				}
+			}
+			m.mu.Unlock()
+		}
+		if err != nil {
+			m.detach(client)
+			return
+		}
+	}
+}
+
+// detach unregisters a client and releases the input if it held it
+func (m *consoleMux) detach(client *consoleClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.detachLocked(client)
+}
+
+// detachLocked is detach with the lock held
+func (m *consoleMux) detachLocked(client *consoleClient) {
+	if !m.clients[client] {
+		return
+	}
+	delete(m.clients, client)
+	close(client.output)
+	client.conn.Close()
+	if m.writer == client {
+		m.writer = nil
+	}
+	logger.Printf("Console client detached (%d attached)", len(m.clients))
+}
+
+// close detaches all clients and closes the serial socket
+func (m *consoleMux) close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for client := range m.clients {
+		m.detachLocked(client)
+	}
+	if m.serial != nil {
+		m.serial.Close()
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConsoleScriptTimeout is how long a step waits for its expected output
+const defaultConsoleScriptTimeout = 60 * time.Second
+
+// Exit codes of a console script
+const (
+	consoleScriptExitMatched = 0 // All expected outputs were seen
+	consoleScriptExitError   = 1 // The script could not run
+	consoleScriptExitTimeout = 2 // An expected output was not seen in time
+	consoleScriptExitClosed  = 3 // The console closed (VM stopped) before an expected output
+)
+
+// ConsoleScript is a sequence of steps run on the serial console of a VM
+type ConsoleScript struct {
+	Timeout   string              `yaml:"timeout,omitempty"`    // Default timeout of the steps
+	FromStart bool                `yaml:"from_start,omitempty"` // Also match the scrollback replayed on attach
+	Steps     []ConsoleScriptStep `yaml:"steps"`
+}
+
+// ConsoleScriptStep waits for an output, then sends an input
+type ConsoleScriptStep struct {
+	Expect  string `yaml:"expect,omitempty"`  // Text to wait for
+	Send    string `yaml:"send,omitempty"`    // Text to send once the expected text was seen
+	Timeout string `yaml:"timeout,omitempty"` // Overrides the script timeout for this step
+}
+
+// unescapeConsoleInput interprets Go escape sequences (\n, \r, \t, \x1b, \\) in a command line value
+func unescapeConsoleInput(value string) (string, error) {
+	var result strings.Builder
+	for rest := value; len(rest) > 0; {
+		r, multibyte, tail, err := strconv.UnquoteChar(rest, 0)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape sequence in %q", value)
+		}
+		if multibyte {
+			result.WriteRune(r)
+		} else {
+			result.WriteByte(byte(r))
+		}
+		rest = tail
+	}
+	return result.String(), nil
+}
+
+// newConsoleScript builds a script from --expect and --send values: the n-th send is sent once the
+// n-th expect is seen, extra sends are sent without waiting
+func newConsoleScript(expects []string, sends []string, timeout time.Duration) (*ConsoleScript, error) {
+	script := &ConsoleScript{Timeout: timeout.String()}
+
+	for i := 0; i < len(expects) || i < len(sends); i++ {
+		var step ConsoleScriptStep
+		if i < len(expects) {
+			expect, err := unescapeConsoleInput(expects[i])
+			if err != nil {
+				return nil, err
+			}
+			step.Expect = expect
+		}
+		if i < len(sends) {
+			send, err := unescapeConsoleInput(sends[i])
+			if err != nil {
+				return nil, err
+			}
+			step.Send = send
+		}
+		script.Steps = append(script.Steps, step)
+	}
+
+	return script, nil
+}
+
+// loadConsoleScript reads a console script file
+// YAML strings are used as is: write "root\n" in double quotes to send a newline
+func loadConsoleScript(path string) (*ConsoleScript, error) {
+	logger.Printf("Loading console script: %s", path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read console script: %w", err)
+	}
+
+	var script ConsoleScript
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse console script: %w", err)
+	}
+
+	return &script, nil
+}
+
+// getStepTimeout returns how long a step waits for its expected output
+func (s *ConsoleScript) getStepTimeout(step ConsoleScriptStep) (time.Duration, error) {
+	value := step.Timeout
+	if value == "" {
+		value = s.Timeout
+	}
+	if value == "" {
+		return defaultConsoleScriptTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q (expected a duration like 30s or 2m)", value)
+	}
+	return timeout, nil
+}
+
+// validate checks the steps and timeouts of a script before connecting to the console
+func (s *ConsoleScript) validate() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("console script has no steps")
+	}
+	for i, step := range s.Steps {
+		if step.Expect == "" && step.Send == "" {
+			return fmt.Errorf("step %d of console script has neither expect nor send", i+1)
+		}
+		if _, err := s.getStepTimeout(step); err != nil {
+			return fmt.Errorf("step %d of console script: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// runConsoleScript runs a script on the serial console of a VM, printing the console output
+// Only output printed after connecting is matched, unless the script starts from the scrollback:
+// an old prompt, e.g. of the previous boot, would match otherwise
+// The output is recorded to the asciicast file record, if not empty
+// Returns one of the consoleScriptExit codes
+func runConsoleScript(vmName string, script *ConsoleScript, record string) (int, error) {
+	if err := script.validate(); err != nil {
+		return consoleScriptExitError, err
+	}
+
+	conn, err := dialConsole(vmName, script.FromStart)
+	if err != nil {
+		return consoleScriptExitError, err
+	}
+	defer conn.Close()
+
+	var stdout io.Writer = os.Stdout
+	if record != "" {
+		recording, err := createAsciicast(record, fmt.Sprintf("qemu-compose console %s", vmName))
+		if err != nil {
+			return consoleScriptExitError, err
+		}
+		defer recording.Close()
+		stdout = io.MultiWriter(os.Stdout, recording)
+		fmt.Fprintf(os.Stderr, "Recording console to: %s\n", record)
+	}
+
+	// Copy from socket to stdout, and to the matcher
+	output := make(chan []byte, consoleClientQueue)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				chunk := append([]byte(nil), buf[:n]...)
+				stdout.Write(chunk)
+				output <- chunk
+			}
+			if err != nil {
+				close(output)
+				return
+			}
+		}
+	}()
+
+	var pending []byte
+	for i, step := range script.Steps {
+		if step.Expect != "" {
+			timeout, _ := script.getStepTimeout(step)
+			code, rest, err := waitForConsoleOutput(output, pending, step.Expect, timeout)
+			if err != nil {
+				return code, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			pending = rest
+			fmt.Fprintf(os.Stderr, "✓ Matched: %q\n", step.Expect)
+		}
+
+		if step.Send != "" {
+			logger.Printf("Step %d: sending %q", i+1, step.Send)
+			if _, err := conn.Write([]byte(step.Send)); err != nil {
+				return consoleScriptExitError, fmt.Errorf("step %d: failed to write to console: %w", i+1, err)
+			}
+		}
+	}
+
+	return consoleScriptExitMatched, nil
+}
+
+// waitForConsoleOutput reads the console output until it contains expect
+// Returns the output following the match, to be matched by the next step
+func waitForConsoleOutput(output <-chan []byte, pending []byte, expect string, timeout time.Duration) (int, []byte, error) {
+	logger.Printf("Waiting up to %s for %q", timeout, expect)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		if index := bytes.Index(pending, []byte(expect)); index >= 0 {
+			return consoleScriptExitMatched, pending[index+len(expect):], nil
+		}
+
+		// The console multiplexer drops input while another client holds it
+		if bytes.Contains(pending, []byte(consoleInputBusyNotice)) {
+			return consoleScriptExitError, nil, fmt.Errorf("console input is held by another client")
+		}
+
+		// Keep what may still be the start of a match
+		if len(pending) > consoleScrollback {
+			pending = pending[len(pending)-consoleScrollback:]
+		}
+
+		select {
+		case chunk, ok := <-output:
+			if !ok {
+				return consoleScriptExitClosed, nil, fmt.Errorf("console closed while waiting for %q", expect)
+			}
+			pending = append(pending, chunk...)
+		case <-timer.C:
+			return consoleScriptExitTimeout, nil, fmt.Errorf("timed out after %s waiting for %q", timeout, expect)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// managedBridgePattern matches the bridges of all qemu-compose networks in iptables interface options
+const managedBridgePattern = "qc-+"
+
+// NetworkConnection is an allowed path from a network to another one: NETWORK[:PORT[/PROTOCOL]]
+// Port 0 allows all traffic to the target network
+type NetworkConnection struct {
+	Network  string
+	Port     int
+	Protocol string // "tcp" or "udp", empty when Port is 0
+}
+
+// parseNetworkConnection parses an entry of a network's connect list
+// Supported forms: "backend", "backend:5432", "backend:53/udp"
+func parseNetworkConnection(spec string) (NetworkConnection, error) {
+	connection := NetworkConnection{}
+
+	target := spec
+	if idx := strings.Index(spec, ":"); idx != -1 {
+		target = spec[:idx]
+		port := spec[idx+1:]
+
+		connection.Protocol = "tcp"
+		if slash := strings.LastIndex(port, "/"); slash != -1 {
+			connection.Protocol = strings.ToLower(port[slash+1:])
+			port = port[:slash]
+			if connection.Protocol != "tcp" && connection.Protocol != "udp" {
+				return connection, fmt.Errorf("invalid connect %q: unsupported protocol %s", spec, connection.Protocol)
+			}
+		}
+
+		var err error
+		if connection.Port, err = parsePortNumber(port); err != nil {
+			return connection, fmt.Errorf("invalid connect %q: %w", spec, err)
+		}
+	}
+
+	if target == "" {
+		return connection, fmt.Errorf("invalid connect %q: expected NETWORK[:PORT[/PROTOCOL]]", spec)
+	}
+	connection.Network = target
+
+	return connection, nil
+}
+
+// getNetworkConnections parses and validates the connect list of a network
+// Both ends must be bridges managed by qemu-compose, other networks are never routed by the host
+func getNetworkConnections(networkName string, config *ComposeConfig) ([]NetworkConnection, error) {
+	network := config.Networks[networkName]
+
+	var connections []NetworkConnection
+	for _, spec := range network.Connect {
+		connection, err := parseNetworkConnection(spec)
+		if err != nil {
+			return nil, fmt.Errorf("network %s: %w", networkName, err)
+		}
+
+		if getNetworkDriver(network) != "bridge" || isExternalNetwork(network) {
+			return nil, fmt.Errorf("network %s: connect is only supported on bridges managed by qemu-compose", networkName)
+		}
+
+		target, exists := config.Networks[connection.Network]
+		if !exists {
+			return nil, fmt.Errorf("network %s: connect target not found in config: %s", networkName, connection.Network)
+		}
+		if connection.Network == networkName {
+			return nil, fmt.Errorf("network %s: cannot connect a network to itself", networkName)
+		}
+		if getNetworkDriver(target) != "bridge" || isExternalNetwork(target) {
+			return nil, fmt.Errorf("network %s: connect target %s is not a bridge managed by qemu-compose", networkName, connection.Network)
+		}
+
+		connections = append(connections, connection)
+	}
+
+	return connections, nil
+}
+
+// forwardRule is a FORWARD rule of a network, in the order iptables -S prints its options
+type forwardRule struct {
+	Spec   []string
+	Append bool // DROP rules go last, ACCEPT rules are inserted first
+}
+
+// getForwardRules returns the FORWARD rules isolating a network from the other qemu-compose networks
+// The network can reach itself, the outside world and its connect targets; replies are always allowed
+func getForwardRules(networkName string, connections []NetworkConnection) []forwardRule {
+	bridgeName := getBridgeName(networkName)
+	ruleComment := getNATRuleComment(bridgeName)
+	comment := []string{"-m", "comment", "--comment", ruleComment}
+
+	var rules []forwardRule
+
+	// Traffic between VMs of the network (seen by iptables when br_netfilter is loaded)
+	rules = append(rules, forwardRule{
+		Spec: append([]string{"-i", bridgeName, "-o", bridgeName}, append(comment, "-j", "ACCEPT")...),
+	})
+
+	// Replies to connections initiated by the network or allowed by a connect rule
+	rules = append(rules, forwardRule{
+		Spec: append([]string{"-o", bridgeName, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED"}, append(comment, "-j", "ACCEPT")...),
+	})
+
+	// Explicitly allowed paths to other networks
+	for _, connection := range connections {
+		spec := []string{"-i", bridgeName, "-o", getBridgeName(connection.Network)}
+		if connection.Port != 0 {
+			spec = append(spec, "-p", connection.Protocol, "-m", connection.Protocol, "--dport", strconv.Itoa(connection.Port))
+		}
+		rules = append(rules, forwardRule{
+			Spec: append(spec, append(comment, "-j", "ACCEPT")...),
+		})
+	}
+
+	// Outside world (NAT)
+	rules = append(rules, forwardRule{
+		Spec: append([]string{"-i", bridgeName, "!", "-o", managedBridgePattern}, append(comment, "-j", "ACCEPT")...),
+	})
+
+	// Everything else to qemu-compose networks is dropped
+	rules = append(rules, forwardRule{
+		Spec:   append([]string{"-i", bridgeName, "-o", managedBridgePattern}, append(comment, "-j", "DROP")...),
+		Append: true,
+	})
+
+	return rules
+}
+
+// setupForwardRules applies the firewall policy of a bridge network for iptables, and ip6tables
+// on dual-stack networks. Tagged FORWARD rules that are no longer wanted (e.g. a removed connect
+// entry) are deleted, missing ones are added
+func setupForwardRules(networkName string, config *ComposeConfig, ipv6 bool) error {
+	connections, err := getNetworkConnections(networkName, config)
+	if err != nil {
+		return err
+	}
+
+	bridgeName := getBridgeName(networkName)
+	rules := getForwardRules(networkName, connections)
+	logger.Printf("Setting up firewall for network %s (bridge: %s, %d connection(s))", networkName, bridgeName, len(connections))
+
+	commands := []string{"iptables"}
+	if ipv6 {
+		commands = append(commands, "ip6tables")
+	}
+
+	for _, command := range commands {
+		wanted := make(map[string]bool)
+		for _, rule := range rules {
+			wanted["-A FORWARD "+strings.Join(rule.Spec, " ")] = true
+		}
+
+		existing, err := listTaggedForwardRules(command, bridgeName)
+		if err != nil {
+			return err
+		}
+		for _, line := range existing {
+			// iptables -S quotes the comment, which contains a colon
+			if wanted[strings.ReplaceAll(line, "\"", "")] {
+				continue
+			}
+			if err := deleteIptablesRule(command, "filter", line); err != nil {
+				return err
+			}
+			logger.Printf("Removed stale %s rule: %s", command, line)
+		}
+
+		for _, rule := range rules {
+			checkArgs := append([]string{command, "-C", "FORWARD"}, rule.Spec...)
+			if err := exec.Command("sudo", checkArgs...).Run(); err == nil {
+				continue
+			}
+
+			args := []string{command, "-I", "FORWARD", "1"}
+			if rule.Append {
+				args = []string{command, "-A", "FORWARD"}
+			}
+			args = append(args, rule.Spec...)
+
+			cmd := exec.Command("sudo", args...)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				return fmt.Errorf("failed to add %s forward rule: %w\nOutput: %s", command, err, string(output))
+			}
+			logger.Printf("Added %s rule: %s", command, strings.Join(rule.Spec, " "))
+		}
+	}
+
+	return nil
+}
+
+// cleanupForwardRules removes all FORWARD rules tagged for a bridge network
+func cleanupForwardRules(networkName string) {
+	bridgeName := getBridgeName(networkName)
+	logger.Printf("Cleaning up firewall for network %s (bridge: %s)", networkName, bridgeName)
+
+	for _, command := range []string{"iptables", "ip6tables"} {
+		existing, err := listTaggedForwardRules(command, bridgeName)
+		if err != nil {
+			logger.Printf("Warning: %v", err)
+			continue
+		}
+		for _, line := range existing {
+			if err := deleteIptablesRule(command, "filter", line); err != nil {
+				logger.Printf("Warning: %v", err)
+			}
+		}
+	}
+}
+
+// listTaggedForwardRules returns the FORWARD rules tagged for a bridge, as printed by iptables -S
+func listTaggedForwardRules(command string, bridgeName string) ([]string, error) {
+	output, err := exec.Command("sudo", command, "-S", "FORWARD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s FORWARD rules: %w", command, err)
+	}
+
+	var rules []string
+	ruleComment := getNATRuleComment(bridgeName)
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "-A FORWARD ") && ruleHasComment(line, ruleComment) {
+			rules = append(rules, strings.TrimSpace(line))
+		}
+	}
+
+	return rules, nil
+}
+
+// deleteIptablesRule deletes a rule given as printed by iptables -S ("-A CHAIN ...")
+func deleteIptablesRule(command string, table string, line string) error {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "-A" {
+		return fmt.Errorf("invalid %s rule: %s", command, line)
+	}
+
+	args := []string{command, "-t", table, "-D"}
+	for _, field := range fields[1:] {
+		args = append(args, strings.Trim(field, "\""))
+	}
+
+	cmd := exec.Command("sudo", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete %s rule %q: %w\nOutput: %s", command, line, err, string(output))
+	}
+
+	return nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// consoleLoggerPollInterval is how often the console logger checks console.log for new output
+const consoleLoggerPollInterval = 200 * time.Millisecond
+
+// consoleLoggerFlushDelay is how long an incomplete line (e.g. a "login: " prompt) waits for its end
+const consoleLoggerFlushDelay = time.Second
+
+// logColors are the ANSI colors of the VM name prefixes of "logs", as docker-compose uses
+var logColors = []string{"36", "33", "32", "35", "34", "96", "93", "92", "95", "94"}
+
+// getConsoleLoggerUnitName returns the systemd unit name of a VM's console logger
+func getConsoleLoggerUnitName(vmName string) string {
+	sanitizedVM := strings.ReplaceAll(vmName, " ", "-")
+	return fmt.Sprintf("qemu-compose-console-%s-%s", getProjectUnitID(), sanitizedVM)
+}
+
+// isConsoleLoggerRunning checks if the console logger of a VM is running
+func isConsoleLoggerRunning(vmName string) bool {
+	cmd := exec.Command("systemctl", "--user", "is-active", getConsoleLoggerUnitName(vmName))
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(output)) == "active"
+}
+
+// getConsoleLogSize returns the size of a VM's console log, 0 if it does not exist yet
+func getConsoleLogSize(vmName string) int64 {
+	info, err := os.Stat(getConsoleLogPath(vmName))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// getConsoleLoggerCommand returns the command line of a console logger following a VM's console log
+// from offset, -1 for the end of the file
+func getConsoleLoggerCommand(vmName string, offset int64) ([]string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine executable path: %w", err)
+	}
+
+	// No --debug: the logger output is the console output
+	return []string{execPath, "console-logger",
+		"--log", getConsoleLogPath(vmName),
+		"--vm-unit", getVMUnitName(vmName),
+		"--offset", strconv.FormatInt(offset, 10),
+	}, nil
+}
+
+// startConsoleLogger starts the console logger of a VM under systemd-run --user
+// It sends the console output QEMU appends to console.log after offset to the journal, line by line
+func startConsoleLogger(vmName string, offset int64) error {
+	unitName := getConsoleLoggerUnitName(vmName)
+
+	// A logger still following the log from a previous run keeps going, the log is only appended to
+	if isConsoleLoggerRunning(vmName) {
+		logger.Printf("Console logger already running for VM: %s", vmName)
+		return nil
+	}
+
+	loggerArgs, err := getConsoleLoggerCommand(vmName, offset)
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"systemd-run",
+		"--user",
+		"--unit=" + unitName,
+		"--description=qemu-compose console logger for VM: " + vmName,
+		"--collect",
+		"--property=Type=simple",
+	}
+	args = append(args, loggerArgs...)
+
+	logger.Printf("Executing: %s", strings.Join(args, " "))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start console logger: %w\nOutput: %s", err, string(output))
+	}
+
+	logger.Printf("Console logger started for VM: %s (unit: %s)", vmName, unitName)
+	return nil
+}
+
+// isUnitAlive checks if a user unit is running or about to run again (restart policy)
+func isUnitAlive(unitName string) bool {
+	cmd := exec.Command("systemctl", "--user", "show", unitName, "--property=ActiveState", "--value")
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	switch strings.TrimSpace(string(output)) {
+	case "active", "activating", "reloading":
+		return true
+	default:
+		return false
+	}
+}
+
+// runConsoleLogger prints each line appended to logPath after offset to stdout, where the journal
+// timestamps it, until the VM unit is stopped for good
+// offset -1 starts at the end of the file
+func runConsoleLogger(logPath string, vmUnit string, offset int64) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	var pending []byte
+	lastOutput := time.Now()
+	lastCheck := time.Now()
+	buf := make([]byte, 64*1024)
+
+	ticker := time.NewTicker(consoleLoggerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// QEMU creates the log when the VM starts, and appends to it on every boot
+		if info, err := os.Stat(logPath); err == nil {
+			if offset < 0 || info.Size() < offset {
+				// First run at the end of the file, or the log was truncated
+				if offset >= 0 {
+					logger.Printf("Console log truncated: %s", logPath)
+					offset = 0
+				} else {
+					offset = info.Size()
+				}
+			}
+
+			if info.Size() > offset {
+				file, err := os.Open(logPath)
+				if err != nil {
+					return fmt.Errorf("failed to open console log: %w", err)
+				}
+				for {
+					n, err := file.ReadAt(buf, offset)
+					offset += int64(n)
+					pending = append(pending, buf[:n]...)
+					if err != nil || n == 0 {
+						break
+					}
+				}
+				file.Close()
+				lastOutput = time.Now()
+			}
+		}
+
+		// Complete lines go out at once, an incomplete one once the console is quiet
+		for {
+			index := bytes.IndexByte(pending, '\n')
+			if index < 0 {
+				break
+			}
+			printConsoleLine(pending[:index])
+			pending = pending[index+1:]
+		}
+		if len(pending) > 0 && time.Since(lastOutput) >= consoleLoggerFlushDelay {
+			printConsoleLine(pending)
+			pending = nil
+		}
+
+		if time.Since(lastCheck) >= 2*time.Second {
+			lastCheck = time.Now()
+			if !isUnitAlive(vmUnit) {
+				logger.Printf("VM unit %s stopped, stopping console logger", vmUnit)
+				if len(pending) > 0 {
+					printConsoleLine(pending)
+				}
+				return nil
+			}
+		}
+
+		select {
+		case sig := <-signals:
+			logger.Printf("Received %s, stopping console logger", sig)
+			if len(pending) > 0 {
+				printConsoleLine(pending)
+			}
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// printConsoleLine prints a console line without its carriage return
+func printConsoleLine(line []byte) {
+	os.Stdout.Write(append(bytes.TrimRight(line, "\r"), '\n'))
+}
+
+// logEntry is a console line of a VM, read back from the journal
+type logEntry struct {
+	VM      string
+	Time    time.Time
+	Message string
+}
+
+// journalEntry is the part of a journalctl -o json entry used by logs
+type journalEntry struct {
+	Message   json.RawMessage `json:"MESSAGE"`
+	Timestamp string          `json:"__REALTIME_TIMESTAMP"`
+	Transport string          `json:"_TRANSPORT"`
+}
+
+// decodeJournalMessage decodes a journal MESSAGE field: a string, or an array of bytes when the
+// message has control characters or is not valid UTF-8
+func decodeJournalMessage(raw json.RawMessage) string {
+	var message string
+	if err := json.Unmarshal(raw, &message); err == nil {
+		return message
+	}
+
+	var data []byte
+	var values []int
+	if err := json.Unmarshal(raw, &values); err == nil {
+		for _, value := range values {
+			data = append(data, byte(value))
+		}
+	}
+	return string(data)
+}
+
+// parseLogsSince converts a --since value to a journalctl time: a Go duration ("10m", "1h30m") is
+// relative to now, RFC 3339 timestamps are converted, anything else is passed through (e.g. "today")
+func parseLogsSince(since string) string {
+	if duration, err := time.ParseDuration(since); err == nil {
+		return fmt.Sprintf("-%ds", int64(duration.Seconds()))
+	}
+	if timestamp, err := time.Parse(time.RFC3339, since); err == nil {
+		return timestamp.Local().Format("2006-01-02 15:04:05")
+	}
+	return since
+}
+
+// LogsOptions are the options of the logs command
+type LogsOptions struct {
+	Follow     bool
+	Since      string // journalctl time, see parseLogsSince
+	Tail       int    // Lines per VM, -1 for all
+	Timestamps bool
+	Color      bool
+}
+
+// readVMLogs runs journalctl on the console logger of a VM and sends its lines to entries
+func readVMLogs(vmName string, options LogsOptions, entries chan<- logEntry) error {
+	args := []string{"--user", "--unit=" + getConsoleLoggerUnitName(vmName), "--output=json", "--no-pager"}
+	if options.Tail >= 0 {
+		args = append(args, "--lines="+strconv.Itoa(options.Tail))
+	} else {
+		args = append(args, "--lines=all")
+	}
+	if options.Since != "" {
+		args = append(args, "--since="+options.Since)
+	}
+	if options.Follow {
+		args = append(args, "--follow")
+	}
+	args = append(args, "_TRANSPORT=stdout")
+
+	logger.Printf("Executing: journalctl %s", strings.Join(args, " "))
+
+	cmd := exec.Command("journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run journalctl: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Printf("Warning: invalid journal entry: %v", err)
+			continue
+		}
+		// Console lines, not the messages of systemd about the logger unit
+		if entry.Transport != "stdout" {
+			continue
+		}
+
+		var timestamp time.Time
+		if microseconds, err := strconv.ParseInt(entry.Timestamp, 10, 64); err == nil {
+			timestamp = time.UnixMicro(microseconds)
+		}
+		entries <- logEntry{VM: vmName, Time: timestamp, Message: decodeJournalMessage(entry.Message)}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		// Interrupted while following
+		if exitErr, ok := err.(*exec.ExitError); ok && !exitErr.Exited() {
+			return nil
+		}
+		return fmt.Errorf("journalctl failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// showLogs prints the console output of VMs, prefixed with their names
+// Without follow, the lines of all VMs are sorted by time; with follow, they are printed as they come
+func showLogs(vmNames []string, options LogsOptions, out io.Writer) error {
+	width := 0
+	for _, vmName := range vmNames {
+		if len(vmName) > width {
+			width = len(vmName)
+		}
+	}
+
+	prefixes := make(map[string]string)
+	for i, vmName := range vmNames {
+		prefix := fmt.Sprintf("%-*s |", width, vmName)
+		if options.Color {
+			prefix = fmt.Sprintf("\033[%sm%s\033[0m", logColors[i%len(logColors)], prefix)
+		}
+		prefixes[vmName] = prefix
+	}
+
+	printEntry := func(entry logEntry) {
+		if options.Timestamps {
+			fmt.Fprintf(out, "%s %s %s\n", prefixes[entry.VM], entry.Time.Format(time.RFC3339Nano), entry.Message)
+		} else {
+			fmt.Fprintf(out, "%s %s\n", prefixes[entry.VM], entry.Message)
+		}
+	}
+
+	entries := make(chan logEntry, 256)
+	errs := make(chan error, len(vmNames))
+	var wg sync.WaitGroup
+	for _, vmName := range vmNames {
+		wg.Add(1)
+		go func(vmName string) {
+			defer wg.Done()
+			if err := readVMLogs(vmName, options, entries); err != nil {
+				errs <- fmt.Errorf("VM %s: %w", vmName, err)
+			}
+		}(vmName)
+	}
+	go func() {
+		wg.Wait()
+		close(entries)
+	}()
+
+	if options.Follow {
+		for entry := range entries {
+			printEntry(entry)
+		}
+	} else {
+		var all []logEntry
+		for entry := range entries {
+			all = append(all, entry)
+		}
+		sort.SliceStable(all, func(i, j int) bool {
+			return all[i].Time.Before(all[j].Time)
+		})
+		for _, entry := range all {
+			printEntry(entry)
+		}
+	}
+
+	close(errs)
+	return <-errs
+}
+
+// useLogColors reports whether the logs output goes to a terminal
+func useLogColors() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
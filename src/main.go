@@ -9,10 +9,14 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -145,6 +149,36 @@ var rootCmd = &cobra.Command{
 			return nil
 		}
 
+		// The network switch runs under systemd and receives everything it needs as flags
+		if cmd.Name() == "switch" && cmd.Parent() != nil && cmd.Parent().Name() == "network" {
+			logger.Printf("Skipping compose file detection for command: network switch")
+			return nil
+		}
+
+		// The port proxy runs under systemd and receives everything it needs as flags
+		if cmd.Name() == "port-proxy" {
+			logger.Printf("Skipping compose file detection for command: port-proxy")
+			return nil
+		}
+
+		// The console logger runs under systemd and receives everything it needs as flags
+		if cmd.Name() == "console-logger" {
+			logger.Printf("Skipping compose file detection for command: console-logger")
+			return nil
+		}
+
+		// The console multiplexer runs under systemd and receives everything it needs as flags
+		if cmd.Name() == "console-mux" {
+			logger.Printf("Skipping compose file detection for command: console-mux")
+			return nil
+		}
+
+		// "console replay" only reads a recording
+		if cmd.Name() == "replay" && cmd.Parent() != nil && cmd.Parent().Name() == "console" {
+			logger.Printf("Skipping compose file detection for command: console replay")
+			return nil
+		}
+
 		// Special handling for "ls" command
 		if cmd.Name() == "ls" {
 			// "image ls" doesn't need compose file
@@ -290,8 +324,11 @@ var upCmd = &cobra.Command{
 
 			// Display connection info based on networking mode
 			if len(vm.Networks) > 0 {
-				fmt.Printf("  Networking: bridge mode (networks: %s)\n", strings.Join(vm.Networks, ", "))
+				fmt.Printf("  Networking: bridge mode (networks: %s)\n", strings.Join(getVMNetworkNames(vm), ", "))
 				fmt.Printf("  Note: VM will obtain IP via DHCP on the bridge network\n")
+				if !usesManagementNIC(vm) {
+					fmt.Printf("  SSH: via network %s (qemu-compose ssh %s)\n", vm.Networks[0].Name, vmName)
+				}
 			} else {
 				// Get SSH port for display (user-mode networking)
 				sshPort, err := getSSHPort(vmName)
@@ -303,7 +340,7 @@ var upCmd = &cobra.Command{
 				}
 			}
 
-			fmt.Printf("  View logs: journalctl --user -u %s -f\n", getVMUnitName(vmName))
+			fmt.Printf("  View logs: qemu-compose logs -f %s\n", vmName)
 			fmt.Printf("  Attach to console: qemu-compose console %s\n\n", vmName)
 		}
 
@@ -318,7 +355,7 @@ var upCmd = &cobra.Command{
 var stopCmd = &cobra.Command{
 	Use:               "stop [VM...]",
 	Short:             "Stop VMs",
-	Long:              `Stop virtual machines defined in qemu-compose.yaml without removing instance disks. By default, VMs are stopped gracefully via SSH (sudo systemctl poweroff). Use --force to terminate immediately. If VM names are provided, only those VMs will be stopped.`,
+	Long:              `Stop virtual machines defined in qemu-compose.yaml without removing instance disks. By default, VMs are stopped gracefully: ACPI power button over QMP, then SSH (sudo systemctl poweroff) for guests that ignore it, each within stop_grace_period. Use --force to terminate immediately. If VM names are provided, only those VMs will be stopped.`,
 	ValidArgsFunction: getVMNames,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Printf("Executing 'stop' command with compose file: %s", composeFile)
@@ -357,13 +394,23 @@ var stopCmd = &cobra.Command{
 				continue
 			}
 
+			if !running && isVMRestartPending(vmName) {
+				if err := stopVMForced(vmName); err != nil {
+					fmt.Fprintf(os.Stderr, "  ✗ Error cancelling restart: %v\n\n", err)
+					hasError = true
+					continue
+				}
+				fmt.Printf("  ✓ Restart cancelled\n\n")
+				continue
+			}
+
 			if !running {
 				fmt.Printf("  ⚠ VM is not running\n\n")
 				continue
 			}
 
 			// Stop VM
-			if err := stopVM(vmName, vm, force); err != nil {
+			if err := stopVM(vmName, vm, config, force); err != nil {
 				fmt.Fprintf(os.Stderr, "  ✗ Error stopping VM: %v\n\n", err)
 				hasError = true
 				continue
@@ -384,6 +431,271 @@ var stopCmd = &cobra.Command{
 	},
 }
 
+var restartCmd = &cobra.Command{
+	Use:   "restart [VM...]",
+	Short: "Restart VMs",
+	Long: `Reboot running VMs in depends_on order, without regenerating their configuration.
+
+By default, the guest shuts down cleanly on an ACPI power button press and boots again in the same
+QEMU process; guests that do not shut down within stop_grace_period are reset. Use --reset to reset
+immediately, or --hard to stop the VMs (in reverse order) and start them again like up, with fresh
+networks and cloud-init ISO. With --wait, each VM must accept SSH connections before the VMs that
+depend on it are restarted. If VM names are provided, only those VMs will be restarted.`,
+	ValidArgsFunction: getVMNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'restart' command with compose file: %s", composeFile)
+
+		hard, _ := cmd.Flags().GetBool("hard")
+		reset, _ := cmd.Flags().GetBool("reset")
+		wait, _ := cmd.Flags().GetBool("wait")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+
+		if hard && reset {
+			fmt.Fprintf(os.Stderr, "Error: --hard and --reset cannot be combined\n")
+			os.Exit(1)
+		}
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		vms, err := filterVMs(config, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		vmNames := make([]string, 0, len(vms))
+		for vmName := range vms {
+			vmNames = append(vmNames, vmName)
+		}
+		order, err := getVMStartOrder(config, vmNames)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		absComposeFile, err := filepath.Abs(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to resolve compose file path: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Using compose file: %s\n", composeFile)
+		fmt.Printf("Project: %s\n", getProjectName())
+		fmt.Printf("Restarting %d VM(s): %s\n\n", len(order), strings.Join(order, ", "))
+
+		// Running state before anything is stopped, VMs that are not running are left alone
+		running := make(map[string]bool)
+		for _, vmName := range order {
+			isRunning, err := isVMRunning(vmName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to check status of VM %s: %v\n", vmName, err)
+				os.Exit(1)
+			}
+			running[vmName] = isRunning
+		}
+
+		hasError := false
+		stopFailed := make(map[string]bool)
+
+		// Hard restart: stop dependents before their dependencies
+		if hard {
+			for i := len(order) - 1; i >= 0; i-- {
+				vmName := order[i]
+				if !running[vmName] {
+					continue
+				}
+				fmt.Printf("Stopping %s...\n", vmName)
+				if err := stopVM(vmName, config.VMs[vmName], config, false); err != nil {
+					fmt.Fprintf(os.Stderr, "  ✗ Error stopping VM: %v\n", err)
+					hasError = true
+					stopFailed[vmName] = true
+				}
+			}
+			fmt.Println()
+		}
+
+		for _, vmName := range order {
+			vm := config.VMs[vmName]
+			fmt.Printf("VM: %s\n", vmName)
+
+			if !running[vmName] {
+				fmt.Printf("  ⚠ VM is not running (use up to start it)\n\n")
+				continue
+			}
+
+			if stopFailed[vmName] {
+				fmt.Fprintf(os.Stderr, "  ✗ Not started again, the VM could not be stopped\n\n")
+				continue
+			}
+
+			switch {
+			case hard:
+				err = startVMInstance(vmName, vm, config, absComposeFile)
+			default:
+				err = rebootVM(vmName, vm, reset)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Error restarting VM: %v\n\n", err)
+				hasError = true
+				continue
+			}
+
+			switch {
+			case hard:
+				fmt.Printf("  ✓ Restarted (unit: %s)\n", getVMUnitName(vmName))
+			case reset:
+				fmt.Printf("  ✓ Reset\n")
+			default:
+				fmt.Printf("  ✓ Rebooted\n")
+			}
+
+			if wait {
+				if err := waitForVMReady(vmName, vm, config, timeout); err != nil {
+					fmt.Fprintf(os.Stderr, "  ✗ %v\n\n", err)
+					hasError = true
+					continue
+				}
+				fmt.Printf("  ✓ Ready\n")
+			}
+			fmt.Println()
+		}
+
+		if hasError {
+			os.Exit(1)
+		}
+
+		fmt.Println("✓ All VMs restarted successfully")
+	},
+}
+
+var pauseCmd = &cobra.Command{
+	Use:               "pause [VM...]",
+	Short:             "Pause VMs",
+	Long:              `Freeze the vCPUs of running VMs through QMP (stop). Memory, devices and network links stay as they are and the VM keeps its resources, but uses no host CPU time. If VM names are provided, only those VMs will be paused.`,
+	ValidArgsFunction: getVMNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'pause' command with compose file: %s", composeFile)
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		vms, err := filterVMs(config, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Using compose file: %s\n", composeFile)
+		fmt.Printf("Project: %s\n", getProjectName())
+		fmt.Printf("Pausing %d VM(s)...\n\n", len(vms))
+
+		hasError := false
+		for vmName := range vms {
+			fmt.Printf("VM: %s\n", vmName)
+
+			running, err := isVMRunning(vmName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Error checking VM status: %v\n\n", err)
+				hasError = true
+				continue
+			}
+
+			if !running {
+				fmt.Printf("  ⚠ VM is not running\n\n")
+				continue
+			}
+
+			if isVMPaused(vmName) {
+				fmt.Printf("  ⚠ VM is already paused\n\n")
+				continue
+			}
+
+			if err := pauseVM(vmName); err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Error pausing VM: %v\n\n", err)
+				hasError = true
+				continue
+			}
+
+			fmt.Printf("  ✓ Paused\n\n")
+		}
+
+		if hasError {
+			os.Exit(1)
+		}
+
+		fmt.Println("✓ All VMs paused successfully")
+	},
+}
+
+var unpauseCmd = &cobra.Command{
+	Use:               "unpause [VM...]",
+	Short:             "Unpause VMs",
+	Long:              `Resume the vCPUs of paused VMs through QMP (cont). If VM names are provided, only those VMs will be unpaused.`,
+	ValidArgsFunction: getVMNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'unpause' command with compose file: %s", composeFile)
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		vms, err := filterVMs(config, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Using compose file: %s\n", composeFile)
+		fmt.Printf("Project: %s\n", getProjectName())
+		fmt.Printf("Unpausing %d VM(s)...\n\n", len(vms))
+
+		hasError := false
+		for vmName := range vms {
+			fmt.Printf("VM: %s\n", vmName)
+
+			running, err := isVMRunning(vmName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Error checking VM status: %v\n\n", err)
+				hasError = true
+				continue
+			}
+
+			if !running {
+				fmt.Printf("  ⚠ VM is not running\n\n")
+				continue
+			}
+
+			if !isVMPaused(vmName) {
+				fmt.Printf("  ⚠ VM is not paused\n\n")
+				continue
+			}
+
+			if err := unpauseVM(vmName); err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Error unpausing VM: %v\n\n", err)
+				hasError = true
+				continue
+			}
+
+			fmt.Printf("  ✓ Unpaused\n\n")
+		}
+
+		if hasError {
+			os.Exit(1)
+		}
+
+		fmt.Println("✓ All VMs unpaused successfully")
+	},
+}
+
 var destroyCmd = &cobra.Command{
 	Use:               "destroy [VM...]",
 	Short:             "Stop and remove VMs",
@@ -426,7 +738,7 @@ var destroyCmd = &cobra.Command{
 
 			// Stop VM if running (force stop for destroy)
 			if running {
-				if err := stopVM(vmName, vm, true); err != nil {
+				if err := stopVM(vmName, vm, config, true); err != nil {
 					fmt.Fprintf(os.Stderr, "  ✗ Error stopping VM: %v\n\n", err)
 					hasError = true
 					continue
@@ -444,6 +756,12 @@ var destroyCmd = &cobra.Command{
 				} else {
 					fmt.Printf("  ✓ Network infrastructure cleaned up\n")
 				}
+				if err := releaseVMAddresses(vmName, vm); err != nil {
+					fmt.Fprintf(os.Stderr, "  ✗ Error releasing network addresses: %v\n", err)
+					hasError = true
+				} else {
+					fmt.Printf("  ✓ Network addresses released\n")
+				}
 			}
 
 			// Remove instance disk
@@ -452,7 +770,11 @@ var destroyCmd = &cobra.Command{
 				hasError = true
 				continue
 			}
-			fmt.Printf("  ✓ Instance disk removed\n\n")
+			fmt.Printf("  ✓ Instance disk removed\n")
+			if isUserUnitInstalled(getVMUnitName(vmName)) {
+				fmt.Printf("  ⚠ systemd unit still installed, run: qemu-compose systemd uninstall\n")
+			}
+			fmt.Println()
 		}
 
 		// If destroying all VMs, also clean up bridges and dnsmasq
@@ -465,11 +787,18 @@ var destroyCmd = &cobra.Command{
 				networksToCleanup[networkName] = true
 			}
 
-			// Clean up bridges and dnsmasq for unused networks
+			// Clean up bridges, dnsmasq and switches for unused networks
 			for networkName := range networksToCleanup {
-				if err := deleteBridge(networkName); err != nil {
-					fmt.Fprintf(os.Stderr, "  ✗ Failed to delete bridge for network %s: %v\n", networkName, err)
+				network := config.Networks[networkName]
+				if err := deleteNetwork(networkName, network); err != nil {
+					fmt.Fprintf(os.Stderr, "  ✗ Failed to delete network %s: %v\n", networkName, err)
 					hasError = true
+				} else if isExternalNetwork(network) {
+					fmt.Printf("  ✓ Kept external bridge: %s (network: %s)\n", resolveBridgeName(networkName, network), networkName)
+				} else if isMacvtapNetwork(network) {
+					fmt.Printf("  ✓ Released macvtap network: %s (parent: %s)\n", networkName, network.Parent)
+				} else if isRootlessNetwork(network) {
+					fmt.Printf("  ✓ Stopped switch: %s (network: %s)\n", getSwitchUnitName(networkName), networkName)
 				} else {
 					bridgeName := getBridgeName(networkName)
 					fmt.Printf("  ✓ Deleted bridge: %s (network: %s)\n", bridgeName, networkName)
@@ -484,6 +813,9 @@ var destroyCmd = &cobra.Command{
 				// Remove all network entries
 				for networkName := range networksToCleanup {
 					delete(metadata, networkName)
+					if err := releaseNetworkSubnets(networkName); err != nil {
+						logger.Printf("Warning: failed to release subnet of network %s: %v", networkName, err)
+					}
 				}
 
 				if err := saveNetworkMetadata(metadata); err != nil {
@@ -510,6 +842,7 @@ type VMStatusResult struct {
 	VMName   string
 	VM       VM
 	Status   string
+	Restarts string
 	DiskSize string
 	IPAddr   string
 	Error    error
@@ -561,7 +894,7 @@ var psCmd = &cobra.Command{
 							continue
 						}
 
-						status, err := getVMStatus(vmName, vm.Image)
+						status, err := getVMStatus(vmName, vm, config)
 						if err != nil {
 							logger.Printf("Error checking VM %s status: %v", vmName, err)
 							status = "unknown"
@@ -569,7 +902,7 @@ var psCmd = &cobra.Command{
 
 						statusMap[vmName] = status
 
-						if status != "ready" && status != "active" {
+						if status != "ready" && status != "active" && status != "paused" {
 							allReady = false
 						}
 					}
@@ -578,7 +911,7 @@ var psCmd = &cobra.Command{
 					fmt.Printf("\r")
 					notReadyVMs := []string{}
 					for vmName, status := range statusMap {
-						if status != "ready" && status != "active" {
+						if status != "ready" && status != "active" && status != "paused" {
 							notReadyVMs = append(notReadyVMs, fmt.Sprintf("%s (%s)", vmName, status))
 						}
 					}
@@ -601,8 +934,8 @@ var psCmd = &cobra.Command{
 						continue
 					}
 
-					status, err := getVMStatus(vmName, vm.Image)
-					if err != nil || (status != "ready" && status != "active") {
+					status, err := getVMStatus(vmName, vm, config)
+					if err != nil || (status != "ready" && status != "active" && status != "paused") {
 						allReady = false
 						break
 					}
@@ -616,7 +949,7 @@ var psCmd = &cobra.Command{
 
 		fmt.Printf("Using compose file: %s\n", composeFile)
 		fmt.Printf("Project: %s\n\n", getProjectName())
-		fmt.Printf("%-20s %-15s %-15s %-10s %-10s %-10s %s\n", "NAME", "STATUS", "IP ADDRESS", "CPU", "MEMORY", "DISK", "SYSTEMD UNIT")
+		fmt.Printf("%-20s %-15s %-10s %-15s %-10s %-10s %-10s %s\n", "NAME", "STATUS", "RESTARTS", "IP ADDRESS", "CPU", "MEMORY", "DISK", "SYSTEMD UNIT")
 		fmt.Println(strings.Repeat("-", 120))
 
 		// Use goroutines to check VM statuses in parallel
@@ -635,7 +968,7 @@ var psCmd = &cobra.Command{
 				}
 
 				// Get VM status
-				status, err := getVMStatus(name, vmConfig.Image)
+				status, err := getVMStatus(name, vmConfig, config)
 				if err != nil {
 					result.Status = "unknown"
 					result.Error = err
@@ -643,6 +976,14 @@ var psCmd = &cobra.Command{
 					result.Status = status
 				}
 
+				// Get restart count (NRestarts of the unit)
+				result.Restarts = "-"
+				if result.Status != "not-created" && result.Status != "stopped" {
+					if restarts, err := getVMRestartCount(name); err == nil {
+						result.Restarts = strconv.Itoa(restarts)
+					}
+				}
+
 				// Get disk size
 				if result.Status == "not-created" {
 					result.DiskSize = "-"
@@ -656,8 +997,8 @@ var psCmd = &cobra.Command{
 				}
 
 				// Get IP address for bridge networking VMs
-				if len(vmConfig.Networks) > 0 && (result.Status == "ready" || result.Status == "starting" || result.Status == "active") {
-					if ip := getVMIPAddress(name, vmConfig); ip != "" {
+				if len(vmConfig.Networks) > 0 && (result.Status == "ready" || result.Status == "starting" || result.Status == "active" || result.Status == "paused") {
+					if ip := getVMIPAddress(name, vmConfig, config); ip != "" {
 						result.IPAddr = ip
 					}
 				}
@@ -689,9 +1030,10 @@ var psCmd = &cobra.Command{
 				unitName = getVMUnitName(vmName)
 			}
 
-			fmt.Printf("%-20s %-15s %-15s %-10d %-10d %-10s %s\n",
+			fmt.Printf("%-20s %-15s %-10s %-15s %-10d %-10d %-10s %s\n",
 				vmName,
 				result.Status,
+				result.Restarts,
 				result.IPAddr,
 				vm.CPU,
 				vm.Memory,
@@ -746,7 +1088,7 @@ var inspectCmd = &cobra.Command{
 		inspectData["default_user"] = getDefaultUserForOS(osType)
 
 		// Status
-		status, err := getVMStatus(vmName, vm.Image)
+		status, err := getVMStatus(vmName, vm, config)
 		if err != nil {
 			inspectData["status"] = "unknown"
 			inspectData["status_error"] = err.Error()
@@ -759,6 +1101,21 @@ var inspectCmd = &cobra.Command{
 			inspectData["systemd_unit"] = getVMUnitName(vmName)
 		}
 
+		// Restart policy and count
+		if policy, err := getRestartPolicy(vm); err == nil {
+			inspectData["restart_policy"] = policy
+		}
+		if status != "not-created" && status != "stopped" {
+			if restarts, err := getVMRestartCount(vmName); err == nil {
+				inspectData["restarts"] = restarts
+			}
+
+			// Effective cgroup limits of the unit
+			if limits, err := getVMResourceLimits(vmName); err == nil {
+				inspectData["resource_limits"] = limits
+			}
+		}
+
 		// Disk information
 		if status != "not-created" {
 			diskMetadata, err := loadDiskMetadata(vmName)
@@ -793,26 +1150,53 @@ var inspectCmd = &cobra.Command{
 			networkInfo := make([]map[string]interface{}, 0)
 			networkMetadata, _ := loadNetworkMetadata()
 
-			for i, networkName := range vm.Networks {
+			for i, networkName := range getVMNetworkNames(vm) {
 				netInfo := make(map[string]interface{})
 				netInfo["name"] = networkName
 				netInfo["index"] = i
 
 				// Get network configuration
-				if netConfig, exists := config.Networks[networkName]; exists {
-					driver := netConfig.Driver
-					if driver == "" {
-						driver = "bridge"
+				netConfig := config.Networks[networkName]
+				netInfo["driver"] = getNetworkDriver(netConfig)
+
+				// Get subnet information
+				if meta, exists := networkMetadata[networkName]; exists && meta.Subnet != "" {
+					netInfo["subnet"] = meta.Subnet
+				}
+				if meta, exists := networkMetadata[networkName]; exists && meta.SubnetV6 != "" && !isRootlessNetwork(netConfig) {
+					netInfo["subnet_v6"] = meta.SubnetV6
+				}
+
+				// Rootless networks are served by a userspace switch instead of a bridge
+				if isRootlessNetwork(netConfig) {
+					if socketPath, err := getSwitchSocketPath(networkName); err == nil {
+						netInfo["switch_socket"] = socketPath
 					}
-					netInfo["driver"] = driver
+					netInfo["switch_unit"] = getSwitchUnitName(networkName)
+					netInfo["dhcp_enabled"] = true
+					netInfo["dhcp_running"] = isNetworkSwitchRunning(networkName)
+					networkInfo = append(networkInfo, netInfo)
+					continue
+				}
+
+				// Get TAP device name
+				tapName := getTAPName(vmName, i)
+
+				// Macvtap networks attach the VM directly to the parent interface
+				if isMacvtapNetwork(netConfig) {
+					netInfo["parent"] = netConfig.Parent
+					netInfo["macvtap_device"] = tapName
+					networkInfo = append(networkInfo, netInfo)
+					continue
 				}
 
 				// Get bridge name
-				bridgeName := getBridgeName(networkName)
+				bridgeName := resolveBridgeName(networkName, netConfig)
 				netInfo["bridge"] = bridgeName
+				if isExternalNetwork(netConfig) {
+					netInfo["external"] = true
+				}
 
-				// Get TAP device name
-				tapName := getTAPName(vmName, i)
 				netInfo["tap_device"] = tapName
 
 				// Check if TAP exists
@@ -822,11 +1206,8 @@ var inspectCmd = &cobra.Command{
 					netInfo["tap_exists"] = false
 				}
 
-				// Get subnet information
+				// Get DHCP information
 				if meta, exists := networkMetadata[networkName]; exists {
-					if meta.Subnet != "" {
-						netInfo["subnet"] = meta.Subnet
-					}
 					if meta.DnsmasqActive {
 						netInfo["dhcp_enabled"] = true
 						netInfo["dhcp_running"] = isDnsmasqRunning(networkName)
@@ -839,11 +1220,20 @@ var inspectCmd = &cobra.Command{
 			inspectData["networks"] = networkInfo
 
 			// Get IP address
-			if status == "ready" || status == "starting" || status == "active" {
-				if ip := getVMIPAddress(vmName, vm); ip != "" {
+			if status == "ready" || status == "starting" || status == "active" || status == "paused" {
+				if ip := getVMIPAddress(vmName, vm, config); ip != "" {
 					inspectData["ip_address"] = ip
 				}
 			}
+
+			// SSH goes through the management NIC unless disabled
+			inspectData["management_nic"] = usesManagementNIC(vm)
+			if status != "not-created" {
+				if sshHost, sshPort, err := getSSHEndpoint(vmName, vm, config); err == nil {
+					inspectData["ssh_host"] = sshHost
+					inspectData["ssh_port"] = sshPort
+				}
+			}
 		} else {
 			inspectData["networking_mode"] = "user-mode"
 
@@ -957,6 +1347,12 @@ var inspectCmd = &cobra.Command{
 			if unitName, ok := inspectData["systemd_unit"].(string); ok {
 				fmt.Printf("  Systemd Unit: %s\n", unitName)
 			}
+			if policy, ok := inspectData["restart_policy"].(string); ok {
+				fmt.Printf("  Restart Policy: %s\n", policy)
+			}
+			if restarts, ok := inspectData["restarts"].(int); ok {
+				fmt.Printf("  Restarts: %d\n", restarts)
+			}
 			fmt.Println()
 
 			// Configuration
@@ -968,6 +1364,17 @@ var inspectCmd = &cobra.Command{
 			fmt.Printf("  Default User: %s\n", inspectData["default_user"])
 			fmt.Println()
 
+			// Resource limits
+			if limits, ok := inspectData["resource_limits"].(*VMResourceLimits); ok {
+				fmt.Println("Resource Limits:")
+				fmt.Printf("  CPU Quota: %s\n", limits.CPUQuota)
+				fmt.Printf("  CPU Weight: %s\n", limits.CPUWeight)
+				fmt.Printf("  Memory Max: %s\n", limits.MemoryMax)
+				fmt.Printf("  IO Weight: %s\n", limits.IOWeight)
+				fmt.Printf("  Tasks Max: %s\n", limits.TasksMax)
+				fmt.Println()
+			}
+
 			// Disk
 			fmt.Println("Disk:")
 			if diskSize, ok := inspectData["disk_size"].(string); ok {
@@ -994,14 +1401,30 @@ var inspectCmd = &cobra.Command{
 							fmt.Printf("      Driver: %s\n", driver)
 						}
 						if bridge, ok := netInfo["bridge"].(string); ok {
-							fmt.Printf("      Bridge: %s\n", bridge)
+							if external, ok := netInfo["external"].(bool); ok && external {
+								fmt.Printf("      Bridge: %s (external)\n", bridge)
+							} else {
+								fmt.Printf("      Bridge: %s\n", bridge)
+							}
+						}
+						if parent, ok := netInfo["parent"].(string); ok {
+							fmt.Printf("      Parent: %s\n", parent)
+						}
+						if macvtap, ok := netInfo["macvtap_device"].(string); ok {
+							fmt.Printf("      Macvtap Device: %s\n", macvtap)
 						}
 						if tap, ok := netInfo["tap_device"].(string); ok {
 							fmt.Printf("      TAP Device: %s\n", tap)
 						}
+						if switchSocket, ok := netInfo["switch_socket"].(string); ok {
+							fmt.Printf("      Switch Socket: %s\n", switchSocket)
+						}
 						if subnet, ok := netInfo["subnet"].(string); ok {
 							fmt.Printf("      Subnet: %s\n", subnet)
 						}
+						if subnetV6, ok := netInfo["subnet_v6"].(string); ok {
+							fmt.Printf("      Subnet IPv6: %s\n", subnetV6)
+						}
 						if dhcpEnabled, ok := netInfo["dhcp_enabled"].(bool); ok && dhcpEnabled {
 							dhcpStatus := "stopped"
 							if running, ok := netInfo["dhcp_running"].(bool); ok && running {
@@ -1014,6 +1437,13 @@ var inspectCmd = &cobra.Command{
 				if ipAddr, ok := inspectData["ip_address"].(string); ok {
 					fmt.Printf("  IP Address: %s\n", ipAddr)
 				}
+				if managementNIC, ok := inspectData["management_nic"].(bool); ok && !managementNIC {
+					fmt.Printf("  Management NIC: disabled\n")
+				}
+				if sshHost, ok := inspectData["ssh_host"].(string); ok {
+					fmt.Printf("  SSH Command: ssh -i .qemu-compose/ssh/id_ed25519 -p %d %s@%s\n",
+						inspectData["ssh_port"], inspectData["default_user"], sshHost)
+				}
 			} else {
 				fmt.Println("Networking:")
 				fmt.Printf("  Mode: user-mode (NAT)\n")
@@ -1031,6 +1461,15 @@ var inspectCmd = &cobra.Command{
 				for _, port := range vm.Ports {
 					fmt.Printf("  - %s\n", port)
 				}
+				if usesPortProxy(vm, config) {
+					proxyStatus := "stopped"
+					if isPortProxyRunning(vmName) {
+						proxyStatus = "running"
+					}
+					fmt.Printf("  Proxy: %s (%s)\n", getPortProxyUnitName(vmName), proxyStatus)
+				} else {
+					fmt.Printf("  Forwarded by: user-mode network (hostfwd)\n")
+				}
 				fmt.Println()
 			}
 
@@ -1113,7 +1552,9 @@ var inspectCmd = &cobra.Command{
 			// Logs
 			if unitName, ok := inspectData["systemd_unit"].(string); ok {
 				fmt.Println("Logs:")
-				fmt.Printf("  View: journalctl --user -u %s -f\n", unitName)
+				fmt.Printf("  Console: qemu-compose logs -f %s\n", vmName)
+				fmt.Printf("  Console Log: %s\n", getConsoleLogPath(vmName))
+				fmt.Printf("  QEMU: journalctl --user -u %s -f\n", unitName)
 			}
 		}
 	},
@@ -1198,7 +1639,8 @@ var doctorCmd = &cobra.Command{
 	Long:  `Verify that all required system dependencies (QEMU, bridge utilities, Linux kernel features) are properly installed`,
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Println("Starting system dependency checks")
-		fmt.Println("Checking system dependencies...\n")
+		fmt.Println("Checking system dependencies...")
+		fmt.Println()
 
 		allOk := true
 
@@ -1400,9 +1842,39 @@ var doctorCmd = &cobra.Command{
 }
 
 var consoleCmd = &cobra.Command{
-	Use:               "console <vm-name>",
-	Short:             "Attach to a VM's serial console",
-	Long:              `Attach to a running VM's serial console with read/write access. Press Ctrl+] to detach.`,
+	Use:   "console <vm-name>",
+	Short: "Attach to a VM's serial console",
+	Long: `Attach to a running VM's serial console. Recent output is replayed on attach. Several clients
+may attach at once: all see the output, the first one typing holds the input until it detaches.
+Press Ctrl+] to detach, or choose another key with --escape (ctrl-a, ^x, none).
+
+With --no-tty, stdin does not need to be a terminal: it is sent to the console as is, and the
+output that follows (no replay) is printed until the console is idle for 2 seconds after the end
+of stdin, e.g.:
+
+  echo 'systemctl restart sshd' | qemu-compose console --no-tty vm1
+
+With --expect and --send, the console is driven by a script instead: the n-th --send is sent
+once the n-th --expect text is seen (escapes such as \n are interpreted), each within --timeout.
+--script reads the steps from a YAML file:
+
+  timeout: 60s
+  steps:
+    - expect: "login:"
+      send: "root\n"
+    - expect: "Password:"
+      send: "secret\n"
+      timeout: 10s
+
+Scripts only match output printed after connecting. With --from-start (or from_start: true in
+the YAML file), the scrollback replayed on attach is matched too, e.g. for a login prompt that
+was printed before connecting.
+
+Exit codes of scripts: 0 all expected texts seen, 1 error, 2 timeout, 3 console closed (VM stopped).
+
+--record writes the console output to an asciicast v2 file, as do all console sessions of a VM with
+console.record set (in .qemu-compose/<vm-name>/recordings). Play recordings back with
+'qemu-compose console replay <file>' or asciinema.`,
 	Args:              cobra.ExactArgs(1),
 	ValidArgsFunction: getVMNames,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -1410,20 +1882,240 @@ var consoleCmd = &cobra.Command{
 
 		logger.Printf("Executing 'console' command for VM: %s", vmName)
 
-		config, err := loadComposeFile(composeFile)
-		if err != nil {
+		escapeKey, _ := cmd.Flags().GetString("escape")
+		noTTY, _ := cmd.Flags().GetBool("no-tty")
+
+		expects, _ := cmd.Flags().GetStringArray("expect")
+		sends, _ := cmd.Flags().GetStringArray("send")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		scriptPath, _ := cmd.Flags().GetString("script")
+		fromStart, _ := cmd.Flags().GetBool("from-start")
+		record, _ := cmd.Flags().GetString("record")
+
+		escape, err := parseConsoleEscape(escapeKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if scriptPath != "" && (len(expects) > 0 || len(sends) > 0) {
+			fmt.Fprintf(os.Stderr, "Error: --script cannot be combined with --expect or --send\n")
+			os.Exit(1)
+		}
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
 
 		// Check if VM exists in config
-		if _, exists := config.VMs[vmName]; !exists {
+		vm, exists := config.VMs[vmName]
+		if !exists {
 			fmt.Fprintf(os.Stderr, "Error: VM not found in compose file: %s\n", vmName)
 			os.Exit(1)
 		}
 
+		// Record every session of VMs with console.record set
+		if record == "" && vm.Console != nil && vm.Console.Record {
+			record, err = getConsoleRecordingPath(vmName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		// Drive the console with a script
+		if scriptPath != "" || len(expects) > 0 || len(sends) > 0 {
+			var script *ConsoleScript
+			if scriptPath != "" {
+				script, err = loadConsoleScript(scriptPath)
+			} else {
+				script, err = newConsoleScript(expects, sends, timeout)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(consoleScriptExitError)
+			}
+			if fromStart {
+				script.FromStart = true
+			}
+
+			code, err := runConsoleScript(vmName, script, record)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			}
+			os.Exit(code)
+		}
+
 		// Attach to console
-		if err := attachToConsole(vmName); err != nil {
+		options := ConsoleOptions{
+			Escape: escape,
+			NoTTY:  noTTY,
+			Record: record,
+		}
+		if err := attachToConsole(vmName, options); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var consoleReplayCmd = &cobra.Command{
+	Use:   "replay <file.cast>",
+	Short: "Play back a recorded console session",
+	Long: `Play back an asciicast v2 recording made with 'console --record' or console.record, with its
+original timing. --speed plays it faster, --idle-limit shortens long pauses (e.g. waiting for a
+boot step).`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := args[0]
+
+		logger.Printf("Executing 'console replay' command for recording: %s", path)
+
+		speed, _ := cmd.Flags().GetFloat64("speed")
+		idleLimit, _ := cmd.Flags().GetDuration("idle-limit")
+
+		if speed <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: --speed must be positive\n")
+			os.Exit(1)
+		}
+
+		if err := replayAsciicast(path, speed, idleLimit); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [VM...]",
+	Short: "Show the serial console output of VMs",
+	Long: `Show the serial console output of VMs (boot messages, cloud-init, login prompts), as logged to
+.qemu-compose/<vm-name>/console.log and timestamped in the journal. The lines of several VMs are
+interleaved and prefixed with their names. If VM names are provided, only those VMs are shown.
+
+--since takes a duration (10m, 1h30m), an RFC 3339 timestamp or any journalctl time ("today",
+"2026-01-02 10:00").`,
+	ValidArgsFunction: getVMNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'logs' command with compose file: %s", composeFile)
+
+		follow, _ := cmd.Flags().GetBool("follow")
+		since, _ := cmd.Flags().GetString("since")
+		tail, _ := cmd.Flags().GetString("tail")
+		timestamps, _ := cmd.Flags().GetBool("timestamps")
+		noColor, _ := cmd.Flags().GetBool("no-color")
+
+		options := LogsOptions{
+			Follow:     follow,
+			Tail:       -1,
+			Timestamps: timestamps,
+			Color:      !noColor && useLogColors(),
+		}
+		if since != "" {
+			options.Since = parseLogsSince(since)
+		}
+		if tail != "all" {
+			lines, err := strconv.Atoi(tail)
+			if err != nil || lines < 0 {
+				fmt.Fprintf(os.Stderr, "Error: invalid --tail value: %s (expected a number of lines or all)\n", tail)
+				os.Exit(1)
+			}
+			options.Tail = lines
+		}
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		vms, err := filterVMs(config, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		vmNames := make([]string, 0, len(vms))
+		for vmName := range vms {
+			vmNames = append(vmNames, vmName)
+		}
+		sort.Strings(vmNames)
+
+		// Follow until interrupted, journalctl processes get the signal too
+		signal.Ignore(os.Interrupt)
+
+		if err := showLogs(vmNames, options, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var qmpCmd = &cobra.Command{
+	Use:   "qmp <vm-name> [command [json-args]]",
+	Short: "Send QMP or HMP commands to a running VM",
+	Long: `Send a command to a running VM over its QMP (QEMU Machine Protocol) socket and print the result,
+for example: qemu-compose qmp web query-status, or qemu-compose qmp web device_del '{"id": "nic1"}'.
+
+With --hmp, the command is a human monitor (HMP) command such as "info network"; without a command,
+an interactive monitor is opened. With --events, QMP events are printed as JSON lines until Ctrl+C.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: getVMNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		vmName := args[0]
+		hmp, _ := cmd.Flags().GetBool("hmp")
+		events, _ := cmd.Flags().GetBool("events")
+
+		logger.Printf("Executing 'qmp' command for VM: %s", vmName)
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Check if VM exists in config
+		if _, exists := config.VMs[vmName]; !exists {
+			fmt.Fprintf(os.Stderr, "Error: VM not found in compose file: %s\n", vmName)
+			os.Exit(1)
+		}
+
+		running, err := isVMRunning(vmName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to check VM status: %v\n", err)
+			os.Exit(1)
+		}
+		if !running {
+			fmt.Fprintf(os.Stderr, "Error: VM is not running: %s\n", vmName)
+			os.Exit(1)
+		}
+
+		switch {
+		case events:
+			if hmp || len(args) > 1 {
+				fmt.Fprintf(os.Stderr, "Error: --events takes no command\n")
+				os.Exit(1)
+			}
+			err = streamQMPEvents(vmName)
+		case hmp && len(args) == 1:
+			err = runHMPShell(vmName)
+		case hmp:
+			err = runHMPCommand(vmName, strings.Join(args[1:], " "))
+		case len(args) == 1:
+			fmt.Fprintf(os.Stderr, "Error: a QMP command is required (or use --hmp for an interactive monitor)\n")
+			os.Exit(1)
+		case len(args) > 3:
+			fmt.Fprintf(os.Stderr, "Error: QMP arguments must be a single JSON object\n")
+			os.Exit(1)
+		case len(args) == 3:
+			err = runQMPCommand(vmName, args[1], args[2])
+		default:
+			err = runQMPCommand(vmName, args[1], "")
+		}
+
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -1467,10 +2159,10 @@ var sshCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// Get SSH port
-		sshPort, err := getSSHPort(vmName)
+		// Get SSH endpoint
+		sshHost, sshPort, err := getSSHEndpoint(vmName, vm, config)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: failed to get SSH port: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: failed to get SSH endpoint: %v\n", err)
 			os.Exit(1)
 		}
 
@@ -1493,7 +2185,7 @@ var sshCmd = &cobra.Command{
 		// Detect default user for the OS
 		defaultUser := getDefaultUserForOS(detectOSFromImage(vm.Image))
 
-		logger.Printf("Connecting to VM %s via SSH (port: %d, user: %s, key: %s)", vmName, sshPort, defaultUser, sshKeyPath)
+		logger.Printf("Connecting to VM %s via SSH (host: %s, port: %d, user: %s, key: %s)", vmName, sshHost, sshPort, defaultUser, sshKeyPath)
 
 		// Build SSH command
 		sshArgs := []string{
@@ -1501,7 +2193,7 @@ var sshCmd = &cobra.Command{
 			"-p", fmt.Sprintf("%d", sshPort),
 			"-o", "StrictHostKeyChecking=no",
 			"-o", "UserKnownHostsFile=/dev/null",
-			fmt.Sprintf("%s@localhost", defaultUser),
+			fmt.Sprintf("%s@%s", defaultUser, sshHost),
 		}
 
 		// Add command arguments if provided
@@ -1603,7 +2295,7 @@ var networkLsCmd = &cobra.Command{
 		// Display networks table
 		if len(config.Networks) > 0 {
 			fmt.Println("=== Networks ===")
-			fmt.Printf("%-20s %-10s %-20s %-15s %-10s %-30s\n", "NAME", "DRIVER", "SUBNET", "BRIDGE", "DHCP", "DNSMASQ UNIT")
+			fmt.Printf("%-20s %-10s %-20s %-15s %-10s %-30s\n", "NAME", "DRIVER", "SUBNET", "BRIDGE", "DHCP", "DHCP UNIT")
 			fmt.Println(strings.Repeat("-", 110))
 
 			for networkName, network := range config.Networks {
@@ -1613,7 +2305,7 @@ var networkLsCmd = &cobra.Command{
 				}
 
 				subnet := "not allocated"
-				bridgeName := getBridgeName(networkName)
+				bridgeName := resolveBridgeName(networkName, network)
 				dhcpStatus := "no"
 				dnsmasqUnit := "-"
 
@@ -1631,38 +2323,72 @@ var networkLsCmd = &cobra.Command{
 					}
 				}
 
+				// External and macvtap networks are addressed by the host LAN
+				if isExternalNetwork(network) {
+					subnet = "external"
+					dhcpStatus = "-"
+				} else if isMacvtapNetwork(network) {
+					subnet = "external"
+					bridgeName = "-"
+					dhcpStatus = "-"
+				}
+
+				// Rootless networks have no bridge, DHCP is served by the switch
+				if isRootlessNetwork(network) {
+					bridgeName = "-"
+					dnsmasqUnit = getSwitchUnitName(networkName)
+					if isNetworkSwitchRunning(networkName) {
+						dhcpStatus = "yes"
+					} else {
+						dhcpStatus = "stopped"
+					}
+				}
+
 				fmt.Printf("%-20s %-10s %-20s %-15s %-10s %-30s\n",
 					networkName, driver, subnet, bridgeName, dhcpStatus, dnsmasqUnit)
 			}
 			fmt.Println()
 		} else {
-			fmt.Println("No networks defined in compose file\n")
+			fmt.Println("No networks defined in compose file")
+			fmt.Println()
 		}
 
 		// Display bridge information
 		if len(config.Networks) > 0 {
 			fmt.Println("=== Bridges ===")
-			for networkName := range config.Networks {
-				bridgeName := getBridgeName(networkName)
+			for networkName, network := range config.Networks {
+				if isRootlessNetwork(network) || isMacvtapNetwork(network) {
+					continue
+				}
+
+				bridgeName := resolveBridgeName(networkName, network)
+				label := fmt.Sprintf("network: %s", networkName)
+				if isExternalNetwork(network) {
+					label += ", external"
+				}
 
 				// Check if bridge exists
 				bridge, err := netlink.LinkByName(bridgeName)
 				if err != nil {
-					fmt.Printf("Bridge: %s (network: %s)\n", bridgeName, networkName)
+					fmt.Printf("Bridge: %s (%s)\n", bridgeName, label)
 					fmt.Printf("  Status: not created\n\n")
 					continue
 				}
 
-				fmt.Printf("Bridge: %s (network: %s)\n", bridgeName, networkName)
+				fmt.Printf("Bridge: %s (%s)\n", bridgeName, label)
 				fmt.Printf("  Status: active\n")
 				fmt.Printf("  Index: %d\n", bridge.Attrs().Index)
 				fmt.Printf("  MTU: %d\n", bridge.Attrs().MTU)
 
 				// Get IP addresses
-				addrs, err := netlink.AddrList(bridge, netlink.FAMILY_V4)
+				addrs, err := netlink.AddrList(bridge, netlink.FAMILY_ALL)
 				if err == nil && len(addrs) > 0 {
 					fmt.Printf("  IP Addresses:\n")
 					for _, addr := range addrs {
+						// Skip IPv6 link-local addresses assigned by the kernel
+						if addr.IP.IsLinkLocalUnicast() {
+							continue
+						}
 						fmt.Printf("    - %s\n", addr.IPNet.String())
 					}
 				}
@@ -1698,18 +2424,16 @@ var networkLsCmd = &cobra.Command{
 				fmt.Printf("  Unit: %s\n", meta.DnsmasqUnit)
 				fmt.Printf("  Status: %s\n", status)
 				fmt.Printf("  Subnet: %s\n", meta.Subnet)
+				if meta.SubnetV6 != "" {
+					fmt.Printf("  Subnet IPv6: %s\n", meta.SubnetV6)
+				}
 
 				if isRunning {
 					// Parse subnet to show DHCP range
-					ip, _, err := net.ParseCIDR(meta.Subnet)
-					if err == nil {
-						startIP := make(net.IP, len(ip))
-						endIP := make(net.IP, len(ip))
-						copy(startIP, ip.To4())
-						copy(endIP, ip.To4())
-						startIP[3] = 10
-						endIP[3] = 250
-						fmt.Printf("  DHCP Range: %s - %s\n", startIP.String(), endIP.String())
+					if _, ipNet, err := net.ParseCIDR(meta.Subnet); err == nil {
+						if reservedFirst, _, dynamicLast, err := getBridgeHostRanges(ipNet); err == nil {
+							fmt.Printf("  DHCP Range: %s - %s\n", uint32ToIP(reservedFirst), uint32ToIP(dynamicLast))
+						}
 					}
 					fmt.Printf("  View logs: journalctl --user -u %s -f\n", meta.DnsmasqUnit)
 				}
@@ -1718,7 +2442,8 @@ var networkLsCmd = &cobra.Command{
 			}
 
 			if !hasActiveDHCP {
-				fmt.Println("No DHCP servers configured\n")
+				fmt.Println("No DHCP servers configured")
+				fmt.Println()
 			}
 		}
 
@@ -1730,7 +2455,11 @@ var networkLsCmd = &cobra.Command{
 				continue
 			}
 
-			for i, networkName := range vm.Networks {
+			for i, networkName := range getVMNetworkNames(vm) {
+				if isRootlessNetwork(config.Networks[networkName]) {
+					continue
+				}
+
 				tapName := getTAPName(vmName, i)
 
 				// Check if TAP exists
@@ -1764,7 +2493,8 @@ var networkLsCmd = &cobra.Command{
 		}
 
 		if !hasAnyTAP {
-			fmt.Println("No TAP devices found\n")
+			fmt.Println("No TAP devices found")
+			fmt.Println()
 		}
 
 		// Display capability information
@@ -1855,7 +2585,7 @@ var networkDownCmd = &cobra.Command{
 		// Find VMs using these networks
 		affectedVMs := make(map[string]VM)
 		for vmName, vm := range config.VMs {
-			for _, vmNetwork := range vm.Networks {
+			for _, vmNetwork := range getVMNetworkNames(vm) {
 				if _, exists := networksToDestroy[vmNetwork]; exists {
 					affectedVMs[vmName] = vm
 					break
@@ -1867,7 +2597,7 @@ var networkDownCmd = &cobra.Command{
 		if len(affectedVMs) > 0 && !force {
 			fmt.Println("Warning: The following VMs are using these networks:")
 			for vmName, vm := range affectedVMs {
-				fmt.Printf("  - %s (networks: %s)\n", vmName, strings.Join(vm.Networks, ", "))
+				fmt.Printf("  - %s (networks: %s)\n", vmName, strings.Join(getVMNetworkNames(vm), ", "))
 			}
 			fmt.Println()
 			fmt.Print("These VMs will be stopped. Continue? [y/N]: ")
@@ -1899,7 +2629,7 @@ var networkDownCmd = &cobra.Command{
 				}
 
 				if running {
-					if err := stopVM(vmName, vm, true); err != nil {
+					if err := stopVM(vmName, vm, config, true); err != nil {
 						fmt.Fprintf(os.Stderr, "  ✗ Failed to stop %s: %v\n", vmName, err)
 						hasError = true
 					} else {
@@ -1919,7 +2649,10 @@ var networkDownCmd = &cobra.Command{
 
 		// Delete TAP devices for affected VMs
 		for vmName, vm := range affectedVMs {
-			for i := range vm.Networks {
+			for i, vmNetwork := range getVMNetworkNames(vm) {
+				if isRootlessNetwork(config.Networks[vmNetwork]) {
+					continue
+				}
 				tapName := getTAPName(vmName, i)
 				if err := deleteTAPDevice(tapName); err != nil {
 					logger.Printf("Warning: failed to delete TAP device %s: %v", tapName, err)
@@ -1929,10 +2662,16 @@ var networkDownCmd = &cobra.Command{
 			}
 		}
 
-		// Delete bridges
-		for networkName := range networksToDestroy {
-			if err := deleteBridge(networkName); err != nil {
-				fmt.Fprintf(os.Stderr, "  ✗ Failed to delete bridge for network %s: %v\n", networkName, err)
+		// Delete bridges and switches
+		for networkName, network := range networksToDestroy {
+			if err := deleteNetwork(networkName, network); err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Failed to delete network %s: %v\n", networkName, err)
+			} else if isExternalNetwork(network) {
+				fmt.Printf("  ✓ Kept external bridge: %s (network: %s)\n", resolveBridgeName(networkName, network), networkName)
+			} else if isMacvtapNetwork(network) {
+				fmt.Printf("  ✓ Released macvtap network: %s (parent: %s)\n", networkName, network.Parent)
+			} else if isRootlessNetwork(network) {
+				fmt.Printf("  ✓ Stopped switch: %s (network: %s)\n", getSwitchUnitName(networkName), networkName)
 			} else {
 				bridgeName := getBridgeName(networkName)
 				fmt.Printf("  ✓ Deleted bridge: %s (network: %s)\n", bridgeName, networkName)
@@ -1947,6 +2686,9 @@ var networkDownCmd = &cobra.Command{
 			// Remove entries for destroyed networks
 			for networkName := range networksToDestroy {
 				delete(metadata, networkName)
+				if err := releaseNetworkSubnets(networkName); err != nil {
+					logger.Printf("Warning: failed to release subnet of network %s: %v", networkName, err)
+				}
 			}
 
 			if err := saveNetworkMetadata(metadata); err != nil {
@@ -1960,47 +2702,1080 @@ var networkDownCmd = &cobra.Command{
 	},
 }
 
-// formatBytes formats a byte count into a human-readable string
-func formatBytes(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+var networkCreateCmd = &cobra.Command{
+	Use:               "create [NETWORK...]",
+	Short:             "Create network infrastructure",
+	Long:              `Create network infrastructure (bridges, DHCP, NAT, switches) without starting any VM. If network names are provided, only those networks will be created.`,
+	ValidArgsFunction: getNetworkNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Println("Executing 'network create' command")
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Using compose file: %s\n", composeFile)
+		fmt.Printf("Project: %s\n\n", getProjectName())
+
+		networkNames := args
+		if len(networkNames) == 0 {
+			for networkName := range config.Networks {
+				networkNames = append(networkNames, networkName)
+			}
+			sort.Strings(networkNames)
+		}
+
+		if len(networkNames) == 0 {
+			fmt.Println("No networks to create")
+			return
+		}
+
+		hasError := false
+		for _, networkName := range networkNames {
+			network, exists := config.Networks[networkName]
+			if !exists {
+				fmt.Fprintf(os.Stderr, "  ✗ Network not found in compose file: %s\n", networkName)
+				hasError = true
+				continue
+			}
+
+			if err := setupNetwork(networkName, config); err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Failed to create network %s: %v\n", networkName, err)
+				hasError = true
+			} else if isExternalNetwork(network) {
+				fmt.Printf("  ✓ Using external bridge: %s (network: %s)\n", resolveBridgeName(networkName, network), networkName)
+			} else if isMacvtapNetwork(network) {
+				fmt.Printf("  ✓ Checked macvtap parent: %s (network: %s)\n", network.Parent, networkName)
+			} else if isRootlessNetwork(network) {
+				fmt.Printf("  ✓ Started switch: %s (network: %s)\n", getSwitchUnitName(networkName), networkName)
+			} else {
+				fmt.Printf("  ✓ Created bridge: %s (network: %s)\n", getBridgeName(networkName), networkName)
+			}
+		}
+
+		if hasError {
+			os.Exit(1)
+		}
+	},
 }
 
-func init() {
-	rootCmd.PersistentFlags().StringVarP(&composeFile, "file", "f", "", "Specify an alternate compose file (default: qemu-compose.yaml or qemu-compose.yml)")
-	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable debug logging (can also use QEMU_COMPOSE_DEBUG=true)")
+var networkRmCmd = &cobra.Command{
+	Use:               "rm NETWORK...",
+	Short:             "Remove network infrastructure",
+	Long:              `Remove the infrastructure of networks (bridges, DHCP, NAT, switches, metadata). Networks used by running VMs are not removed.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: getNetworkNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Println("Executing 'network rm' command")
 
-	pullCmd.Flags().BoolP("force", "", false, "Force re-download even if image already exists")
-	psCmd.Flags().BoolP("wait", "", false, "Wait for all VMs to be ready before displaying status")
-	stopCmd.Flags().BoolP("force", "", false, "Force immediate shutdown (send SIGTERM to QEMU process)")
-	networkDownCmd.Flags().BoolP("force", "", false, "Skip confirmation prompt")
-	inspectCmd.Flags().StringP("format", "", "text", "Output format: text or json")
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 
-	imageCmd.AddCommand(imageLsCmd)
+		// Refuse to pull a network from under running VMs
+		for _, networkName := range args {
+			if _, exists := config.Networks[networkName]; !exists {
+				fmt.Fprintf(os.Stderr, "Error: network not found in compose file: %s\n", networkName)
+				os.Exit(1)
+			}
+
+			for vmName, vm := range config.VMs {
+				for _, vmNetwork := range getVMNetworkNames(vm) {
+					if vmNetwork != networkName {
+						continue
+					}
+					if running, _ := isVMRunning(vmName); running {
+						fmt.Fprintf(os.Stderr, "Error: network %s is used by running VM %s (stop it first or use 'network down')\n", networkName, vmName)
+						os.Exit(1)
+					}
+				}
+			}
+		}
+
+		metadata, err := loadNetworkMetadata()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		hasError := false
+		for _, networkName := range args {
+			network := config.Networks[networkName]
+
+			// Remove TAP devices left behind by stopped VMs
+			for attachment, tapName := range metadata[networkName].TAPDevices {
+				if err := deleteTAPDevice(tapName); err != nil {
+					logger.Printf("Warning: failed to delete TAP device %s: %v", tapName, err)
+				} else {
+					fmt.Printf("  ✓ Deleted TAP device: %s (%s)\n", tapName, attachment)
+				}
+			}
+
+			if err := deleteNetwork(networkName, network); err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ Failed to remove network %s: %v\n", networkName, err)
+				hasError = true
+				continue
+			}
+
+			delete(metadata, networkName)
+			if err := releaseNetworkSubnets(networkName); err != nil {
+				logger.Printf("Warning: failed to release subnet of network %s: %v", networkName, err)
+			}
+
+			if isExternalNetwork(network) {
+				fmt.Printf("  ✓ Kept external bridge: %s (network: %s)\n", resolveBridgeName(networkName, network), networkName)
+			} else if isMacvtapNetwork(network) {
+				fmt.Printf("  ✓ Released macvtap network: %s (parent: %s)\n", networkName, network.Parent)
+			} else if isRootlessNetwork(network) {
+				fmt.Printf("  ✓ Stopped switch: %s (network: %s)\n", getSwitchUnitName(networkName), networkName)
+			} else {
+				fmt.Printf("  ✓ Deleted bridge: %s (network: %s)\n", getBridgeName(networkName), networkName)
+			}
+		}
+
+		if err := saveNetworkMetadata(metadata); err != nil {
+			fmt.Fprintf(os.Stderr, "  ✗ Failed to update network metadata: %v\n", err)
+			hasError = true
+		}
+
+		if hasError {
+			os.Exit(1)
+		}
+	},
+}
+
+var networkInspectCmd = &cobra.Command{
+	Use:               "inspect NETWORK",
+	Short:             "Display detailed network information",
+	Long:              `Display the subnet, bridge, DHCP server, NAT rules, attached TAP devices and current leases of a network`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: getNetworkNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		networkName := args[0]
+		logger.Printf("Executing 'network inspect' command for network: %s", networkName)
+
+		outputFormat, _ := cmd.Flags().GetString("format")
+		if outputFormat != "text" && outputFormat != "json" {
+			fmt.Fprintf(os.Stderr, "Error: invalid format %s (must be 'text' or 'json')\n", outputFormat)
+			os.Exit(1)
+		}
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		network, exists := config.Networks[networkName]
+		if !exists {
+			fmt.Fprintf(os.Stderr, "Error: network not found in compose file: %s\n", networkName)
+			os.Exit(1)
+		}
+
+		metadata, err := loadNetworkMetadata()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		netMeta := metadata[networkName]
+
+		inspectData := make(map[string]interface{})
+		inspectData["name"] = networkName
+		inspectData["project"] = getProjectName()
+		inspectData["driver"] = getNetworkDriver(network)
+		inspectData["external"] = isExternalNetwork(network)
+		inspectData["subnet"] = netMeta.Subnet
+		if netMeta.SubnetV6 != "" {
+			inspectData["subnet_v6"] = netMeta.SubnetV6
+		}
+
+		// Host side of the network
+		switch {
+		case isRootlessNetwork(network):
+			inspectData["switch_unit"] = getSwitchUnitName(networkName)
+			inspectData["switch_active"] = isNetworkSwitchRunning(networkName)
+		case isMacvtapNetwork(network):
+			inspectData["parent"] = network.Parent
+			mode := network.Mode
+			if mode == "" {
+				mode = "bridge"
+			}
+			inspectData["mode"] = mode
+		default:
+			bridgeName := resolveBridgeName(networkName, network)
+			bridgeInfo := map[string]interface{}{
+				"name":   bridgeName,
+				"exists": false,
+			}
+			if link, err := netlink.LinkByName(bridgeName); err == nil {
+				bridgeInfo["exists"] = true
+				bridgeInfo["state"] = link.Attrs().OperState.String()
+			}
+			if !isExternalNetwork(network) && netMeta.Subnet != "" {
+				bridgeInfo["ip"] = getBridgeIP(netMeta.Subnet)
+			}
+			inspectData["bridge"] = bridgeInfo
+
+			if !isExternalNetwork(network) {
+				inspectData["dnsmasq_unit"] = getDnsmasqUnitName(networkName)
+				inspectData["dnsmasq_active"] = isDnsmasqRunning(networkName)
+
+				rules, err := listNATRules(bridgeName)
+				if err != nil {
+					logger.Printf("Warning: could not list NAT rules: %v", err)
+				}
+				inspectData["nat_rules"] = rules
+			}
+		}
+
+		// Attached VM interfaces
+		attachments := make([]map[string]interface{}, 0)
+		for _, vmName := range getSortedVMNames(config) {
+			for i, vmNetwork := range config.VMs[vmName].Networks {
+				if vmNetwork.Name != networkName {
+					continue
+				}
+
+				attachment := map[string]interface{}{
+					"vm":  vmName,
+					"mac": getVMMACAddress(vmName, config.VMs[vmName], i),
+				}
+				if address := getReservedVMAddress(networkName, vmName); address != "" {
+					attachment["reserved_ip"] = address
+				}
+				if len(vmNetwork.Aliases) > 0 {
+					attachment["aliases"] = vmNetwork.Aliases
+				}
+				if tapName, ok := netMeta.TAPDevices[getTAPDeviceKey(vmName, i)]; ok {
+					attachment["device"] = tapName
+					_, err := netlink.LinkByName(tapName)
+					attachment["exists"] = err == nil
+				}
+				attachments = append(attachments, attachment)
+			}
+		}
+		inspectData["attachments"] = attachments
+
+		// Current leases
+		leases, err := loadNetworkLeases(networkName)
+		if err != nil {
+			logger.Printf("Warning: could not load leases: %v", err)
+		}
+		leaseInfo := make([]map[string]interface{}, 0)
+		for _, lease := range leases {
+			leaseInfo = append(leaseInfo, map[string]interface{}{
+				"mac":      lease.MAC,
+				"ip":       lease.IP,
+				"hostname": lease.Hostname,
+				"expiry":   lease.Expiry.Format(time.RFC3339),
+			})
+		}
+		inspectData["leases"] = leaseInfo
+
+		if outputFormat == "json" {
+			jsonData, err := json.MarshalIndent(inspectData, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to marshal JSON: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(jsonData))
+			return
+		}
+
+		// Human-readable format
+		fmt.Printf("Network: %s\n", networkName)
+		fmt.Printf("Project: %s\n", getProjectName())
+		fmt.Println(strings.Repeat("=", 80))
+		fmt.Println()
+
+		fmt.Printf("Driver: %s\n", getNetworkDriver(network))
+		if netMeta.Subnet != "" {
+			fmt.Printf("Subnet: %s\n", netMeta.Subnet)
+		} else {
+			fmt.Printf("Subnet: (not allocated)\n")
+		}
+		if netMeta.SubnetV6 != "" {
+			fmt.Printf("Subnet (IPv6): %s\n", netMeta.SubnetV6)
+		}
+
+		if bridgeInfo, ok := inspectData["bridge"].(map[string]interface{}); ok {
+			external := ""
+			if isExternalNetwork(network) {
+				external = " (external)"
+			}
+			if bridgeInfo["exists"] == true {
+				fmt.Printf("Bridge: %s%s (%s)\n", bridgeInfo["name"], external, bridgeInfo["state"])
+			} else {
+				fmt.Printf("Bridge: %s%s (not created)\n", bridgeInfo["name"], external)
+			}
+			if ip, ok := bridgeInfo["ip"]; ok {
+				fmt.Printf("Gateway: %s\n", ip)
+			}
+		}
+		if unitName, ok := inspectData["dnsmasq_unit"]; ok {
+			status := "inactive"
+			if inspectData["dnsmasq_active"] == true {
+				status = "active"
+			}
+			fmt.Printf("DHCP: %s (%s)\n", unitName, status)
+		}
+		if unitName, ok := inspectData["switch_unit"]; ok {
+			status := "inactive"
+			if inspectData["switch_active"] == true {
+				status = "active"
+			}
+			fmt.Printf("Switch: %s (%s)\n", unitName, status)
+		}
+		if isMacvtapNetwork(network) {
+			fmt.Printf("Parent: %s (mode: %s)\n", network.Parent, inspectData["mode"])
+		}
+
+		if rules, ok := inspectData["nat_rules"].([]string); ok {
+			fmt.Println()
+			fmt.Println("NAT Rules:")
+			if len(rules) == 0 {
+				fmt.Println("  (none)")
+			}
+			for _, rule := range rules {
+				fmt.Printf("  %s\n", rule)
+			}
+		}
+
+		fmt.Println()
+		fmt.Println("Attached VMs:")
+		if len(attachments) == 0 {
+			fmt.Println("  (none)")
+		}
+		for _, attachment := range attachments {
+			line := fmt.Sprintf("  %s  mac=%s", attachment["vm"], attachment["mac"])
+			if device, ok := attachment["device"]; ok {
+				state := "missing"
+				if attachment["exists"] == true {
+					state = "up"
+				}
+				line += fmt.Sprintf("  device=%s (%s)", device, state)
+			}
+			if address, ok := attachment["reserved_ip"]; ok {
+				line += fmt.Sprintf("  ip=%s", address)
+			}
+			if aliases, ok := attachment["aliases"].([]string); ok {
+				line += fmt.Sprintf("  aliases=%s", strings.Join(aliases, ","))
+			}
+			fmt.Println(line)
+		}
+
+		fmt.Println()
+		fmt.Println("Leases:")
+		if len(leases) == 0 {
+			fmt.Println("  (none)")
+		}
+		for _, lease := range leases {
+			hostname := lease.Hostname
+			if hostname == "" {
+				hostname = "-"
+			}
+			fmt.Printf("  %-15s  %s  %-20s  expires %s\n", lease.IP, lease.MAC, hostname, lease.Expiry.Format(time.RFC3339))
+		}
+	},
+}
+
+var networkPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove orphaned network resources",
+	Long:  `Remove bridges, TAP devices, dnsmasq units and iptables rules created by qemu-compose that no project metadata references any more`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Println("Executing 'network prune' command")
+
+		force, _ := cmd.Flags().GetBool("force")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		orphaned, err := findOrphanedNetworkResources()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if orphaned.isEmpty() {
+			fmt.Println("No orphaned network resources found")
+			return
+		}
+
+		fmt.Println("Orphaned network resources:")
+		for _, bridgeName := range orphaned.Bridges {
+			fmt.Printf("  - bridge %s\n", bridgeName)
+		}
+		for _, tapName := range orphaned.TAPDevices {
+			fmt.Printf("  - TAP device %s\n", tapName)
+		}
+		for _, unitName := range orphaned.DnsmasqUnits {
+			fmt.Printf("  - dnsmasq unit %s\n", unitName)
+		}
+		for _, unitName := range orphaned.UserDnsmasqUnits {
+			fmt.Printf("  - dnsmasq user unit %s\n", unitName)
+		}
+		for _, rule := range orphaned.NATRules {
+			fmt.Printf("  - rule %s\n", rule)
+		}
+		fmt.Println()
+
+		if dryRun {
+			return
+		}
+
+		if !force {
+			fmt.Print("Remove these resources? [y/N]: ")
+
+			reader := bufio.NewReader(os.Stdin)
+			response, err := reader.ReadString('\n')
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+				os.Exit(1)
+			}
+
+			response = strings.TrimSpace(strings.ToLower(response))
+			if response != "y" && response != "yes" {
+				fmt.Println("Aborted")
+				return
+			}
+			fmt.Println()
+		}
+
+		errs := pruneNetworkResources(orphaned)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "  ✗ %v\n", err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+
+		fmt.Println("✓ Orphaned network resources removed")
+	},
+}
+
+var networkChaosCmd = &cobra.Command{
+	Use:   "chaos TARGET...",
+	Short: "Degrade or partition VM network links",
+	Long: `Apply delay, jitter, loss, corruption and rate limits (netem) to the bridge interfaces of VMs.
+Each TARGET is a VM (all its bridge interfaces) or a network (the interfaces of all its VMs).
+--partition isolates the target VMs from the other VMs of their networks with ebtables: they can
+still reach each other and the host (network names are not accepted as --partition targets). Settings are kept across VM restarts until cleared with
+--clear. Without settings, the current link settings are displayed.`,
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: getNetworkNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'network chaos' command for: %s", strings.Join(args, ", "))
+
+		clearSettings, _ := cmd.Flags().GetBool("clear")
+		partition, _ := cmd.Flags().GetBool("partition")
+
+		var settings LinkSettings
+		settings.Delay, _ = cmd.Flags().GetString("delay")
+		settings.Jitter, _ = cmd.Flags().GetString("jitter")
+		settings.Loss, _ = cmd.Flags().GetString("loss")
+		settings.Corrupt, _ = cmd.Flags().GetString("corrupt")
+		settings.Rate, _ = cmd.Flags().GetString("rate")
+
+		if (clearSettings || partition) && (!settings.isEmpty() || clearSettings && partition) {
+			fmt.Fprintf(os.Stderr, "Error: --clear and --partition cannot be combined with other settings\n")
+			os.Exit(1)
+		}
+		if partition {
+			settings = getPartitionSettings(args)
+		}
+		if _, err := parseLinkSettings(settings); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// All the VMs of a network would land in the same partition, which isolates nothing
+		if partition {
+			for _, name := range args {
+				if _, exists := config.VMs[name]; !exists {
+					fmt.Fprintf(os.Stderr, "Error: --partition takes VM names, %s is not a VM\n", name)
+					os.Exit(1)
+				}
+			}
+		}
+
+		targets, err := resolveChaosTargets(args, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Display current settings
+		if !clearSettings && settings.isEmpty() {
+			for _, target := range targets {
+				fmt.Printf("%s on %s (%s): %s\n", target.VM, target.Network, target.TAP, getEffectiveLinkSettings(target.Network, target.VM, config))
+			}
+			return
+		}
+
+		hasError := false
+		for _, target := range targets {
+			if err := recordLinkSettings(target.Network, target.VM, settings); err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ %s on %s: %v\n", target.VM, target.Network, err)
+				hasError = true
+				continue
+			}
+
+			effective := getEffectiveLinkSettings(target.Network, target.VM, config)
+
+			if _, err := netlink.LinkByName(target.TAP); err != nil {
+				fmt.Printf("  ⚠ %s on %s: not running, %s will apply when the VM starts\n", target.VM, target.Network, effective)
+				continue
+			}
+
+			if effective.isEmpty() {
+				err = clearLinkSettings(target.TAP)
+			} else {
+				err = applyLinkSettings(target.TAP, effective)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ %s on %s: %v\n", target.VM, target.Network, err)
+				hasError = true
+				continue
+			}
+
+			switch {
+			case clearSettings && effective.isEmpty():
+				fmt.Printf("  ✓ %s on %s: cleared\n", target.VM, target.Network)
+			case clearSettings:
+				fmt.Printf("  ✓ %s on %s: restored network link settings (%s)\n", target.VM, target.Network, effective)
+			default:
+				fmt.Printf("  ✓ %s on %s: %s\n", target.VM, target.Network, effective)
+			}
+		}
+
+		// Partitions are filtered on the bridges, between the interfaces of all the VMs of a network
+		seenNetworks := make(map[string]bool)
+		for _, target := range targets {
+			if seenNetworks[target.Network] {
+				continue
+			}
+			seenNetworks[target.Network] = true
+			if err := setupPartitionRules(target.Network, config); err != nil {
+				fmt.Fprintf(os.Stderr, "  ✗ %s: %v\n", target.Network, err)
+				hasError = true
+			}
+		}
+
+		if hasError {
+			os.Exit(1)
+		}
+	},
+}
+
+var networkCaptureCmd = &cobra.Command{
+	Use:   "capture TARGET",
+	Short: "Capture packets on a network or a VM interface",
+	Long: `Capture the packets of a network bridge or of a VM interface, without working out
+interface names. TARGET is a network (its bridge) or a VM (its interface on --network, by default
+its first network). User-mode NICs and rootless networks are captured inside QEMU with a
+filter-dump object; use --network user for the user-mode NIC of a VM with networks.
+
+Without --write, a one-line summary of each packet is printed. With --write FILE, packets are
+saved in pcap format; use --write - to stream pcap to stdout (e.g. into wireshark -k -i -).`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: getNetworkNames,
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		networkName, _ := cmd.Flags().GetString("network")
+		writePath, _ := cmd.Flags().GetString("write")
+		count, _ := cmd.Flags().GetInt("count")
+		snaplen, _ := cmd.Flags().GetInt("snaplen")
+
+		logger.Printf("Executing 'network capture' command for: %s", name)
+
+		if snaplen <= 0 || count < 0 {
+			fmt.Fprintf(os.Stderr, "Error: --snaplen must be positive and --count cannot be negative\n")
+			os.Exit(1)
+		}
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		target, err := resolveCaptureTarget(name, networkName, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if target.VM != "" {
+			running, err := isVMRunning(target.VM)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to check VM status: %v\n", err)
+				os.Exit(1)
+			}
+			if !running {
+				fmt.Fprintf(os.Stderr, "Error: VM is not running: %s\n", target.VM)
+				os.Exit(1)
+			}
+		}
+
+		var sink packetSink = &textWriter{writer: os.Stdout}
+		if writePath != "" {
+			output := os.Stdout
+			if writePath != "-" {
+				output, err = os.Create(writePath)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: failed to create capture file: %v\n", err)
+					os.Exit(1)
+				}
+				defer output.Close()
+			}
+			if sink, err = newPcapWriter(output, snaplen); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		session := newCaptureSession(sink, count)
+
+		// Stop on Ctrl+C
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigCh
+			session.Stop()
+		}()
+
+		// Status goes to stderr, stdout may carry the pcap stream
+		fmt.Fprintf(os.Stderr, "Capturing on %s, press Ctrl+C to stop\n", target)
+
+		if err := runCapture(target, snaplen, session); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "✓ %d packet(s) captured\n", session.count)
+		if writePath != "" && writePath != "-" {
+			fmt.Fprintf(os.Stderr, "  Written to %s\n", writePath)
+		}
+	},
+}
+
+var networkSwitchCmd = &cobra.Command{
+	Use:    "switch",
+	Short:  "Run the userspace switch of a rootless network",
+	Long:   `Run the userspace Ethernet switch (with built-in DHCP and DNS) used by networks with driver "user" or "socket". This command is started automatically under systemd-run --user.`,
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		networkName, _ := cmd.Flags().GetString("network")
+		subnet, _ := cmd.Flags().GetString("subnet")
+		socketPath, _ := cmd.Flags().GetString("socket")
+		stateDir, _ := cmd.Flags().GetString("state-dir")
+
+		logger.Printf("Executing 'network switch' command for network: %s", networkName)
+
+		if networkName == "" || subnet == "" || socketPath == "" || stateDir == "" {
+			fmt.Fprintf(os.Stderr, "Error: --network, --subnet, --socket and --state-dir are required\n")
+			os.Exit(1)
+		}
+
+		if err := runNetworkSwitch(networkName, socketPath, subnet, stateDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var systemdCmd = &cobra.Command{
+	Use:   "systemd",
+	Short: "Manage persistent systemd units",
+	Long:  `Generate persistent systemd user units for the VMs of the project, so that they start again after a host reboot`,
+}
+
+var systemdGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Write systemd unit files for the project",
+	Long: `Write a service per VM, dnsmasq instance and port proxy, and a qemu-compose-<project>.target
+starting all VMs in depends_on order, to ~/.config/systemd/user.
+
+VM services run the same QEMU command as up, generated from the existing instances: run up first,
+and generate again after changing the compose file. Once units are installed, up and stop start and
+stop them with systemctl. Use --install to enable the target at login, and "loginctl enable-linger"
+to start it at boot without login. VMs on macvtap networks are not supported.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'systemd generate' command with compose file: %s", composeFile)
+
+		install, _ := cmd.Flags().GetBool("install")
+		outputDir, _ := cmd.Flags().GetString("output")
+
+		if install && outputDir != "" {
+			fmt.Fprintf(os.Stderr, "Error: --install and --output cannot be combined\n")
+			os.Exit(1)
+		}
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Using compose file: %s\n", composeFile)
+		fmt.Printf("Project: %s\n", getProjectName())
+		fmt.Printf("Generating systemd units for %d VM(s)\n\n", len(config.VMs))
+
+		units, err := generateSystemdUnits(config, composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		unitDir := outputDir
+		if unitDir == "" {
+			unitDir, err = getUserUnitDir()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if err := writeSystemdUnits(units, unitDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, unit := range units {
+			fmt.Printf("  ✓ %s\n", unit.Name)
+		}
+		fmt.Printf("\nUnit files written to %s\n", unitDir)
+
+		// Units written elsewhere are for the user to review or copy
+		if outputDir != "" {
+			return
+		}
+
+		if err := runUserSystemctl("daemon-reload"); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		targetName := getProjectTargetName()
+		if install {
+			if err := runUserSystemctl("enable", targetName); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("✓ Enabled %s\n", targetName)
+		}
+
+		fmt.Printf("\nStart now: systemctl --user start %s\n", targetName)
+		if !install {
+			fmt.Printf("Start at login: qemu-compose systemd generate --install\n")
+		}
+		fmt.Printf("Start at boot without login: loginctl enable-linger $USER\n")
+	},
+}
+
+var systemdUninstallCmd = &cobra.Command{
+	Use:   "uninstall",
+	Short: "Remove the systemd unit files of the project",
+	Long:  `Disable the project target and remove the unit files written by "systemd generate". Running VMs keep running; once stopped, they are started with systemd-run again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'systemd uninstall' command")
+
+		fmt.Printf("Project: %s\n", getProjectName())
+
+		names, err := uninstallSystemdUnits()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if len(names) == 0 {
+			fmt.Println("No systemd units installed for this project")
+			return
+		}
+
+		fmt.Printf("Removed %d unit file(s):\n", len(names))
+		for _, name := range names {
+			fmt.Printf("  ✓ %s\n", name)
+		}
+	},
+}
+
+var systemdPrepareCmd = &cobra.Command{
+	Use:    "prepare VM",
+	Short:  "Set up the networks of a VM",
+	Long:   `Set up the networks of a VM before QEMU starts. This command is run by the VM units written by "systemd generate".`,
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'systemd prepare' command for VM: %s", args[0])
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := systemdPrepareVM(args[0], config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var systemdCleanupCmd = &cobra.Command{
+	Use:    "cleanup VM",
+	Short:  "Remove the networks of a VM",
+	Long:   `Remove the TAP devices of a VM after QEMU exits. This command is run by the VM units written by "systemd generate".`,
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'systemd cleanup' command for VM: %s", args[0])
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := systemdCleanupVM(args[0], config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var systemdPrepareNetworkCmd = &cobra.Command{
+	Use:    "prepare-network NETWORK",
+	Short:  "Set up the bridge of a network",
+	Long:   `Set up the bridge, NAT and firewall rules of a network before dnsmasq starts. This command is run by the dnsmasq units written by "systemd generate".`,
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Printf("Executing 'systemd prepare-network' command for network: %s", args[0])
+
+		config, err := loadComposeFile(composeFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := systemdPrepareNetwork(args[0], config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var consoleLoggerCmd = &cobra.Command{
+	Use:    "console-logger",
+	Short:  "Send the console log of a VM to the journal",
+	Long:   `Print each line QEMU appends to the console log of a VM, so that the journal timestamps it for the logs command. This command is started automatically under systemd-run --user and exits when the VM unit stops.`,
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		logPath, _ := cmd.Flags().GetString("log")
+		vmUnit, _ := cmd.Flags().GetString("vm-unit")
+		offset, _ := cmd.Flags().GetInt64("offset")
+
+		logger.Printf("Executing 'console-logger' command for log: %s", logPath)
+
+		if logPath == "" || vmUnit == "" {
+			fmt.Fprintf(os.Stderr, "Error: --log and --vm-unit are required\n")
+			os.Exit(1)
+		}
+
+		if err := runConsoleLogger(logPath, vmUnit, offset); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var consoleMuxCmd = &cobra.Command{
+	Use:    "console-mux -- <qemu command>",
+	Short:  "Run QEMU and share its serial console",
+	Long:   `Run QEMU and serve its serial socket to several console clients, replaying recent output on attach (except on --live-socket). This command is the main process of the VM unit and exits with the exit code of QEMU.`,
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		serialPath, _ := cmd.Flags().GetString("serial")
+		socketPath, _ := cmd.Flags().GetString("socket")
+		liveSocketPath, _ := cmd.Flags().GetString("live-socket")
+
+		logger.Printf("Executing 'console-mux' command for socket: %s", socketPath)
+
+		if serialPath == "" || socketPath == "" || len(args) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --serial, --socket and a QEMU command are required\n")
+			os.Exit(1)
+		}
+
+		code, err := runConsoleMux(serialPath, socketPath, liveSocketPath, args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(code)
+	},
+}
+
+var portProxyCmd = &cobra.Command{
+	Use:    "port-proxy",
+	Short:  "Forward published ports to a bridge-networked VM",
+	Long:   `Forward published ports from the host to the address of a VM on a bridge network. This command is started automatically under systemd-run --user.`,
+	Hidden: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		targetIP, _ := cmd.Flags().GetString("target")
+		publish, _ := cmd.Flags().GetStringArray("publish")
+
+		logger.Printf("Executing 'port-proxy' command for target: %s", targetIP)
+
+		if targetIP == "" || len(publish) == 0 {
+			fmt.Fprintf(os.Stderr, "Error: --target and --publish are required\n")
+			os.Exit(1)
+		}
+
+		var mappings []PortMapping
+		for _, spec := range publish {
+			mapping, err := parsePortMapping(spec)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			mappings = append(mappings, mapping)
+		}
+
+		if err := runPortProxy(targetIP, mappings); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// formatBytes formats a byte count into a human-readable string
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&composeFile, "file", "f", "", "Specify an alternate compose file (default: qemu-compose.yaml or qemu-compose.yml)")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "Enable debug logging (can also use QEMU_COMPOSE_DEBUG=true)")
+
+	pullCmd.Flags().BoolP("force", "", false, "Force re-download even if image already exists")
+	psCmd.Flags().BoolP("wait", "", false, "Wait for all VMs to be ready before displaying status")
+	stopCmd.Flags().BoolP("force", "", false, "Force immediate shutdown (send SIGTERM to QEMU process)")
+	restartCmd.Flags().Bool("hard", false, "Stop the VMs and start them again instead of rebooting the guests")
+	restartCmd.Flags().Bool("reset", false, "Reset the VMs immediately instead of a clean reboot")
+	restartCmd.Flags().Bool("wait", false, "Wait for each VM to accept SSH connections before restarting its dependents")
+	restartCmd.Flags().Duration("timeout", 5*time.Minute, "Maximum time to wait for each VM with --wait")
+	qmpCmd.Flags().Bool("hmp", false, "Run a human monitor (HMP) command, or open an interactive monitor")
+	qmpCmd.Flags().Bool("events", false, "Print QMP events as JSON lines until interrupted")
+	networkDownCmd.Flags().BoolP("force", "", false, "Skip confirmation prompt")
+	networkInspectCmd.Flags().StringP("format", "", "text", "Output format: text or json")
+	networkPruneCmd.Flags().BoolP("force", "", false, "Skip confirmation prompt")
+	networkPruneCmd.Flags().BoolP("dry-run", "", false, "Only list orphaned resources")
+	networkChaosCmd.Flags().String("delay", "", "Delay added to each packet (e.g. 100ms)")
+	networkChaosCmd.Flags().String("jitter", "", "Delay variation, requires --delay (e.g. 10ms)")
+	networkChaosCmd.Flags().String("loss", "", "Packet loss percentage (e.g. 1%)")
+	networkChaosCmd.Flags().String("corrupt", "", "Packet corruption percentage (e.g. 0.1%)")
+	networkChaosCmd.Flags().String("rate", "", "Bandwidth limit (e.g. 10mbit, 512kbit)")
+	networkChaosCmd.Flags().Bool("partition", false, "Isolate the targets from the other VMs of their networks (they can still reach each other)")
+	networkChaosCmd.Flags().Bool("clear", false, "Remove settings applied by chaos, restoring the network link settings")
+	networkCaptureCmd.Flags().String("network", "", "Network of the VM interface to capture (\"user\" for the user-mode NIC)")
+	networkCaptureCmd.Flags().StringP("write", "w", "", "Write packets in pcap format to a file (\"-\" for stdout)")
+	networkCaptureCmd.Flags().IntP("count", "c", 0, "Stop after capturing this many packets")
+	networkCaptureCmd.Flags().Int("snaplen", defaultSnaplen, "Maximum number of bytes captured per packet")
+	inspectCmd.Flags().StringP("format", "", "text", "Output format: text or json")
+	networkSwitchCmd.Flags().String("network", "", "Network name")
+	networkSwitchCmd.Flags().String("subnet", "", "Network subnet (CIDR)")
+	networkSwitchCmd.Flags().String("socket", "", "Unix socket path QEMU connects to")
+	networkSwitchCmd.Flags().String("state-dir", "", "Directory for switch state (leases)")
+
+	consoleCmd.Flags().String("escape", defaultConsoleEscape, "Detach key: ctrl-<key>, ^<key> or none")
+	consoleCmd.Flags().Bool("no-tty", false, "Send stdin to the console as is, without a terminal (for pipes)")
+	consoleCmd.Flags().StringArray("expect", nil, "Wait for this text in the console output (repeatable)")
+	consoleCmd.Flags().StringArray("send", nil, "Send this text once the matching --expect is seen (repeatable, \\n for newline)")
+	consoleCmd.Flags().Duration("timeout", defaultConsoleScriptTimeout, "How long each --expect waits")
+	consoleCmd.Flags().String("script", "", "Run the expect/send steps of a YAML file")
+	consoleCmd.Flags().Bool("from-start", false, "Also match the scrollback replayed on attach")
+	consoleCmd.Flags().String("record", "", "Record the console output to an asciicast v2 file")
+
+	consoleReplayCmd.Flags().Float64("speed", 1, "Playback speed factor")
+	consoleReplayCmd.Flags().Duration("idle-limit", 0, "Maximum pause between outputs (e.g. 2s, 0 for none)")
+	consoleCmd.AddCommand(consoleReplayCmd)
+
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow new console output")
+	logsCmd.Flags().String("since", "", "Show output since a time or for a duration (e.g. 10m, 2026-01-02T10:00:00Z)")
+	logsCmd.Flags().String("tail", "all", "Number of lines to show per VM")
+	logsCmd.Flags().BoolP("timestamps", "t", false, "Show timestamps")
+	logsCmd.Flags().Bool("no-color", false, "Do not color the VM name prefixes")
+
+	consoleLoggerCmd.Flags().String("log", "", "Console log to follow")
+	consoleLoggerCmd.Flags().String("vm-unit", "", "Unit of the VM, the logger exits when it stops")
+	consoleLoggerCmd.Flags().Int64("offset", -1, "Offset to start from (-1: end of the log)")
+
+	consoleMuxCmd.Flags().String("serial", "", "QEMU serial socket")
+	consoleMuxCmd.Flags().String("socket", "", "Console socket to serve")
+	consoleMuxCmd.Flags().String("live-socket", "", "Console socket to serve without scrollback replay")
+
+	portProxyCmd.Flags().String("target", "", "VM address to forward to")
+	portProxyCmd.Flags().StringArray("publish", nil, "Port mapping HOST_IP:HOST_PORT:VM_PORT/PROTOCOL (repeatable)")
+
+	systemdGenerateCmd.Flags().Bool("install", false, "Enable the project target so that the VMs start at login (or at boot with lingering)")
+	systemdGenerateCmd.Flags().StringP("output", "o", "", "Write the unit files to this directory instead of ~/.config/systemd/user")
+
+	imageCmd.AddCommand(imageLsCmd)
+
+	systemdCmd.AddCommand(systemdGenerateCmd)
+	systemdCmd.AddCommand(systemdUninstallCmd)
+	systemdCmd.AddCommand(systemdPrepareCmd)
+	systemdCmd.AddCommand(systemdCleanupCmd)
+	systemdCmd.AddCommand(systemdPrepareNetworkCmd)
 
 	networkCmd.AddCommand(networkLsCmd)
 	networkCmd.AddCommand(networkDownCmd)
+	networkCmd.AddCommand(networkCreateCmd)
+	networkCmd.AddCommand(networkRmCmd)
+	networkCmd.AddCommand(networkInspectCmd)
+	networkCmd.AddCommand(networkPruneCmd)
+	networkCmd.AddCommand(networkChaosCmd)
+	networkCmd.AddCommand(networkCaptureCmd)
+	networkCmd.AddCommand(networkSwitchCmd)
 
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(portProxyCmd)
+	rootCmd.AddCommand(consoleLoggerCmd)
+	rootCmd.AddCommand(consoleMuxCmd)
 	rootCmd.AddCommand(upCmd)
 	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(restartCmd)
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(unpauseCmd)
 	rootCmd.AddCommand(destroyCmd)
 	rootCmd.AddCommand(psCmd)
 	rootCmd.AddCommand(inspectCmd)
 	rootCmd.AddCommand(pullCmd)
 	rootCmd.AddCommand(doctorCmd)
 	rootCmd.AddCommand(consoleCmd)
+	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(qmpCmd)
 	rootCmd.AddCommand(sshCmd)
 	rootCmd.AddCommand(imageCmd)
 	rootCmd.AddCommand(networkCmd)
+	rootCmd.AddCommand(systemdCmd)
 }
 
 func main() {
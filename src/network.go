@@ -1,24 +1,144 @@
 package main
 
 import (
-	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/vishvananda/netlink"
 )
 
 // NetworkMetadata stores network configuration
 type NetworkMetadata struct {
-	Subnet        string `json:"subnet"`
-	Driver        string `json:"driver"`
-	DnsmasqUnit   string `json:"dnsmasq_unit,omitempty"`
-	DnsmasqActive bool   `json:"dnsmasq_active,omitempty"`
+	Subnet        string                  `json:"subnet"`
+	SubnetV6      string                  `json:"subnet_v6,omitempty"`
+	Driver        string                  `json:"driver"`
+	DnsmasqUnit   string                  `json:"dnsmasq_unit,omitempty"`
+	DnsmasqActive bool                    `json:"dnsmasq_active,omitempty"`
+	SwitchUnit    string                  `json:"switch_unit,omitempty"`
+	Project       string                  `json:"project,omitempty"` // Project directory owning the interfaces
+	Bridge        string                  `json:"bridge,omitempty"`
+	TAPDevices    map[string]string       `json:"tap_devices,omitempty"` // "<vm>/<network index>" -> TAP/macvtap device
+	Addresses     map[string]string       `json:"addresses,omitempty"`   // VM name -> reserved IPv4 address
+	Chaos         map[string]LinkSettings `json:"chaos,omitempty"`       // VM name -> link settings set by "network chaos"
+}
+
+// NetworkLease represents a DHCP lease (dnsmasq lease file format)
+type NetworkLease struct {
+	Expiry   time.Time
+	MAC      string
+	IP       string
+	Hostname string
+}
+
+// getNetworkDriver returns the driver of a network, defaulting to "bridge"
+func getNetworkDriver(network Network) string {
+	if network.Driver == "" {
+		return "bridge"
+	}
+	return network.Driver
+}
+
+// isRootlessNetwork returns true if the network uses the userspace switch driver
+func isRootlessNetwork(network Network) bool {
+	driver := getNetworkDriver(network)
+	return driver == "user" || driver == "socket"
+}
+
+// isExternalNetwork returns true if the network references a host bridge not managed by qemu-compose
+func isExternalNetwork(network Network) bool {
+	return network.External
+}
+
+// isMacvtapNetwork returns true if VMs are attached to a parent host interface through macvtap
+func isMacvtapNetwork(network Network) bool {
+	return getNetworkDriver(network) == "macvtap"
+}
+
+// resolveBridgeName returns the host bridge used by a bridge network
+// External networks use the configured bridge name, others the project-owned bridge
+func resolveBridgeName(networkName string, network Network) string {
+	if isExternalNetwork(network) {
+		if network.Name != "" {
+			return network.Name
+		}
+		return networkName
+	}
+	return getBridgeName(networkName)
+}
+
+// getNetworkStateDir returns the directory holding runtime state for a network
+func getNetworkStateDir(networkName string) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	stateDir := filepath.Join(cwd, ".qemu-compose", "networks", networkName)
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create network state directory: %w", err)
+	}
+
+	return stateDir, nil
+}
+
+// parseLeasesFile parses a dnsmasq-format lease file
+// Format: "<expiry> <mac> <ip> <hostname|*> <client-id|*>"
+func parseLeasesFile(path string) ([]NetworkLease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read leases file: %w", err)
+	}
+
+	var leases []NetworkLease
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		hostname := fields[3]
+		if hostname == "*" {
+			hostname = ""
+		}
+
+		leases = append(leases, NetworkLease{
+			Expiry:   time.Unix(expiry, 0),
+			MAC:      strings.ToLower(fields[1]),
+			IP:       fields[2],
+			Hostname: hostname,
+		})
+	}
+
+	return leases, nil
+}
+
+// loadNetworkLeases returns the current DHCP leases of a network
+func loadNetworkLeases(networkName string) ([]NetworkLease, error) {
+	stateDir, err := getNetworkStateDir(networkName)
+	if err != nil {
+		return nil, err
+	}
+	return parseLeasesFile(filepath.Join(stateDir, "leases"))
 }
 
 // getNetworkMetadataPath returns the path to the networks metadata file
@@ -43,6 +163,12 @@ func loadNetworkMetadata() (map[string]NetworkMetadata, error) {
 		return nil, err
 	}
 
+	return loadNetworkMetadataFile(metadataPath)
+}
+
+// loadNetworkMetadataFile loads network metadata from a networks.json file
+// A missing file means no network metadata
+func loadNetworkMetadataFile(metadataPath string) (map[string]NetworkMetadata, error) {
 	data, err := os.ReadFile(metadataPath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -78,94 +204,404 @@ func saveNetworkMetadata(metadata map[string]NetworkMetadata) error {
 	return nil
 }
 
-// allocateSubnet allocates a new subnet from the pool
-func allocateSubnet() (string, error) {
+// resolveNetworkSubnet resolves the subnet for a network and records it in the network metadata
+// If subnet is "auto", allocates a new subnet from the pool, manual subnets are checked for conflicts
+func resolveNetworkSubnet(networkName string, network Network) (string, error) {
+	// Load existing metadata
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	var subnet string
+	if network.Subnet != "auto" && network.Subnet != "" {
+		// Manual subnet: refuse overlaps with host routes and other projects
+		if err := checkManualSubnet(networkName, network, network.Subnet); err != nil {
+			return "", err
+		}
+		subnet = network.Subnet
+	} else if existing, exists := metadata[networkName]; exists && existing.Subnet != "" {
+		// Reuse the subnet we already allocated for this network
+		logger.Printf("Reusing existing subnet for network %s: %s", networkName, existing.Subnet)
+		if err := registerSubnet(networkName, existing.Subnet); err != nil {
+			logger.Printf("Warning: %v", err)
+		}
+		return existing.Subnet, nil
+	} else {
+		// Allocate a new subnet
+		subnet, err = allocateSubnet(networkName)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Save the allocation
+	netMeta := metadata[networkName]
+	netMeta.Subnet = subnet
+	netMeta.Driver = network.Driver
+	metadata[networkName] = netMeta
+
+	if err := saveNetworkMetadata(metadata); err != nil {
+		return "", fmt.Errorf("failed to save network metadata: %w", err)
+	}
+
+	logger.Printf("Resolved subnet for network %s: %s", networkName, subnet)
+	return subnet, nil
+}
+
+// isIPv6Enabled returns true if the network is dual-stack
+func isIPv6Enabled(network Network) bool {
+	return network.EnableIPv6 || network.SubnetV6 != ""
+}
+
+// allocateSubnetV6 allocates a unique local (ULA) /64 for a network
+// The 40-bit global ID is derived from the project directory, the subnet ID is the
+// first one not yet used by another network of the project
+func allocateSubnetV6() (string, error) {
 	metadata, err := loadNetworkMetadata()
 	if err != nil {
 		return "", err
 	}
 
-	// Collect all allocated subnets
 	allocatedSubnets := make(map[string]bool)
 	for _, net := range metadata {
-		allocatedSubnets[net.Subnet] = true
+		if net.SubnetV6 != "" {
+			allocatedSubnets[net.SubnetV6] = true
+		}
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
 	}
+	globalID := sha256.Sum256([]byte(cwd))
 
-	// Find first available subnet in the pool
-	// Start from 172.16.0.0/24 and increment
-	// This gives us 4096 possible /24 subnets (172.16.0.0 - 172.31.255.255)
-	for i := 0; i < 4096; i++ {
-		// Calculate subnet: 172.X.Y.0/24
-		thirdOctet := 16 + (i / 256)
-		fourthOctet := i % 256
-		subnet := fmt.Sprintf("172.%d.%d.0/24", thirdOctet, fourthOctet)
+	for subnetID := 0; subnetID < 65536; subnetID++ {
+		prefix := make(net.IP, net.IPv6len)
+		prefix[0] = 0xfd
+		copy(prefix[1:6], globalID[:5])
+		prefix[6] = byte(subnetID >> 8)
+		prefix[7] = byte(subnetID)
 
+		subnet := fmt.Sprintf("%s/64", prefix.String())
 		if !allocatedSubnets[subnet] {
-			logger.Printf("Allocated subnet: %s", subnet)
+			logger.Printf("Allocated IPv6 subnet: %s", subnet)
 			return subnet, nil
 		}
 	}
 
-	return "", fmt.Errorf("no available subnets in pool (172.16.0.0/12)")
+	return "", fmt.Errorf("no available IPv6 subnets in project ULA prefix")
 }
 
-// resolveNetworkSubnet resolves the subnet for a network
-// If subnet is "auto", allocates a new subnet from the pool
-func resolveNetworkSubnet(networkName string, network Network) (string, error) {
-	if network.Subnet != "auto" && network.Subnet != "" {
-		return network.Subnet, nil
+// resolveNetworkSubnetV6 resolves the IPv6 subnet for a dual-stack network
+// Returns an empty string if IPv6 is not enabled on the network
+func resolveNetworkSubnetV6(networkName string, network Network) (string, error) {
+	if !isIPv6Enabled(network) {
+		return "", nil
+	}
+
+	if network.SubnetV6 != "" && network.SubnetV6 != "auto" {
+		ip, ipNet, err := net.ParseCIDR(network.SubnetV6)
+		if err != nil || ip.To4() != nil {
+			return "", fmt.Errorf("subnet_v6 %s is not a valid IPv6 CIDR", network.SubnetV6)
+		}
+		// SLAAC and the DHCPv6 range of dnsmasq need a /64
+		if ones, _ := ipNet.Mask.Size(); ones != 64 {
+			return "", fmt.Errorf("subnet_v6 %s must be a /64", network.SubnetV6)
+		}
+		return network.SubnetV6, nil
 	}
 
-	// Load existing metadata
 	metadata, err := loadNetworkMetadata()
 	if err != nil {
 		return "", err
 	}
 
 	// Check if we already allocated a subnet for this network
-	if existing, exists := metadata[networkName]; exists {
-		logger.Printf("Reusing existing subnet for network %s: %s", networkName, existing.Subnet)
-		return existing.Subnet, nil
+	if existing, exists := metadata[networkName]; exists && existing.SubnetV6 != "" {
+		logger.Printf("Reusing existing IPv6 subnet for network %s: %s", networkName, existing.SubnetV6)
+		return existing.SubnetV6, nil
 	}
 
-	// Allocate a new subnet
-	subnet, err := allocateSubnet()
+	subnet, err := allocateSubnetV6()
 	if err != nil {
 		return "", err
 	}
 
 	// Save the allocation
-	metadata[networkName] = NetworkMetadata{
-		Subnet: subnet,
-		Driver: network.Driver,
+	netMeta := metadata[networkName]
+	netMeta.SubnetV6 = subnet
+	if netMeta.Driver == "" {
+		netMeta.Driver = network.Driver
 	}
+	metadata[networkName] = netMeta
 
 	if err := saveNetworkMetadata(metadata); err != nil {
 		return "", fmt.Errorf("failed to save network metadata: %w", err)
 	}
 
-	logger.Printf("Allocated new subnet for network %s: %s", networkName, subnet)
+	logger.Printf("Allocated new IPv6 subnet for network %s: %s", networkName, subnet)
 	return subnet, nil
 }
 
+// getDNSHostsDir returns the directory of VM names of a bridge network (dnsmasq --hostsdir)
+// Each file is in hosts format: "<ip> <vm> <alias>..."
+func getDNSHostsDir(networkName string) (string, error) {
+	stateDir, err := getNetworkStateDir(networkName)
+	if err != nil {
+		return "", err
+	}
+
+	hostsDir := filepath.Join(stateDir, "dns-hosts")
+	if err := os.MkdirAll(hostsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create DNS hosts directory: %w", err)
+	}
+
+	return hostsDir, nil
+}
+
+// getDNSAliasesDir returns the directory of VM names of a rootless network, read by the switch
+// Each file maps a MAC address to names: "<mac> <vm> <alias>..."
+func getDNSAliasesDir(stateDir string) string {
+	return filepath.Join(stateDir, "dns-aliases")
+}
+
+// registerVMDNSNames registers the VM name and the aliases of a VM network entry in the network's DNS
+// Names are scoped to the network: each network has its own DNS server
+func registerVMDNSNames(vmName string, vm VM, networkIndex int, config *ComposeConfig) error {
+	vmNetwork := vm.Networks[networkIndex]
+	network := config.Networks[vmNetwork.Name]
+	names := append([]string{vmName}, vmNetwork.Aliases...)
+
+	var path, entry string
+	switch {
+	case isRootlessNetwork(network):
+		stateDir, err := getNetworkStateDir(vmNetwork.Name)
+		if err != nil {
+			return err
+		}
+		aliasesDir := getDNSAliasesDir(stateDir)
+		if err := os.MkdirAll(aliasesDir, 0755); err != nil {
+			return fmt.Errorf("failed to create DNS aliases directory: %w", err)
+		}
+		path = filepath.Join(aliasesDir, vmName)
+		entry = fmt.Sprintf("%s %s\n", getVMMACAddress(vmName, vm, networkIndex), strings.Join(names, " "))
+	case getNetworkDriver(network) == "bridge" && !isExternalNetwork(network):
+		address := getReservedVMAddress(vmNetwork.Name, vmName)
+		if address == "" {
+			return fmt.Errorf("no address reserved for VM %s on network %s", vmName, vmNetwork.Name)
+		}
+		hostsDir, err := getDNSHostsDir(vmNetwork.Name)
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(hostsDir, vmName)
+		entry = fmt.Sprintf("%s %s\n", address, strings.Join(names, " "))
+	default:
+		// External bridges and macvtap networks have no qemu-compose DNS
+		return nil
+	}
+
+	if err := os.WriteFile(path, []byte(entry), 0644); err != nil {
+		return fmt.Errorf("failed to write DNS names of VM %s: %w", vmName, err)
+	}
+
+	logger.Printf("Registered DNS names on network %s: %s", vmNetwork.Name, strings.TrimSpace(entry))
+	return nil
+}
+
+// getDHCPHostsDir returns the directory of static DHCP reservations of a network (dnsmasq --dhcp-hostsdir)
+func getDHCPHostsDir(networkName string) (string, error) {
+	stateDir, err := getNetworkStateDir(networkName)
+	if err != nil {
+		return "", err
+	}
+
+	hostsDir := filepath.Join(stateDir, "dhcp-hosts")
+	if err := os.MkdirAll(hostsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create DHCP hosts directory: %w", err)
+	}
+
+	return hostsDir, nil
+}
+
+// getBridgeHostRanges splits the host range of a bridge subnet, as big-endian integers: fixed addresses
+// reserved for VMs come first, the last fifth of the subnet is the dynamic DHCP range of dnsmasq,
+// so that a reservation never collides with a dynamic lease (.10-.199 and .200-.250 in a /24)
+func getBridgeHostRanges(subnet *net.IPNet) (reservedFirst, dynamicFirst, dynamicLast uint32, err error) {
+	first, last, err := getSubnetHostRange(subnet)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	ones, bits := subnet.Mask.Size()
+	size := uint32(1) << uint(bits-ones)
+	dynamicFirst = last - max(size/5, 1) + 1
+	return first, dynamicFirst, last, nil
+}
+
+// getReservedVMAddress returns the address reserved for a VM on a network, or an empty string
+func getReservedVMAddress(networkName, vmName string) string {
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return ""
+	}
+	return metadata[networkName].Addresses[vmName]
+}
+
+// reserveVMAddress returns the fixed IPv4 address of a VM on a bridge network, reserving one if needed
+// The reservation is written as a dnsmasq dhcp-host entry for the VM interface MAC address
+// so the VM always gets the same address
+func reserveVMAddress(networkName, vmName, macAddr string) (string, error) {
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	netMeta, exists := metadata[networkName]
+	if !exists || netMeta.Subnet == "" {
+		return "", fmt.Errorf("no subnet allocated for network %s", networkName)
+	}
+
+	address, reserved := netMeta.Addresses[vmName]
+	if !reserved {
+		_, ipNet, err := net.ParseCIDR(netMeta.Subnet)
+		if err != nil || ipNet.IP.To4() == nil {
+			return "", fmt.Errorf("failed to parse subnet %s", netMeta.Subnet)
+		}
+		reservedFirst, dynamicFirst, _, err := getBridgeHostRanges(ipNet)
+		if err != nil {
+			return "", err
+		}
+
+		used := make(map[string]bool)
+		for _, existing := range netMeta.Addresses {
+			used[existing] = true
+		}
+
+		// Addresses still leased to other interfaces, such as leases from before reservations
+		leases, err := loadNetworkLeases(networkName)
+		if err != nil {
+			logger.Printf("Warning: could not load leases: %v", err)
+		}
+		for _, lease := range leases {
+			if lease.Expiry.After(time.Now()) && !strings.EqualFold(lease.MAC, macAddr) {
+				used[lease.IP] = true
+			}
+		}
+
+		// Reserve outside the dynamic DHCP range
+		for host := reservedFirst; host < dynamicFirst; host++ {
+			candidate := uint32ToIP(host).String()
+			if !used[candidate] {
+				address = candidate
+				break
+			}
+		}
+		if address == "" {
+			return "", fmt.Errorf("no free address left on network %s", networkName)
+		}
+
+		if netMeta.Addresses == nil {
+			netMeta.Addresses = make(map[string]string)
+		}
+		netMeta.Addresses[vmName] = address
+		metadata[networkName] = netMeta
+
+		if err := saveNetworkMetadata(metadata); err != nil {
+			return "", fmt.Errorf("failed to save network metadata: %w", err)
+		}
+		logger.Printf("Reserved IP %s for VM %s on network %s", address, vmName, networkName)
+	}
+
+	hostsDir, err := getDHCPHostsDir(networkName)
+	if err != nil {
+		return "", err
+	}
+
+	entry := fmt.Sprintf("%s,%s,%s\n", macAddr, address, vmName)
+	if err := os.WriteFile(filepath.Join(hostsDir, vmName), []byte(entry), 0644); err != nil {
+		return "", fmt.Errorf("failed to write DHCP reservation: %w", err)
+	}
+
+	return address, nil
+}
+
+// releaseVMAddresses releases the fixed addresses, DHCP reservations and DNS names of a VM on its
+// networks, so that a destroyed VM neither keeps its addresses nor keeps resolving
+func releaseVMAddresses(vmName string, vm VM) error {
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return err
+	}
+
+	for _, networkName := range getVMNetworkNames(vm) {
+		if netMeta, exists := metadata[networkName]; exists && netMeta.Addresses[vmName] != "" {
+			logger.Printf("Releasing IP %s of VM %s on network %s", netMeta.Addresses[vmName], vmName, networkName)
+			delete(netMeta.Addresses, vmName)
+			metadata[networkName] = netMeta
+		}
+
+		stateDir, err := getNetworkStateDir(networkName)
+		if err != nil {
+			return err
+		}
+		for _, dir := range []string{"dhcp-hosts", "dns-hosts", "dns-aliases"} {
+			path := filepath.Join(stateDir, dir, vmName)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+	}
+
+	if err := saveNetworkMetadata(metadata); err != nil {
+		return fmt.Errorf("failed to save network metadata: %w", err)
+	}
+	return nil
+}
+
 // getDnsmasqUnitName returns the systemd unit name for a network's dnsmasq instance
 func getDnsmasqUnitName(networkName string) string {
-	projectName := getProjectName()
-	sanitizedProject := strings.ReplaceAll(projectName, " ", "-")
 	sanitizedNetwork := strings.ReplaceAll(networkName, " ", "-")
-	return fmt.Sprintf("qemu-compose-dnsmasq-%s-%s", sanitizedProject, sanitizedNetwork)
+	return fmt.Sprintf("qemu-compose-dnsmasq-%s-%s", getProjectUnitID(), sanitizedNetwork)
 }
 
 // getVMIPAddress returns the IP address assigned to a VM via DHCP
 // Returns empty string if IP cannot be determined
-func getVMIPAddress(vmName string, vm VM) string {
+func getVMIPAddress(vmName string, vm VM, config *ComposeConfig) string {
 	// Only works for bridge networking
 	if len(vm.Networks) == 0 {
 		return ""
 	}
 
+	networkName := vm.Networks[0].Name
+
+	// Managed bridge networks reserve a fixed address per VM
+	if address := getReservedVMAddress(networkName, vmName); address != "" {
+		logger.Printf("Found reserved IP %s for VM %s", address, vmName)
+		return address
+	}
+
+	// Leases are kept in the network state directory by the switch and dnsmasq
+	leases, err := loadNetworkLeases(networkName)
+	if err != nil {
+		logger.Printf("Failed to load leases for network %s: %v", networkName, err)
+	}
+
+	macAddr := getVMMACAddress(vmName, vm, 0)
+	for _, lease := range leases {
+		if lease.MAC == macAddr {
+			logger.Printf("Found IP %s for VM %s (MAC: %s)", lease.IP, vmName, macAddr)
+			return lease.IP
+		}
+	}
+
+	if isRootlessNetwork(config.Networks[networkName]) {
+		logger.Printf("No DHCP lease found for VM %s", vmName)
+		return ""
+	}
+
 	// Get the first network's dnsmasq unit
-	networkName := vm.Networks[0]
 	unitName := getDnsmasqUnitName(networkName)
 
 	// Check if dnsmasq is running
@@ -227,79 +663,60 @@ func getVMIPAddress(vmName string, vm VM) string {
 }
 
 // startDnsmasq starts a dnsmasq instance for a network
-func startDnsmasq(networkName string, subnet string) error {
+// If subnetV6 is not empty, dnsmasq also sends router advertisements and serves DHCPv6
+func startDnsmasq(networkName string, subnet string, subnetV6 string) error {
 	bridgeName := getBridgeName(networkName)
 	unitName := getDnsmasqUnitName(networkName)
 
 	logger.Printf("Starting dnsmasq for network %s (bridge: %s, subnet: %s)", networkName, bridgeName, subnet)
 
-	// Check if dnsmasq is already running
-	if isDnsmasqRunning(networkName) {
-		logger.Printf("Dnsmasq already running for network: %s", networkName)
-		return nil
-	}
+	installed := isUserUnitInstalled(unitName)
 
-	// Parse subnet to get DHCP range
-	ip, ipNet, err := net.ParseCIDR(subnet)
-	if err != nil {
-		return fmt.Errorf("failed to parse subnet %s: %w", subnet, err)
+	if installed {
+		if err := stopSystemDnsmasq(networkName); err != nil {
+			return err
+		}
 	}
 
-	// Calculate DHCP range: .10 to .250
-	ip4 := ip.To4()
-	if ip4 == nil {
-		return fmt.Errorf("subnet %s is not a valid IPv4 address", subnet)
+	// Check if dnsmasq is already running
+	if isDnsmasqUnitActive(unitName, installed) {
+		logger.Printf("Dnsmasq already running for network: %s", networkName)
+		return nil
 	}
 
-	startIP := make(net.IP, 4)
-	endIP := make(net.IP, 4)
-	gateway := make(net.IP, 4)
-
-	copy(startIP, ip4)
-	copy(endIP, ip4)
-	copy(gateway, ip4)
-
-	startIP[3] = 10
-	endIP[3] = 250
-	gateway[3] = 1
-
-	dhcpRange := fmt.Sprintf("%s,%s,12h", startIP.String(), endIP.String())
-
-	// Get network mask
-	ones, _ := ipNet.Mask.Size()
-	_ = ones // We'll use the mask directly
-	netmask := net.IP(ipNet.Mask).String()
+	// An installed unit runs dnsmasq under the user manager
+	if installed {
+		logger.Printf("Dnsmasq for network %s has an installed unit, starting it", networkName)
+		cmd := exec.Command("systemctl", "--user", "start", "--no-block", unitName)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to start dnsmasq unit %s: %w\nOutput: %s", unitName, err, string(output))
+		}
+	} else {
+		dnsmasqArgs, err := getDnsmasqCommand(networkName, subnet, subnetV6)
+		if err != nil {
+			return err
+		}
 
-	// Build dnsmasq command - requires sudo to bind to port 67 (DHCP)
-	args := []string{
-		"sudo",
-		"systemd-run",
-		"--system", // Use system manager (requires sudo)
-		"--unit=" + unitName,
-		"--description=qemu-compose dnsmasq for network: " + networkName,
-		"--collect",
-		"--property=KillMode=mixed",
-		"--property=Type=simple",
-		"dnsmasq",
-		"--interface=" + bridgeName,
-		"--bind-interfaces",
-		"--dhcp-range=" + dhcpRange,
-		"--dhcp-option=1," + netmask,          // Subnet mask
-		"--dhcp-option=3," + gateway.String(), // Gateway
-		"--dhcp-option=6," + gateway.String(), // DNS server (bridge IP)
-		"--port=0",                            // Disable DNS
-		"--leasefile-ro",                      // Don't write lease file (read-only mode)
-		"--no-daemon",
-		"--log-dhcp",
-		"--log-facility=-", // Log to stderr (captured by systemd)
-	}
+		// Build systemd-run command - dnsmasq requires sudo to bind to port 67 (DHCP)
+		args := []string{
+			"sudo",
+			"systemd-run",
+			"--system", // Use system manager (requires sudo)
+			"--unit=" + unitName,
+			"--description=qemu-compose dnsmasq for network: " + networkName,
+			"--collect",
+			"--property=KillMode=mixed",
+			"--property=Type=simple",
+		}
+		args = append(args, dnsmasqArgs...)
 
-	logger.Printf("Executing: %s", strings.Join(args, " "))
+		logger.Printf("Executing: %s", strings.Join(args, " "))
 
-	cmd := exec.Command(args[0], args[1:]...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to start dnsmasq (requires sudo): %w\nOutput: %s\n\nTo enable passwordless sudo for dnsmasq, run: qemu-compose doctor", err, string(output))
+		cmd := exec.Command(args[0], args[1:]...)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to start dnsmasq (requires sudo): %w\nOutput: %s\n\nTo enable passwordless sudo for dnsmasq, run: qemu-compose doctor", err, string(output))
+		}
 	}
 
 	// Update metadata
@@ -321,17 +738,110 @@ func startDnsmasq(networkName string, subnet string) error {
 	return nil
 }
 
-// stopDnsmasq stops the dnsmasq instance for a network
-func stopDnsmasq(networkName string) error {
-	unitName := getDnsmasqUnitName(networkName)
-	logger.Printf("Stopping dnsmasq for network: %s (unit: %s)", networkName, unitName)
+// getDnsmasqCommand returns the dnsmasq command line serving DHCP and DNS on a network's bridge
+// If subnetV6 is not empty, dnsmasq also sends router advertisements and serves DHCPv6
+func getDnsmasqCommand(networkName string, subnet string, subnetV6 string) ([]string, error) {
+	bridgeName := getBridgeName(networkName)
 
-	cmd := exec.Command("sudo", "systemctl", "stop", unitName)
-	output, err := cmd.CombinedOutput()
+	// Parse subnet to get DHCP range
+	_, ipNet, err := net.ParseCIDR(subnet)
 	if err != nil {
-		// Don't fail if unit doesn't exist
-		if !strings.Contains(string(output), "not loaded") && !strings.Contains(string(output), "not found") {
-			logger.Printf("Warning: failed to stop dnsmasq unit %s: %v", unitName, err)
+		return nil, fmt.Errorf("failed to parse subnet %s: %w", subnet, err)
+	}
+	if ipNet.IP.To4() == nil {
+		return nil, fmt.Errorf("subnet %s is not a valid IPv4 address", subnet)
+	}
+
+	// The dynamic DHCP range is the end of the subnet, reserved addresses are below
+	_, dynamicFirst, dynamicLast, err := getBridgeHostRanges(ipNet)
+	if err != nil {
+		return nil, err
+	}
+	dhcpRange := fmt.Sprintf("%s,%s,12h", uint32ToIP(dynamicFirst), uint32ToIP(dynamicLast))
+	gateway := getSubnetGateway(ipNet)
+
+	// Get network mask
+	netmask := net.IP(ipNet.Mask).String()
+
+	// Static reservations, dnsmasq picks up new files automatically
+	hostsDir, err := getDHCPHostsDir(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	// VM names and aliases, dnsmasq picks up new files automatically
+	dnsHostsDir, err := getDNSHostsDir(networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	stateDir, err := getNetworkStateDir(networkName)
+	if err != nil {
+		return nil, err
+	}
+	leasesPath := filepath.Join(stateDir, "leases")
+
+	args := []string{
+		"dnsmasq",
+		"--interface=" + bridgeName,
+		"--bind-interfaces",
+		"--dhcp-range=" + dhcpRange,
+		"--dhcp-hostsdir=" + hostsDir,
+		"--dhcp-option=1," + netmask,          // Subnet mask
+		"--dhcp-option=3," + gateway.String(), // Gateway
+		"--dhcp-option=6," + gateway.String(), // DNS server (bridge IP)
+		"--hostsdir=" + dnsHostsDir,           // VM names and aliases of this network
+		"--no-hosts",                          // Don't serve the host's /etc/hosts
+		"--domain-needed",                     // Don't forward unqualified names
+		"--except-interface=lo",               // Each network's DNS only listens on its bridge
+		"--dhcp-leasefile=" + leasesPath,      // Leases read by "network inspect"
+		"--no-daemon",
+		"--log-dhcp",
+		"--log-facility=-", // Log to stderr (captured by systemd)
+	}
+
+	// Dual-stack: router advertisements (managed flag) and stateful DHCPv6
+	if subnetV6 != "" {
+		prefix, _, err := net.ParseCIDR(subnetV6)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse IPv6 subnet %s: %w", subnetV6, err)
+		}
+
+		startIPv6 := make(net.IP, net.IPv6len)
+		endIPv6 := make(net.IP, net.IPv6len)
+		copy(startIPv6, prefix)
+		copy(endIPv6, prefix)
+		startIPv6[15] = 0x10
+		endIPv6[14] = 0xff
+		endIPv6[15] = 0xff
+
+		args = append(args,
+			"--enable-ra",
+			fmt.Sprintf("--dhcp-range=%s,%s,64,12h", startIPv6.String(), endIPv6.String()),
+			"--dhcp-option=option6:dns-server,[::]", // DNS server (bridge IPv6 address)
+		)
+	}
+
+	return args, nil
+}
+
+// stopDnsmasq stops the dnsmasq instance for a network
+func stopDnsmasq(networkName string) error {
+	unitName := getDnsmasqUnitName(networkName)
+	logger.Printf("Stopping dnsmasq for network: %s (unit: %s)", networkName, unitName)
+
+	// dnsmasq may run under both managers: started by systemd-run, then by an installed unit
+	commands := [][]string{
+		{"sudo", "systemctl", "stop", unitName},
+		{"systemctl", "--user", "stop", unitName},
+	}
+	for _, args := range commands {
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			// Don't fail if unit doesn't exist
+			if !strings.Contains(string(output), "not loaded") && !strings.Contains(string(output), "not found") {
+				logger.Printf("Warning: failed to stop dnsmasq unit %s: %v", unitName, err)
+			}
 		}
 	}
 
@@ -353,11 +863,35 @@ func stopDnsmasq(networkName string) error {
 	return nil
 }
 
-// isDnsmasqRunning checks if dnsmasq is running for a network
+// stopSystemDnsmasq stops a dnsmasq started by systemd-run before the unit of its network was
+// installed: it would hold the DHCP and DNS ports of the bridge, the installed unit replaces it
+func stopSystemDnsmasq(networkName string) error {
+	unitName := getDnsmasqUnitName(networkName)
+	if !isDnsmasqUnitActive(unitName, false) {
+		return nil
+	}
+
+	logger.Printf("Stopping dnsmasq of network %s under the system manager, replaced by the installed unit", networkName)
+	cmd := exec.Command("sudo", "systemctl", "stop", unitName)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to stop dnsmasq unit %s: %w\nOutput: %s", unitName, err, string(output))
+	}
+	return nil
+}
+
+// isDnsmasqRunning checks if dnsmasq is running for a network, under the system or the user manager
 func isDnsmasqRunning(networkName string) bool {
 	unitName := getDnsmasqUnitName(networkName)
+	return isDnsmasqUnitActive(unitName, false) || isDnsmasqUnitActive(unitName, true)
+}
 
+// isDnsmasqUnitActive checks if a dnsmasq unit is active under the user manager (installed unit)
+// or under the system manager (started by systemd-run)
+func isDnsmasqUnitActive(unitName string, user bool) bool {
 	cmd := exec.Command("sudo", "systemctl", "is-active", unitName)
+	if user {
+		cmd = exec.Command("systemctl", "--user", "is-active", unitName)
+	}
 	output, err := cmd.Output()
 
 	if err != nil {
@@ -367,9 +901,174 @@ func isDnsmasqRunning(networkName string) bool {
 	return strings.TrimSpace(string(output)) == "active"
 }
 
+// getSwitchUnitName returns the systemd unit name for a rootless network's switch
+func getSwitchUnitName(networkName string) string {
+	sanitizedNetwork := strings.ReplaceAll(networkName, " ", "-")
+	return fmt.Sprintf("qemu-compose-switch-%s-%s", getProjectUnitID(), sanitizedNetwork)
+}
+
+// getSwitchSocketPath returns the Unix socket QEMU connects to for a rootless network
+func getSwitchSocketPath(networkName string) (string, error) {
+	stateDir, err := getNetworkStateDir(networkName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, "switch.sock"), nil
+}
+
+// isNetworkSwitchRunning checks if the userspace switch is running for a network
+func isNetworkSwitchRunning(networkName string) bool {
+	unitName := getSwitchUnitName(networkName)
+
+	cmd := exec.Command("systemctl", "--user", "is-active", unitName)
+	output, err := cmd.Output()
+
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(output)) == "active"
+}
+
+// startNetworkSwitch starts the userspace switch (with built-in DHCP and DNS) for a rootless network
+func startNetworkSwitch(networkName string, network Network) error {
+	unitName := getSwitchUnitName(networkName)
+
+	if isNetworkSwitchRunning(networkName) {
+		logger.Printf("Switch already running for network: %s", networkName)
+		return nil
+	}
+
+	subnet, err := resolveNetworkSubnet(networkName, network)
+	if err != nil {
+		return fmt.Errorf("failed to resolve subnet for network %s: %w", networkName, err)
+	}
+
+	// Refuse subnets the switch cannot hand out addresses in, before starting it
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("failed to parse subnet %s: %w", subnet, err)
+	}
+	if _, _, err := getSubnetHostRange(ipNet); err != nil {
+		return fmt.Errorf("network %s: %w", networkName, err)
+	}
+
+	if isIPv6Enabled(network) {
+		logger.Printf("Warning: IPv6 is not supported on rootless network %s, ignoring", networkName)
+	}
+
+	stateDir, err := getNetworkStateDir(networkName)
+	if err != nil {
+		return err
+	}
+
+	socketPath, err := getSwitchSocketPath(networkName)
+	if err != nil {
+		return err
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to determine executable path: %w", err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	logger.Printf("Starting switch for network %s (subnet: %s, socket: %s)", networkName, subnet, socketPath)
+
+	// The switch runs unprivileged in the user's systemd session
+	args := []string{
+		"systemd-run",
+		"--user",
+		"--unit=" + unitName,
+		"--description=qemu-compose switch for network: " + networkName,
+		"--collect",
+		"--working-directory=" + cwd,
+		"--property=KillMode=mixed",
+		"--property=Type=simple",
+		execPath,
+	}
+	if debug {
+		args = append(args, "--debug")
+	}
+	args = append(args,
+		"network", "switch",
+		"--network", networkName,
+		"--subnet", subnet,
+		"--socket", socketPath,
+		"--state-dir", stateDir,
+	)
+
+	logger.Printf("Executing: %s", strings.Join(args, " "))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start switch: %w\nOutput: %s", err, string(output))
+	}
+
+	// Wait for the socket so QEMU can connect as soon as the VM starts
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout waiting for switch socket: %s", socketPath)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Update metadata
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return err
+	}
+
+	if netMeta, exists := metadata[networkName]; exists {
+		netMeta.SwitchUnit = unitName
+		metadata[networkName] = netMeta
+		if err := saveNetworkMetadata(metadata); err != nil {
+			logger.Printf("Warning: failed to save switch metadata: %v", err)
+		}
+	}
+
+	logger.Printf("Switch started successfully for network: %s (unit: %s)", networkName, unitName)
+	return nil
+}
+
+// stopNetworkSwitch stops the userspace switch for a rootless network
+func stopNetworkSwitch(networkName string) error {
+	unitName := getSwitchUnitName(networkName)
+	logger.Printf("Stopping switch for network: %s (unit: %s)", networkName, unitName)
+
+	cmd := exec.Command("systemctl", "--user", "stop", unitName)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// Don't fail if unit doesn't exist
+		if !strings.Contains(string(output), "not loaded") && !strings.Contains(string(output), "not found") {
+			logger.Printf("Warning: failed to stop switch unit %s: %v", unitName, err)
+		}
+	}
+
+	logger.Printf("Switch stopped for network: %s", networkName)
+	return nil
+}
+
+// getNATRuleComment returns the iptables comment tagging the rules of a bridge
+// Tagged rules can be listed by "network inspect" and removed by "network prune"
+func getNATRuleComment(bridgeName string) string {
+	return "qemu-compose:" + bridgeName
+}
+
 // setupNAT configures NAT/masquerading for a bridge network to enable internet access
+// Forwarding is allowed by setupForwardRules
 func setupNAT(networkName string, subnet string) error {
 	bridgeName := getBridgeName(networkName)
+	ruleComment := getNATRuleComment(bridgeName)
 	logger.Printf("Setting up NAT for network %s (bridge: %s, subnet: %s)", networkName, bridgeName, subnet)
 
 	// Enable IP forwarding
@@ -379,12 +1078,12 @@ func setupNAT(networkName string, subnet string) error {
 	}
 	logger.Printf("IP forwarding enabled")
 
-	// Add NAT rule (MASQUERADE)
+	// Add NAT rule (MASQUERADE), traffic to other qemu-compose networks keeps its source address
 	// Check if rule already exists first
-	checkCmd := exec.Command("sudo", "iptables", "-t", "nat", "-C", "POSTROUTING", "-s", subnet, "-j", "MASQUERADE")
+	checkCmd := exec.Command("sudo", "iptables", "-t", "nat", "-C", "POSTROUTING", "-s", subnet, "!", "-o", managedBridgePattern, "-m", "comment", "--comment", ruleComment, "-j", "MASQUERADE")
 	if err := checkCmd.Run(); err != nil {
 		// Rule doesn't exist, add it
-		cmd = exec.Command("sudo", "iptables", "-t", "nat", "-A", "POSTROUTING", "-s", subnet, "-j", "MASQUERADE")
+		cmd = exec.Command("sudo", "iptables", "-t", "nat", "-A", "POSTROUTING", "-s", subnet, "!", "-o", managedBridgePattern, "-m", "comment", "--comment", ruleComment, "-j", "MASQUERADE")
 		if output, err := cmd.CombinedOutput(); err != nil {
 			return fmt.Errorf("failed to add NAT rule: %w\nOutput: %s", err, string(output))
 		}
@@ -393,26 +1092,6 @@ func setupNAT(networkName string, subnet string) error {
 		logger.Printf("NAT rule already exists for subnet: %s", subnet)
 	}
 
-	// Allow forwarding from bridge
-	checkCmd = exec.Command("sudo", "iptables", "-C", "FORWARD", "-i", bridgeName, "-j", "ACCEPT")
-	if err := checkCmd.Run(); err != nil {
-		cmd = exec.Command("sudo", "iptables", "-A", "FORWARD", "-i", bridgeName, "-j", "ACCEPT")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to add forward rule (input): %w\nOutput: %s", err, string(output))
-		}
-		logger.Printf("Added forward rule for bridge input: %s", bridgeName)
-	}
-
-	// Allow forwarding to bridge
-	checkCmd = exec.Command("sudo", "iptables", "-C", "FORWARD", "-o", bridgeName, "-j", "ACCEPT")
-	if err := checkCmd.Run(); err != nil {
-		cmd = exec.Command("sudo", "iptables", "-A", "FORWARD", "-o", bridgeName, "-j", "ACCEPT")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to add forward rule (output): %w\nOutput: %s", err, string(output))
-		}
-		logger.Printf("Added forward rule for bridge output: %s", bridgeName)
-	}
-
 	logger.Printf("NAT setup completed for network: %s", networkName)
 	return nil
 }
@@ -420,10 +1099,11 @@ func setupNAT(networkName string, subnet string) error {
 // cleanupNAT removes NAT rules for a bridge network
 func cleanupNAT(networkName string, subnet string) error {
 	bridgeName := getBridgeName(networkName)
+	ruleComment := getNATRuleComment(bridgeName)
 	logger.Printf("Cleaning up NAT for network %s (bridge: %s, subnet: %s)", networkName, bridgeName, subnet)
 
 	// Remove NAT rule
-	cmd := exec.Command("sudo", "iptables", "-t", "nat", "-D", "POSTROUTING", "-s", subnet, "-j", "MASQUERADE")
+	cmd := exec.Command("sudo", "iptables", "-t", "nat", "-D", "POSTROUTING", "-s", subnet, "!", "-o", managedBridgePattern, "-m", "comment", "--comment", ruleComment, "-j", "MASQUERADE")
 	if output, err := cmd.CombinedOutput(); err != nil {
 		// Don't fail if rule doesn't exist
 		if !strings.Contains(string(output), "does a matching rule exist") {
@@ -431,39 +1111,249 @@ func cleanupNAT(networkName string, subnet string) error {
 		}
 	}
 
-	// Remove forward rules
-	cmd = exec.Command("sudo", "iptables", "-D", "FORWARD", "-i", bridgeName, "-j", "ACCEPT")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		if !strings.Contains(string(output), "does a matching rule exist") {
-			logger.Printf("Warning: failed to remove forward rule (input): %v", err)
+	logger.Printf("NAT cleanup completed for network: %s", networkName)
+	return nil
+}
+
+// setupNAT6 configures IPv6 forwarding and NAT66 for a dual-stack bridge network
+func setupNAT6(networkName string, subnetV6 string) error {
+	bridgeName := getBridgeName(networkName)
+	ruleComment := getNATRuleComment(bridgeName)
+	logger.Printf("Setting up NAT66 for network %s (bridge: %s, subnet: %s)", networkName, bridgeName, subnetV6)
+
+	// The previous host settings are recorded, to be restored when the last dual-stack network is removed
+	err := updateIPv6Sysctls(func(state *IPv6Sysctls) error {
+		if !slices.Contains(state.Bridges, bridgeName) {
+			state.Bridges = append(state.Bridges, bridgeName)
+		}
+
+		// Forwarding makes interfaces with accept_ra=1 ignore router advertisements, keep them accepted
+		// so that the host does not lose its IPv6 default route
+		if data, err := os.ReadFile("/proc/sys/net/ipv6/conf/all/forwarding"); err == nil && strings.TrimSpace(string(data)) == "0" {
+			state.Forwarding = "0"
+			if err := keepAcceptingRouterAdvertisements(state); err != nil {
+				return err
+			}
+		}
+
+		// Enable IPv6 forwarding, the kernel only forwards IPv6 when it is enabled for all interfaces
+		cmd := exec.Command("sudo", "sysctl", "-w", "net.ipv6.conf.all.forwarding=1")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to enable IPv6 forwarding: %w\nOutput: %s", err, string(output))
 		}
+		logger.Printf("IPv6 forwarding enabled")
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	cmd = exec.Command("sudo", "iptables", "-D", "FORWARD", "-o", bridgeName, "-j", "ACCEPT")
+	// Add NAT66 rule (MASQUERADE), ULA prefixes are not routable on the internet
+	checkCmd := exec.Command("sudo", "ip6tables", "-t", "nat", "-C", "POSTROUTING", "-s", subnetV6, "!", "-o", managedBridgePattern, "-m", "comment", "--comment", ruleComment, "-j", "MASQUERADE")
+	if err := checkCmd.Run(); err != nil {
+		cmd := exec.Command("sudo", "ip6tables", "-t", "nat", "-A", "POSTROUTING", "-s", subnetV6, "!", "-o", managedBridgePattern, "-m", "comment", "--comment", ruleComment, "-j", "MASQUERADE")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to add NAT66 rule: %w\nOutput: %s", err, string(output))
+		}
+		logger.Printf("Added NAT66 rule for subnet: %s", subnetV6)
+	} else {
+		logger.Printf("NAT66 rule already exists for subnet: %s", subnetV6)
+	}
+
+	logger.Printf("NAT66 setup completed for network: %s", networkName)
+	return nil
+}
+
+// keepAcceptingRouterAdvertisements sets accept_ra=2 on the host interfaces with accept_ra=1 (and
+// the default of new interfaces), so that they still accept router advertisements with forwarding
+// Bridges and TAP devices of qemu-compose are skipped: the host must not learn routes from dnsmasq
+// The interfaces are recorded in state, the first recorded value is kept
+func keepAcceptingRouterAdvertisements(state *IPv6Sysctls) error {
+	entries, err := os.ReadDir("/proc/sys/net/ipv6/conf")
+	if err != nil {
+		return fmt.Errorf("failed to list IPv6 interface settings: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == "all" || name == "lo" || strings.HasPrefix(name, "qc-") || strings.HasPrefix(name, "qt-") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join("/proc/sys/net/ipv6/conf", name, "accept_ra"))
+		if err != nil || strings.TrimSpace(string(data)) != "1" {
+			continue
+		}
+
+		// The slash form supports interface names with dots (VLANs)
+		setting := fmt.Sprintf("net/ipv6/conf/%s/accept_ra=2", name)
+		cmd := exec.Command("sudo", "sysctl", "-w", setting)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to keep router advertisements on %s: %w\nOutput: %s", name, err, string(output))
+		}
+		logger.Printf("Set accept_ra=2 on %s", name)
+
+		if state.AcceptRA == nil {
+			state.AcceptRA = make(map[string]string)
+		}
+		if _, recorded := state.AcceptRA[name]; !recorded {
+			state.AcceptRA[name] = "1"
+		}
+	}
+
+	return nil
+}
+
+// IPv6Sysctls records the host IPv6 settings changed for dual-stack networks, in the user-level data
+// directory since networks of all projects share them
+type IPv6Sysctls struct {
+	Forwarding string            `json:"forwarding,omitempty"` // Previous net.ipv6.conf.all.forwarding, empty if it was already enabled
+	AcceptRA   map[string]string `json:"accept_ra,omitempty"`  // Interface -> previous accept_ra
+	Bridges    []string          `json:"bridges,omitempty"`    // Bridges of the dual-stack networks relying on the settings
+}
+
+// updateIPv6Sysctls loads the recorded IPv6 settings, applies fn and saves them back
+// The file is locked for the whole operation, and removed once no bridge relies on the settings
+func updateIPv6Sysctls(fn func(state *IPv6Sysctls) error) error {
+	dataDir, err := getUserDataDir()
+	if err != nil {
+		return err
+	}
+	statePath := filepath.Join(dataDir, "ipv6-sysctls.json")
+
+	lockFile, err := os.OpenFile(statePath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open IPv6 settings lock: %w", err)
+	}
+	defer lockFile.Close()
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock IPv6 settings: %w", err)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	var state IPv6Sysctls
+	data, err := os.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read IPv6 settings: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("failed to parse IPv6 settings: %w", err)
+		}
+	}
+
+	if err := fn(&state); err != nil {
+		return err
+	}
+
+	if len(state.Bridges) == 0 {
+		if err := os.Remove(statePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove IPv6 settings: %w", err)
+		}
+		return nil
+	}
+
+	data, err = json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal IPv6 settings: %w", err)
+	}
+	if err := os.WriteFile(statePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write IPv6 settings: %w", err)
+	}
+	return nil
+}
+
+// restoreIPv6Sysctls restores the recorded accept_ra values and IPv6 forwarding of the host
+// accept_ra is only restored on interfaces still set to 2, other values were changed by someone else
+func restoreIPv6Sysctls(state *IPv6Sysctls) {
+	for name, value := range state.AcceptRA {
+		data, err := os.ReadFile(filepath.Join("/proc/sys/net/ipv6/conf", name, "accept_ra"))
+		if err != nil || strings.TrimSpace(string(data)) != "2" {
+			continue
+		}
+
+		setting := fmt.Sprintf("net/ipv6/conf/%s/accept_ra=%s", name, value)
+		cmd := exec.Command("sudo", "sysctl", "-w", setting)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logger.Printf("Warning: failed to restore accept_ra on %s: %v\nOutput: %s", name, err, string(output))
+			continue
+		}
+		logger.Printf("Restored accept_ra=%s on %s", value, name)
+	}
+
+	if state.Forwarding != "" {
+		cmd := exec.Command("sudo", "sysctl", "-w", "net.ipv6.conf.all.forwarding="+state.Forwarding)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			logger.Printf("Warning: failed to restore IPv6 forwarding: %v\nOutput: %s", err, string(output))
+		} else {
+			logger.Printf("Restored net.ipv6.conf.all.forwarding=%s", state.Forwarding)
+		}
+	}
+
+	state.Forwarding = ""
+	state.AcceptRA = nil
+}
+
+// cleanupNAT6 removes NAT66 rules for a dual-stack bridge network
+func cleanupNAT6(networkName string, subnetV6 string) error {
+	bridgeName := getBridgeName(networkName)
+	ruleComment := getNATRuleComment(bridgeName)
+	logger.Printf("Cleaning up NAT66 for network %s (bridge: %s, subnet: %s)", networkName, bridgeName, subnetV6)
+
+	cmd := exec.Command("sudo", "ip6tables", "-t", "nat", "-D", "POSTROUTING", "-s", subnetV6, "!", "-o", managedBridgePattern, "-m", "comment", "--comment", ruleComment, "-j", "MASQUERADE")
 	if output, err := cmd.CombinedOutput(); err != nil {
 		if !strings.Contains(string(output), "does a matching rule exist") {
-			logger.Printf("Warning: failed to remove forward rule (output): %v", err)
+			logger.Printf("Warning: failed to remove NAT66 rule: %v", err)
 		}
 	}
 
-	logger.Printf("NAT cleanup completed for network: %s", networkName)
+	// Restore the host settings when no other dual-stack network relies on them, bridges deleted
+	// without cleanup (host reboot) no longer count
+	err := updateIPv6Sysctls(func(state *IPv6Sysctls) error {
+		var bridges []string
+		for _, bridge := range state.Bridges {
+			if bridge == bridgeName {
+				continue
+			}
+			if _, err := netlink.LinkByName(bridge); err != nil {
+				continue
+			}
+			bridges = append(bridges, bridge)
+		}
+		state.Bridges = bridges
+
+		if len(state.Bridges) == 0 {
+			restoreIPv6Sysctls(state)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Printf("Warning: failed to restore IPv6 settings: %v", err)
+	}
+
+	logger.Printf("NAT66 cleanup completed for network: %s", networkName)
 	return nil
 }
 
 // createBridge creates a network bridge interface
-func createBridge(networkName string, config *ComposeConfig) error {
+// withDnsmasq is false when dnsmasq is started separately, by an installed unit
+func createBridge(networkName string, config *ComposeConfig, withDnsmasq bool) error {
 	network, exists := config.Networks[networkName]
 	if !exists {
 		return fmt.Errorf("network not found in config: %s", networkName)
 	}
 
 	bridgeName := getBridgeName(networkName)
+	bridgeOwner := getInterfaceOwner("network", networkName)
 	logger.Printf("Creating bridge: %s", bridgeName)
 
-	// Check if bridge already exists
+	// Check if bridge already exists, and that it belongs to this project network
 	bridgeExists := true
-	if _, err := netlink.LinkByName(bridgeName); err != nil {
+	if link, err := netlink.LinkByName(bridgeName); err != nil {
 		bridgeExists = false
+	} else if err := checkInterfaceOwner(link, bridgeOwner); err != nil {
+		return err
 	}
 
 	if !bridgeExists {
@@ -478,6 +1368,11 @@ func createBridge(networkName string, config *ComposeConfig) error {
 			return fmt.Errorf("failed to create bridge %s: %w", bridgeName, err)
 		}
 
+		// Tag the bridge with its owner to detect name collisions
+		if err := netlink.LinkSetAlias(bridge, bridgeOwner); err != nil {
+			return fmt.Errorf("failed to set alias on bridge %s: %w", bridgeName, err)
+		}
+
 		// Set bridge up
 		if err := netlink.LinkSetUp(bridge); err != nil {
 			return fmt.Errorf("failed to bring up bridge %s: %w", bridgeName, err)
@@ -492,6 +1387,16 @@ func createBridge(networkName string, config *ComposeConfig) error {
 		return fmt.Errorf("failed to resolve subnet for network %s: %w", networkName, err)
 	}
 
+	// Resolve IPv6 subnet for dual-stack networks (handles ULA allocation)
+	subnetV6, err := resolveNetworkSubnetV6(networkName, network)
+	if err != nil {
+		return fmt.Errorf("failed to resolve IPv6 subnet for network %s: %w", networkName, err)
+	}
+
+	if err := recordBridgeName(networkName, bridgeName); err != nil {
+		logger.Printf("Warning: failed to record bridge name for network %s: %v", networkName, err)
+	}
+
 	// Get bridge link
 	bridge, err := netlink.LinkByName(bridgeName)
 	if err != nil {
@@ -514,10 +1419,28 @@ func createBridge(networkName string, config *ComposeConfig) error {
 		}
 		logger.Printf("Assigned IP %s to bridge %s", bridgeIPStr, bridgeName)
 
+		// Assign IPv6 address to bridge for dual-stack networks
+		if subnetV6 != "" {
+			bridgeIPv6Str := getBridgeIPv6(subnetV6)
+			addr, err := netlink.ParseAddr(bridgeIPv6Str)
+			if err != nil {
+				return fmt.Errorf("failed to parse bridge IPv6 %s: %w", bridgeIPv6Str, err)
+			}
+
+			if err := netlink.AddrAdd(bridge, addr); err != nil {
+				if !strings.Contains(err.Error(), "file exists") {
+					return fmt.Errorf("failed to assign IPv6 to bridge %s: %w", bridgeName, err)
+				}
+			}
+			logger.Printf("Assigned IPv6 %s to bridge %s", bridgeIPv6Str, bridgeName)
+		}
+
 		// Start dnsmasq for this network
-		if err := startDnsmasq(networkName, subnet); err != nil {
-			logger.Printf("Warning: failed to start dnsmasq for network %s: %v", networkName, err)
-			// Don't fail bridge creation if dnsmasq fails
+		if withDnsmasq {
+			if err := startDnsmasq(networkName, subnet, subnetV6); err != nil {
+				logger.Printf("Warning: failed to start dnsmasq for network %s: %v", networkName, err)
+				// Don't fail bridge creation if dnsmasq fails
+			}
 		}
 
 		// Setup NAT for internet access
@@ -525,6 +1448,17 @@ func createBridge(networkName string, config *ComposeConfig) error {
 			logger.Printf("Warning: failed to setup NAT for network %s: %v", networkName, err)
 			// Don't fail bridge creation if NAT setup fails
 		}
+
+		if subnetV6 != "" {
+			if err := setupNAT6(networkName, subnetV6); err != nil {
+				logger.Printf("Warning: failed to setup NAT66 for network %s: %v", networkName, err)
+			}
+		}
+
+		// Isolate the network from the other networks, except for its connect list
+		if err := setupForwardRules(networkName, config, subnetV6 != ""); err != nil {
+			return fmt.Errorf("failed to set up firewall for network %s: %w", networkName, err)
+		}
 	}
 
 	logger.Printf("Bridge created successfully: %s", bridgeName)
@@ -541,13 +1475,21 @@ func deleteBridge(networkName string) error {
 		logger.Printf("Warning: failed to stop dnsmasq for network %s: %v", networkName, err)
 	}
 
-	// Cleanup NAT rules
+	// Cleanup NAT and firewall rules
+	cleanupForwardRules(networkName)
+	cleanupPartitionRules(bridgeName)
+
 	metadata, err := loadNetworkMetadata()
 	if err == nil {
 		if netMeta, exists := metadata[networkName]; exists {
 			if err := cleanupNAT(networkName, netMeta.Subnet); err != nil {
 				logger.Printf("Warning: failed to cleanup NAT for network %s: %v", networkName, err)
 			}
+			if netMeta.SubnetV6 != "" {
+				if err := cleanupNAT6(networkName, netMeta.SubnetV6); err != nil {
+					logger.Printf("Warning: failed to cleanup NAT66 for network %s: %v", networkName, err)
+				}
+			}
 		}
 	}
 
@@ -568,46 +1510,341 @@ func deleteBridge(networkName string) error {
 		return fmt.Errorf("failed to delete bridge %s: %w", bridgeName, err)
 	}
 
-	logger.Printf("Bridge deleted successfully: %s", bridgeName)
-	return nil
+	logger.Printf("Bridge deleted successfully: %s", bridgeName)
+	return nil
+}
+
+// setupNetwork brings up the infrastructure of a network according to its driver
+func setupNetwork(networkName string, config *ComposeConfig) error {
+	network, exists := config.Networks[networkName]
+	if !exists {
+		return fmt.Errorf("network not found in config: %s", networkName)
+	}
+
+	driver := getNetworkDriver(network)
+	if isExternalNetwork(network) && driver != "bridge" {
+		return fmt.Errorf("network %s: external is only supported with the bridge driver", networkName)
+	}
+
+	switch driver {
+	case "bridge":
+		if isExternalNetwork(network) {
+			return checkExternalBridge(networkName, network)
+		}
+		return createBridge(networkName, config, true)
+	case "macvtap":
+		return checkMacvtapParent(networkName, network)
+	case "user", "socket":
+		return startNetworkSwitch(networkName, network)
+	default:
+		return fmt.Errorf("unsupported network driver for network %s: %s", networkName, driver)
+	}
+}
+
+// deleteNetwork tears down the infrastructure of a network according to its driver
+// External and macvtap networks have no project-owned infrastructure and are left untouched
+func deleteNetwork(networkName string, network Network) error {
+	if isExternalNetwork(network) {
+		logger.Printf("Network %s is external, not deleting bridge %s", networkName, resolveBridgeName(networkName, network))
+		return nil
+	}
+	if isMacvtapNetwork(network) {
+		logger.Printf("Network %s uses macvtap on %s, nothing to delete", networkName, network.Parent)
+		return nil
+	}
+	if isRootlessNetwork(network) {
+		return stopNetworkSwitch(networkName)
+	}
+	return deleteBridge(networkName)
+}
+
+// checkExternalBridge verifies that the host bridge of an external network exists
+// IP addressing, DHCP and NAT are left to whoever manages the bridge
+func checkExternalBridge(networkName string, network Network) error {
+	bridgeName := resolveBridgeName(networkName, network)
+	logger.Printf("Using external bridge %s for network %s", bridgeName, networkName)
+
+	link, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		return fmt.Errorf("external bridge %s for network %s not found: %w", bridgeName, networkName, err)
+	}
+
+	if link.Type() != "bridge" {
+		return fmt.Errorf("external network %s: %s is not a bridge (type: %s)", networkName, bridgeName, link.Type())
+	}
+
+	return nil
+}
+
+// checkMacvtapParent verifies that the parent interface of a macvtap network exists
+func checkMacvtapParent(networkName string, network Network) error {
+	if network.Parent == "" {
+		return fmt.Errorf("macvtap network %s requires a parent interface", networkName)
+	}
+
+	if _, err := getMacvtapMode(network); err != nil {
+		return fmt.Errorf("macvtap network %s: %w", networkName, err)
+	}
+
+	if _, err := netlink.LinkByName(network.Parent); err != nil {
+		return fmt.Errorf("parent interface %s for network %s not found: %w", network.Parent, networkName, err)
+	}
+
+	logger.Printf("Using parent interface %s for macvtap network %s", network.Parent, networkName)
+	return nil
+}
+
+// getMacvtapMode returns the netlink macvlan mode of a macvtap network
+func getMacvtapMode(network Network) (netlink.MacvlanMode, error) {
+	switch network.Mode {
+	case "", "bridge":
+		return netlink.MACVLAN_MODE_BRIDGE, nil
+	case "vepa":
+		return netlink.MACVLAN_MODE_VEPA, nil
+	case "private":
+		return netlink.MACVLAN_MODE_PRIVATE, nil
+	case "passthru":
+		return netlink.MACVLAN_MODE_PASSTHRU, nil
+	default:
+		return netlink.MACVLAN_MODE_DEFAULT, fmt.Errorf("unsupported macvtap mode: %s", network.Mode)
+	}
+}
+
+// createTAPDevice creates a TAP device for a VM network interface
+// The device is multiqueue when the interface uses several queues, and carries the interface MTU
+func createTAPDevice(vmName string, vmNetwork VMNetwork, networkIndex int) (string, error) {
+	networkName := vmNetwork.Name
+	tapName := getTAPName(vmName, networkIndex)
+	tapOwner := getInterfaceOwner("vm", vmName, strconv.Itoa(networkIndex))
+	logger.Printf("Creating TAP device: %s for VM: %s on network: %s", tapName, vmName, networkName)
+
+	// Check if TAP already exists, and that it belongs to this VM
+	if link, err := netlink.LinkByName(tapName); err == nil {
+		if err := checkInterfaceOwner(link, tapOwner); err != nil {
+			return "", err
+		}
+		queuesErr := checkTAPQueues(link, getNICQueues(vmNetwork))
+		if queuesErr == nil {
+			logger.Printf("TAP device already exists: %s", tapName)
+			if err := setInterfaceMTU(link, vmNetwork.MTU); err != nil {
+				return "", err
+			}
+			return tapName, nil
+		}
+
+		// The queues changed since the TAP device was created, it is recreated unless QEMU still holds it
+		if tap, ok := link.(*netlink.Tuntap); ok && tap.Queues > 0 {
+			return "", fmt.Errorf("TAP device %s is in use: %w", tapName, queuesErr)
+		}
+		logger.Printf("Recreating TAP device %s: %v", tapName, queuesErr)
+		if err := deleteTAPDevice(tapName); err != nil {
+			return "", err
+		}
+	}
+
+	// Get current user ID
+	uid := os.Getuid()
+	gid := os.Getgid()
+
+	// Create TAP device
+	tap := &netlink.Tuntap{
+		LinkAttrs: netlink.LinkAttrs{
+			Name: tapName,
+		},
+		Mode:  netlink.TUNTAP_MODE_TAP,
+		Owner: uint32(uid),
+		Group: uint32(gid),
+	}
+
+	// QEMU can only open several queues on a TAP device created multiqueue
+	if getNICQueues(vmNetwork) > 1 {
+		tap.Flags = netlink.TUNTAP_MULTI_QUEUE_DEFAULTS
+	}
+
+	if err := netlink.LinkAdd(tap); err != nil {
+		return "", fmt.Errorf("failed to create TAP device %s: %w", tapName, err)
+	}
+
+	// Tag the TAP device with its owner to detect name collisions
+	if err := netlink.LinkSetAlias(tap, tapOwner); err != nil {
+		return "", fmt.Errorf("failed to set alias on TAP device %s: %w", tapName, err)
+	}
+
+	if err := setInterfaceMTU(tap, vmNetwork.MTU); err != nil {
+		return "", err
+	}
+
+	// Set TAP device up
+	if err := netlink.LinkSetUp(tap); err != nil {
+		return "", fmt.Errorf("failed to bring up TAP device %s: %w", tapName, err)
+	}
+
+	logger.Printf("TAP device created successfully: %s (owner: %d:%d)", tapName, uid, gid)
+	return tapName, nil
+}
+
+// checkTAPQueues checks that an existing TAP device can be opened with the queues of a VM NIC:
+// multiqueue if and only if there are several queues, and no other queue count already attached
+func checkTAPQueues(link netlink.Link, queues int) error {
+	tap, ok := link.(*netlink.Tuntap)
+	if !ok {
+		return fmt.Errorf("%s is not a TAP device", link.Attrs().Name)
+	}
+
+	multiQueue := tap.Flags&netlink.TUNTAP_MULTI_QUEUE != 0
+	if multiQueue != (queues > 1) {
+		return fmt.Errorf("multiqueue is %v, %d queues requested", multiQueue, queues)
+	}
+	if tap.Queues > 0 && tap.Queues != queues {
+		return fmt.Errorf("%d queues attached, %d queues requested", tap.Queues, queues)
+	}
+	return nil
+}
+
+// setInterfaceMTU sets the MTU of a VM interface, 0 keeps the current MTU
+func setInterfaceMTU(link netlink.Link, mtu int) error {
+	if mtu == 0 || link.Attrs().MTU == mtu {
+		return nil
+	}
+
+	if err := netlink.LinkSetMTU(link, mtu); err != nil {
+		return fmt.Errorf("failed to set MTU %d on %s: %w", mtu, link.Attrs().Name, err)
+	}
+
+	logger.Printf("Set MTU %d on %s", mtu, link.Attrs().Name)
+	return nil
+}
+
+// createMacvtapDevice creates a macvtap device on the parent interface of a network for a VM
+// The device carries the VM MAC address and MTU, its character device is handed over to the current user
+func createMacvtapDevice(vmName string, vmNetwork VMNetwork, network Network, networkIndex int, macAddress string) (string, error) {
+	tapName := getTAPName(vmName, networkIndex)
+	tapOwner := getInterfaceOwner("vm", vmName, strconv.Itoa(networkIndex))
+	logger.Printf("Creating macvtap device: %s for VM: %s on parent: %s", tapName, vmName, network.Parent)
+
+	parent, err := netlink.LinkByName(network.Parent)
+	if err != nil {
+		return "", fmt.Errorf("failed to find parent interface %s: %w", network.Parent, err)
+	}
+
+	mode, err := getMacvtapMode(network)
+	if err != nil {
+		return "", err
+	}
+
+	// The guest NIC and the macvtap device must share the same MAC address
+	macAddr, err := net.ParseMAC(macAddress)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse MAC address: %w", err)
+	}
+
+	// Check if macvtap already exists, and that it belongs to this VM
+	if link, err := netlink.LinkByName(tapName); err == nil {
+		if err := checkInterfaceOwner(link, tapOwner); err != nil {
+			return "", err
+		}
+		settingsErr := checkMacvtapDevice(link, parent, mode, macAddr)
+		if settingsErr == nil {
+			logger.Printf("Macvtap device already exists: %s", tapName)
+			if err := setInterfaceMTU(link, vmNetwork.MTU); err != nil {
+				return "", err
+			}
+			if err := netlink.LinkSetUp(link); err != nil {
+				return "", fmt.Errorf("failed to bring up macvtap device %s: %w", tapName, err)
+			}
+			if err := chownMacvtapDevice(tapName); err != nil {
+				return "", err
+			}
+			return tapName, nil
+		}
+
+		// The network or the MAC address changed since the macvtap device was created
+		logger.Printf("Recreating macvtap device %s: %v", tapName, settingsErr)
+		if err := deleteTAPDevice(tapName); err != nil {
+			return "", err
+		}
+	}
+
+	macvtap := &netlink.Macvtap{
+		Macvlan: netlink.Macvlan{
+			LinkAttrs: netlink.LinkAttrs{
+				Name:         tapName,
+				ParentIndex:  parent.Attrs().Index,
+				HardwareAddr: macAddr,
+			},
+			Mode: mode,
+		},
+	}
+
+	if err := netlink.LinkAdd(macvtap); err != nil {
+		return "", fmt.Errorf("failed to create macvtap device %s: %w", tapName, err)
+	}
+
+	// Tag the macvtap device with its owner to detect name collisions
+	if err := netlink.LinkSetAlias(macvtap, tapOwner); err != nil {
+		return "", fmt.Errorf("failed to set alias on macvtap device %s: %w", tapName, err)
+	}
+
+	if err := setInterfaceMTU(macvtap, vmNetwork.MTU); err != nil {
+		return "", err
+	}
+
+	if err := netlink.LinkSetUp(macvtap); err != nil {
+		return "", fmt.Errorf("failed to bring up macvtap device %s: %w", tapName, err)
+	}
+
+	if err := chownMacvtapDevice(tapName); err != nil {
+		return "", err
+	}
+
+	logger.Printf("Macvtap device created successfully: %s", tapName)
+	return tapName, nil
 }
 
-// createTAPDevice creates a TAP device for a VM
-func createTAPDevice(vmName, networkName string, networkIndex int) (string, error) {
-	tapName := getTAPName(vmName, networkIndex)
-	logger.Printf("Creating TAP device: %s for VM: %s on network: %s", tapName, vmName, networkName)
-
-	// Check if TAP already exists
-	if _, err := netlink.LinkByName(tapName); err == nil {
-		logger.Printf("TAP device already exists: %s", tapName)
-		return tapName, nil
+// checkMacvtapDevice checks that an existing macvtap device matches the parent, mode and MAC address of a VM NIC
+func checkMacvtapDevice(link netlink.Link, parent netlink.Link, mode netlink.MacvlanMode, macAddr net.HardwareAddr) error {
+	macvtap, ok := link.(*netlink.Macvtap)
+	if !ok {
+		return fmt.Errorf("%s is not a macvtap device", link.Attrs().Name)
 	}
 
-	// Get current user ID
-	uid := os.Getuid()
-	gid := os.Getgid()
-
-	// Create TAP device
-	tap := &netlink.Tuntap{
-		LinkAttrs: netlink.LinkAttrs{
-			Name: tapName,
-		},
-		Mode:  netlink.TUNTAP_MODE_TAP,
-		Owner: uint32(uid),
-		Group: uint32(gid),
+	if macvtap.ParentIndex != parent.Attrs().Index {
+		return fmt.Errorf("parent is not %s", parent.Attrs().Name)
+	}
+	if macvtap.Mode != mode {
+		return fmt.Errorf("mode is %d, %d requested", macvtap.Mode, mode)
 	}
+	if macvtap.HardwareAddr.String() != macAddr.String() {
+		return fmt.Errorf("MAC address is %s, %s requested", macvtap.HardwareAddr, macAddr)
+	}
+	return nil
+}
 
-	if err := netlink.LinkAdd(tap); err != nil {
-		return "", fmt.Errorf("failed to create TAP device %s: %w", tapName, err)
+// chownMacvtapDevice hands the character device of a macvtap interface over to the current user
+// The character device is created by the kernel and owned by root
+func chownMacvtapDevice(tapName string) error {
+	devicePath, err := getMacvtapDevicePath(tapName)
+	if err != nil {
+		return err
 	}
 
-	// Set TAP device up
-	if err := netlink.LinkSetUp(tap); err != nil {
-		return "", fmt.Errorf("failed to bring up TAP device %s: %w", tapName, err)
+	owner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	cmd := exec.Command("sudo", "chown", owner, devicePath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to chown %s (requires sudo): %w\nOutput: %s", devicePath, err, string(output))
 	}
 
-	logger.Printf("TAP device created successfully: %s (owner: %d:%d)", tapName, uid, gid)
-	return tapName, nil
+	logger.Printf("Handed %s over to %s", devicePath, owner)
+	return nil
+}
+
+// getMacvtapDevicePath returns the character device QEMU opens for a macvtap interface
+func getMacvtapDevicePath(tapName string) (string, error) {
+	link, err := netlink.LinkByName(tapName)
+	if err != nil {
+		return "", fmt.Errorf("failed to find macvtap device %s: %w", tapName, err)
+	}
+	return fmt.Sprintf("/dev/tap%d", link.Attrs().Index), nil
 }
 
 // deleteTAPDevice removes a TAP device
@@ -636,8 +1873,7 @@ func deleteTAPDevice(tapName string) error {
 }
 
 // attachTAPToBridge attaches a TAP device to a bridge
-func attachTAPToBridge(tapName, networkName string) error {
-	bridgeName := getBridgeName(networkName)
+func attachTAPToBridge(tapName, bridgeName string) error {
 	logger.Printf("Attaching TAP device %s to bridge %s", tapName, bridgeName)
 
 	// Get TAP device
@@ -661,69 +1897,146 @@ func attachTAPToBridge(tapName, networkName string) error {
 	return nil
 }
 
-// getBridgeName returns the bridge interface name for a network
-func getBridgeName(networkName string) string {
-	projectName := getProjectName()
-	// Sanitize names for network interfaces (max 15 chars, alphanumeric + dash)
-	sanitizedProject := strings.ReplaceAll(projectName, " ", "-")
-	sanitizedNetwork := strings.ReplaceAll(networkName, " ", "-")
+// getProjectID returns the identity of the current project: its absolute directory
+// Unlike getProjectName, two projects with the same directory name get different IDs
+func getProjectID() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return getProjectName()
+	}
+	return cwd
+}
+
+// getProjectUnitID returns the project part of systemd unit names: the directory name and a short
+// hash of the project directory, so that projects in directories with the same name get different units
+func getProjectUnitID() string {
+	sanitizedProject := strings.ReplaceAll(getProjectName(), " ", "-")
+	hash := sha256.Sum256([]byte(getProjectID()))
+	return fmt.Sprintf("%s-%x", sanitizedProject, hash[:4])
+}
+
+// hashInterfaceName builds an interface name from a prefix and a hash of the full identity
+// Names are always 15 characters (IFNAMSIZ - 1): 3 for the prefix and 12 hex characters
+func hashInterfaceName(prefix string, identity ...string) string {
+	parts := append([]string{getProjectID()}, identity...)
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return fmt.Sprintf("%s%x", prefix, hash[:6])
+}
+
+// getInterfaceOwner returns the link alias recorded on interfaces created by qemu-compose
+// For example: "qemu-compose:/home/user/project:network:frontend"
+func getInterfaceOwner(identity ...string) string {
+	parts := append([]string{"qemu-compose", getProjectID()}, identity...)
+	return strings.Join(parts, ":")
+}
+
+// checkInterfaceOwner returns an error if an existing interface was not created for the given owner
+func checkInterfaceOwner(link netlink.Link, owner string) error {
+	alias := link.Attrs().Alias
+	if alias == owner {
+		return nil
+	}
 
-	// Keep it short to fit in 15 char limit
-	bridgeName := fmt.Sprintf("qc-%s-%s", sanitizedProject, sanitizedNetwork)
-	if len(bridgeName) > 15 {
-		// Truncate if too long
-		bridgeName = bridgeName[:15]
+	if alias == "" {
+		return fmt.Errorf("interface %s already exists and was not created by qemu-compose", link.Attrs().Name)
 	}
 
-	return bridgeName
+	return fmt.Errorf("interface %s already exists and is owned by %s", link.Attrs().Name, alias)
 }
 
-// getTAPName returns the TAP device name for a VM using hash-based naming with VM name suffix
+// getBridgeName returns the bridge interface name for a network
+// Format: qc-<12 hex chars of sha256(project directory, network)>
+func getBridgeName(networkName string) string {
+	return hashInterfaceName("qc-", "network", networkName)
+}
+
+// getTAPName returns the TAP device name for a VM network interface
+// Format: qt-<12 hex chars of sha256(project directory, VM, network index)>
 func getTAPName(vmName string, networkIndex int) string {
-	projectName := getProjectName()
+	return hashInterfaceName("qt-", "vm", vmName, strconv.Itoa(networkIndex))
+}
+
+// recordBridgeName records the bridge of a network and its owning project in networks.json
+func recordBridgeName(networkName, bridgeName string) error {
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return err
+	}
+
+	netMeta := metadata[networkName]
+	netMeta.Project = getProjectID()
+	netMeta.Bridge = bridgeName
+	metadata[networkName] = netMeta
+
+	return saveNetworkMetadata(metadata)
+}
 
-	// Create a unique identifier combining project, VM name, and network index
-	identifier := fmt.Sprintf("%s-%s-%d", projectName, vmName, networkIndex)
+// getTAPDeviceKey returns the key of a VM network interface in the TAP devices of networks.json
+// A VM may have several interfaces on the same network
+func getTAPDeviceKey(vmName string, networkIndex int) string {
+	return fmt.Sprintf("%s/%d", vmName, networkIndex)
+}
 
-	// Generate MD5 hash and take first 4 characters for uniqueness
-	hash := fmt.Sprintf("%x", md5.Sum([]byte(identifier)))[:4]
+// recordTAPName records the TAP device of a VM network interface in networks.json
+// An empty tapName removes the entry
+func recordTAPName(networkName, vmName string, networkIndex int, tapName string) error {
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return err
+	}
 
-	// Sanitize VM name
-	sanitizedVM := strings.ReplaceAll(vmName, " ", "-")
+	netMeta, exists := metadata[networkName]
+	if !exists && tapName == "" {
+		return nil
+	}
 
-	// Format: tap-<hash>-<vmname> (try to fit in 15 char limit)
-	// Reserve 9 chars for "tap-" + hash + "-", leaving 6 for VM name
-	maxVMLen := 6
-	if len(sanitizedVM) > maxVMLen {
-		sanitizedVM = sanitizedVM[:maxVMLen]
+	if tapName == "" {
+		delete(netMeta.TAPDevices, getTAPDeviceKey(vmName, networkIndex))
+		// Entries recorded before interfaces were keyed by index
+		delete(netMeta.TAPDevices, vmName)
+	} else {
+		if netMeta.TAPDevices == nil {
+			netMeta.TAPDevices = make(map[string]string)
+		}
+		netMeta.Project = getProjectID()
+		netMeta.TAPDevices[getTAPDeviceKey(vmName, networkIndex)] = tapName
 	}
+	metadata[networkName] = netMeta
 
-	return fmt.Sprintf("tap-%s-%s", hash, sanitizedVM)
+	return saveNetworkMetadata(metadata)
 }
 
 // getBridgeIP returns the bridge IP address from a subnet
 // For example: "192.168.100.0/24" -> "192.168.100.1/24"
 func getBridgeIP(subnet string) string {
-	parts := strings.Split(subnet, "/")
-	if len(parts) != 2 {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil || ipNet.IP.To4() == nil {
 		return subnet
 	}
 
-	ip := net.ParseIP(parts[0])
-	if ip == nil {
-		return subnet
-	}
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", getSubnetGateway(ipNet), ones)
+}
 
-	// Convert to 4-byte representation
-	ip = ip.To4()
-	if ip == nil {
-		return subnet
+// getSubnetGateway returns the first host address of an IPv4 subnet, where the bridge sits
+func getSubnetGateway(subnet *net.IPNet) net.IP {
+	return uint32ToIP(binary.BigEndian.Uint32(subnet.IP.To4()) + 1)
+}
+
+// getBridgeIPv6 returns the bridge IPv6 address from an IPv6 subnet
+// For example: "fd12:3456:789a::/64" -> "fd12:3456:789a::1/64"
+func getBridgeIPv6(subnetV6 string) string {
+	ip, ipNet, err := net.ParseCIDR(subnetV6)
+	if err != nil || ip.To4() != nil {
+		return subnetV6
 	}
 
-	// Set last octet to 1 for bridge IP
-	ip[3] = 1
+	bridgeIP := make(net.IP, net.IPv6len)
+	copy(bridgeIP, ipNet.IP)
+	bridgeIP[15] = 1
 
-	return fmt.Sprintf("%s/%s", ip.String(), parts[1])
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", bridgeIP.String(), ones)
 }
 
 // setupVMNetworks creates all network infrastructure for a VM
@@ -735,22 +2048,72 @@ func setupVMNetworks(vmName string, vm VM, config *ComposeConfig) error {
 
 	logger.Printf("Setting up %d network(s) for VM: %s", len(vm.Networks), vmName)
 
-	for i, networkName := range vm.Networks {
-		// Create bridge if it doesn't exist
-		if err := createBridge(networkName, config); err != nil {
-			return fmt.Errorf("failed to create bridge for network %s: %w", networkName, err)
+	for i, vmNetwork := range vm.Networks {
+		networkName := vmNetwork.Name
+
+		// Create bridge or switch if it doesn't exist
+		if err := setupNetwork(networkName, config); err != nil {
+			return fmt.Errorf("failed to set up network %s: %w", networkName, err)
+		}
+
+		network := config.Networks[networkName]
+
+		// Rootless networks connect QEMU directly to the switch socket
+		if isRootlessNetwork(network) {
+			if err := registerVMDNSNames(vmName, vm, i, config); err != nil {
+				return fmt.Errorf("failed to register DNS names on network %s: %w", networkName, err)
+			}
+			continue
+		}
+
+		// Macvtap networks put the VM directly on the parent interface segment
+		if isMacvtapNetwork(network) {
+			tapName, err := createMacvtapDevice(vmName, vmNetwork, network, i, getVMMACAddress(vmName, vm, i))
+			if err != nil {
+				return fmt.Errorf("failed to create macvtap device for network %s: %w", networkName, err)
+			}
+			if err := recordTAPName(networkName, vmName, i, tapName); err != nil {
+				logger.Printf("Warning: failed to record macvtap device %s: %v", tapName, err)
+			}
+			continue
 		}
 
 		// Create TAP device
-		tapName, err := createTAPDevice(vmName, networkName, i)
+		tapName, err := createTAPDevice(vmName, vmNetwork, i)
 		if err != nil {
 			return fmt.Errorf("failed to create TAP device for network %s: %w", networkName, err)
 		}
 
 		// Attach TAP to bridge
-		if err := attachTAPToBridge(tapName, networkName); err != nil {
+		if err := attachTAPToBridge(tapName, resolveBridgeName(networkName, network)); err != nil {
 			return fmt.Errorf("failed to attach TAP to bridge for network %s: %w", networkName, err)
 		}
+
+		if err := recordTAPName(networkName, vmName, i, tapName); err != nil {
+			logger.Printf("Warning: failed to record TAP device %s: %v", tapName, err)
+		}
+
+		// Degraded link conditions survive VM restarts
+		if settings := getEffectiveLinkSettings(networkName, vmName, config); !settings.isEmpty() {
+			if err := applyLinkSettings(tapName, settings); err != nil {
+				return fmt.Errorf("failed to apply link settings on network %s: %w", networkName, err)
+			}
+			if settings.Partition != "" {
+				if err := setupPartitionRules(networkName, config); err != nil {
+					return fmt.Errorf("failed to apply partition on network %s: %w", networkName, err)
+				}
+			}
+		}
+
+		// Managed bridges give each VM a fixed address, used by the port proxy and DNS
+		if !isExternalNetwork(network) {
+			if _, err := reserveVMAddress(networkName, vmName, getVMMACAddress(vmName, vm, i)); err != nil {
+				return fmt.Errorf("failed to reserve address on network %s: %w", networkName, err)
+			}
+			if err := registerVMDNSNames(vmName, vm, i, config); err != nil {
+				return fmt.Errorf("failed to register DNS names on network %s: %w", networkName, err)
+			}
+		}
 	}
 
 	logger.Printf("Network setup completed for VM: %s", vmName)
@@ -766,10 +2129,22 @@ func cleanupVMNetworks(vmName string, vm VM) error {
 
 	logger.Printf("Cleaning up %d network(s) for VM: %s", len(vm.Networks), vmName)
 
-	for i := range vm.Networks {
+	// Published ports of bridge VMs are forwarded by a proxy unit
+	// An installed proxy unit is bound to the VM unit and stops with it
+	if len(vm.Ports) > 0 && !isUserUnitInstalled(getPortProxyUnitName(vmName)) {
+		if err := stopPortProxy(vmName); err != nil {
+			logger.Printf("Warning: failed to stop port proxy: %v", err)
+		}
+	}
+
+	for i, networkName := range getVMNetworkNames(vm) {
 		tapName := getTAPName(vmName, i)
 		if err := deleteTAPDevice(tapName); err != nil {
 			logger.Printf("Warning: failed to delete TAP device %s: %v", tapName, err)
+			continue
+		}
+		if err := recordTAPName(networkName, vmName, i, ""); err != nil {
+			logger.Printf("Warning: failed to update network metadata for %s: %v", tapName, err)
 		}
 	}
 
@@ -786,12 +2161,370 @@ func cleanupProjectNetworks(config *ComposeConfig) error {
 
 	logger.Printf("Cleaning up %d project network(s)", len(config.Networks))
 
-	for networkName := range config.Networks {
-		if err := deleteBridge(networkName); err != nil {
-			logger.Printf("Warning: failed to delete bridge for network %s: %v", networkName, err)
+	for networkName, network := range config.Networks {
+		if err := deleteNetwork(networkName, network); err != nil {
+			logger.Printf("Warning: failed to delete network %s: %v", networkName, err)
 		}
 	}
 
 	logger.Printf("Project network cleanup completed")
 	return nil
 }
+
+// listNATRules returns the iptables and ip6tables rules tagged for a bridge
+// Each rule is prefixed with its command and table, for example: "iptables nat -A POSTROUTING ..."
+func listNATRules(bridgeName string) ([]string, error) {
+	ruleComment := getNATRuleComment(bridgeName)
+	var rules []string
+
+	for _, command := range []string{"iptables", "ip6tables"} {
+		for _, table := range []string{"filter", "nat"} {
+			cmd := exec.Command("sudo", command, "-t", table, "-S")
+			output, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s %s rules: %w", command, table, err)
+			}
+
+			for _, line := range strings.Split(string(output), "\n") {
+				if strings.HasPrefix(line, "-A ") && ruleHasComment(line, ruleComment) {
+					rules = append(rules, fmt.Sprintf("%s %s %s", command, table, line))
+				}
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// ruleHasComment returns true if an iptables -S line carries exactly the given comment
+func ruleHasComment(line, comment string) bool {
+	return strings.Contains(line, "--comment "+comment+" ") ||
+		strings.Contains(line, "--comment \""+comment+"\" ") ||
+		strings.HasSuffix(line, "--comment "+comment)
+}
+
+// OrphanedNetworkResources lists host network resources created by qemu-compose
+// that no project metadata references any more
+type OrphanedNetworkResources struct {
+	Bridges          []string
+	TAPDevices       []string
+	DnsmasqUnits     []string // Under the system manager (systemd-run)
+	UserDnsmasqUnits []string // Under the user manager (installed by "systemd generate")
+	NATRules         []string // Same format as listNATRules
+}
+
+// isEmpty returns true if no orphaned resource was found
+func (o *OrphanedNetworkResources) isEmpty() bool {
+	return len(o.Bridges) == 0 && len(o.TAPDevices) == 0 && len(o.DnsmasqUnits) == 0 && len(o.UserDnsmasqUnits) == 0 && len(o.NATRules) == 0
+}
+
+// parseInterfaceOwner splits an interface alias set by getInterfaceOwner
+// Returns the project directory, the kind ("network" or "vm") and false if the alias is not ours
+func parseInterfaceOwner(alias string) (string, string, bool) {
+	if !strings.HasPrefix(alias, "qemu-compose:") {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(alias, "qemu-compose:")
+
+	for _, kind := range []string{"network", "vm"} {
+		if idx := strings.LastIndex(rest, ":"+kind+":"); idx != -1 {
+			return rest[:idx], kind, true
+		}
+	}
+
+	return "", "", false
+}
+
+// isInterfaceReferenced returns true if the networks.json of a project references an interface
+func isInterfaceReferenced(projectDir, kind, linkName string) bool {
+	metadata, err := loadNetworkMetadataFile(filepath.Join(projectDir, ".qemu-compose", "networks.json"))
+	if err != nil {
+		// Keep interfaces of projects we cannot read
+		logger.Printf("Warning: could not read network metadata of %s: %v", projectDir, err)
+		return true
+	}
+
+	for _, netMeta := range metadata {
+		if kind == "network" && netMeta.Bridge == linkName {
+			return true
+		}
+		if kind == "vm" {
+			for _, tapName := range netMeta.TAPDevices {
+				if tapName == linkName {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// legacyTAPPattern matches TAP devices named before interfaces had owner aliases: tap-<4 hex>-<vm>
+var legacyTAPPattern = regexp.MustCompile(`^tap-[0-9a-f]{4}-`)
+
+// isLegacyInterface returns true if an interface without owner alias has a name used by versions of
+// qemu-compose before interface names were hashed: qc-<project>-<network> bridges and tap-* devices
+func isLegacyInterface(link netlink.Link) bool {
+	name := link.Attrs().Name
+	switch link.Type() {
+	case "bridge":
+		return strings.HasPrefix(name, "qc-")
+	case "tuntap":
+		return legacyTAPPattern.MatchString(name)
+	}
+	return false
+}
+
+// isLegacyBridgeInUse returns true if a TAP device attached to a legacy bridge is still up (a VM uses it)
+func isLegacyBridgeInUse(bridge netlink.Link, links []netlink.Link) bool {
+	for _, link := range links {
+		if link.Attrs().MasterIndex == bridge.Attrs().Index && link.Attrs().OperState == netlink.OperUp {
+			return true
+		}
+	}
+	return false
+}
+
+// findOrphanedNetworkResources scans the host for bridges, TAP devices, dnsmasq units and iptables rules
+// created by qemu-compose (identified by interface alias, unit name and rule comment) that are no longer
+// referenced by the networks.json of their project
+// Interfaces and untagged rules of versions before owner aliases are found by name instead
+func findOrphanedNetworkResources() (*OrphanedNetworkResources, error) {
+	orphaned := &OrphanedNetworkResources{}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+
+	// Bridges still in use by a project
+	liveBridges := make(map[string]bool)
+
+	// Interfaces without owner alias named like the ones of older versions
+	var legacyLinks []netlink.Link
+
+	for _, link := range links {
+		name := link.Attrs().Name
+		projectDir, kind, ok := parseInterfaceOwner(link.Attrs().Alias)
+		if !ok {
+			if isLegacyInterface(link) {
+				legacyLinks = append(legacyLinks, link)
+			}
+			continue
+		}
+
+		if isInterfaceReferenced(projectDir, kind, name) {
+			if kind == "network" {
+				liveBridges[name] = true
+			}
+			continue
+		}
+
+		logger.Printf("Found orphaned interface %s (owner: %s)", name, link.Attrs().Alias)
+		if kind == "network" {
+			orphaned.Bridges = append(orphaned.Bridges, name)
+		} else {
+			orphaned.TAPDevices = append(orphaned.TAPDevices, name)
+		}
+	}
+
+	// Legacy interfaces are orphaned unless a VM still uses them
+	// Subnets of orphaned legacy bridges identify their untagged MASQUERADE rules
+	legacySubnets := make(map[string]bool)
+	for _, link := range legacyLinks {
+		name := link.Attrs().Name
+		if link.Type() == "bridge" {
+			if isLegacyBridgeInUse(link, links) {
+				liveBridges[name] = true
+				continue
+			}
+			logger.Printf("Found orphaned legacy bridge %s", name)
+			orphaned.Bridges = append(orphaned.Bridges, name)
+
+			addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				subnet := &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+				legacySubnets[subnet.String()] = true
+			}
+		} else if link.Attrs().OperState != netlink.OperUp {
+			logger.Printf("Found orphaned legacy TAP device %s", name)
+			orphaned.TAPDevices = append(orphaned.TAPDevices, name)
+		}
+	}
+
+	// dnsmasq units whose bridge is gone or orphaned
+	systemUnits, err := findOrphanedDnsmasqUnits(false, liveBridges)
+	if err != nil {
+		return nil, err
+	}
+	orphaned.DnsmasqUnits = systemUnits
+
+	userUnits, err := findOrphanedDnsmasqUnits(true, liveBridges)
+	if err != nil {
+		return nil, err
+	}
+	orphaned.UserDnsmasqUnits = userUnits
+
+	// iptables rules tagged for a bridge that is gone or orphaned
+	for _, command := range []string{"iptables", "ip6tables"} {
+		for _, table := range []string{"filter", "nat"} {
+			cmd := exec.Command("sudo", command, "-t", table, "-S")
+			output, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %s %s rules: %w", command, table, err)
+			}
+
+			for _, line := range strings.Split(string(output), "\n") {
+				if !strings.HasPrefix(line, "-A ") {
+					continue
+				}
+
+				fields := strings.Fields(line)
+				if isOrphanedLegacyRule(fields, liveBridges, legacySubnets) {
+					orphaned.NATRules = append(orphaned.NATRules, fmt.Sprintf("%s %s %s", command, table, line))
+					continue
+				}
+
+				for i, field := range fields {
+					if field != "--comment" || i+1 >= len(fields) {
+						continue
+					}
+
+					comment := strings.Trim(fields[i+1], "\"")
+					if !strings.HasPrefix(comment, "qemu-compose:") {
+						continue
+					}
+
+					bridgeName := strings.TrimPrefix(comment, "qemu-compose:")
+					if !liveBridges[bridgeName] {
+						orphaned.NATRules = append(orphaned.NATRules, fmt.Sprintf("%s %s %s", command, table, line))
+					}
+				}
+			}
+		}
+	}
+
+	return orphaned, nil
+}
+
+// findOrphanedDnsmasqUnits returns the dnsmasq units of the system or the user manager whose bridge
+// is gone or orphaned. Installed user units are only orphaned while active: a stopped one belongs
+// to a project that is not running, and is removed by "systemd uninstall"
+func findOrphanedDnsmasqUnits(user bool, liveBridges map[string]bool) ([]string, error) {
+	systemctl := []string{"systemctl"}
+	if user {
+		systemctl = append(systemctl, "--user")
+	}
+
+	listArgs := append(append([]string{}, systemctl...), "list-units", "--all", "--plain", "--no-legend", "qemu-compose-dnsmasq-*")
+	output, err := exec.Command(listArgs[0], listArgs[1:]...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dnsmasq units: %w", err)
+	}
+
+	var units []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		unitName := fields[0]
+
+		// list-units prints UNIT LOAD ACTIVE SUB DESCRIPTION
+		if user && (len(fields) < 3 || fields[2] != "active") {
+			continue
+		}
+
+		showArgs := append(append([]string{}, systemctl...), "show", unitName, "--property=ExecStart", "--value")
+		execStart, err := exec.Command(showArgs[0], showArgs[1:]...).Output()
+		if err != nil {
+			continue
+		}
+
+		bridgeName := ""
+		for _, arg := range strings.Fields(string(execStart)) {
+			if strings.HasPrefix(arg, "--interface=") {
+				bridgeName = strings.TrimPrefix(arg, "--interface=")
+			}
+		}
+
+		if bridgeName == "" || !liveBridges[bridgeName] {
+			logger.Printf("Found orphaned dnsmasq unit %s (bridge: %s, user: %v)", unitName, bridgeName, user)
+			units = append(units, unitName)
+		}
+	}
+
+	return units, nil
+}
+
+// isOrphanedLegacyRule returns true if an untagged iptables rule was added by an older version for a
+// bridge that is gone or orphaned: "-A FORWARD -i|-o <qc-*|tap-*> -j ACCEPT", or
+// "-A POSTROUTING -s <subnet> -j MASQUERADE" for the subnet of an orphaned legacy bridge
+func isOrphanedLegacyRule(fields []string, liveBridges map[string]bool, legacySubnets map[string]bool) bool {
+	if len(fields) != 6 || fields[0] != "-A" {
+		return false
+	}
+
+	switch {
+	case fields[1] == "FORWARD" && (fields[2] == "-i" || fields[2] == "-o") && fields[4] == "-j" && fields[5] == "ACCEPT":
+		name := fields[3]
+		isLegacyName := (strings.HasPrefix(name, "qc-") && name != managedBridgePattern) || legacyTAPPattern.MatchString(name)
+		return isLegacyName && !liveBridges[name]
+	case fields[1] == "POSTROUTING" && fields[2] == "-s" && fields[4] == "-j" && fields[5] == "MASQUERADE":
+		return legacySubnets[fields[3]]
+	}
+	return false
+}
+
+// pruneNetworkResources removes orphaned network resources
+// Returns one error per resource that could not be removed
+func pruneNetworkResources(orphaned *OrphanedNetworkResources) []error {
+	var errs []error
+
+	for _, unitName := range orphaned.DnsmasqUnits {
+		cmd := exec.Command("sudo", "systemctl", "stop", unitName)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w\nOutput: %s", unitName, err, string(output)))
+		}
+	}
+
+	for _, unitName := range orphaned.UserDnsmasqUnits {
+		cmd := exec.Command("systemctl", "--user", "stop", unitName)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop %s: %w\nOutput: %s", unitName, err, string(output)))
+		}
+	}
+
+	for _, rule := range orphaned.NATRules {
+		// "iptables nat -A CHAIN ..."
+		fields := strings.SplitN(rule, " ", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		if err := deleteIptablesRule(fields[0], fields[1], fields[2]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, tapName := range orphaned.TAPDevices {
+		if err := deleteTAPDevice(tapName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, bridgeName := range orphaned.Bridges {
+		link, err := netlink.LinkByName(bridgeName)
+		if err != nil {
+			continue
+		}
+		if err := netlink.LinkDel(link); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete bridge %s: %w", bridgeName, err))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// PortMapping represents a published port: [HOST_IP:]HOST_PORT:VM_PORT[/PROTOCOL]
+type PortMapping struct {
+	HostIP   string
+	HostPort int
+	VMPort   int
+	Protocol string // "tcp" or "udp"
+}
+
+// String returns the long form of a port mapping, accepted by parsePortMapping
+func (p PortMapping) String() string {
+	return fmt.Sprintf("%s:%d:%d/%s", p.HostIP, p.HostPort, p.VMPort, p.Protocol)
+}
+
+// parsePortMapping parses a port specification
+// Supported forms: "80", "8080:80", "127.0.0.1:8080:80", each with an optional "/tcp" or "/udp" suffix
+// Ports are published on 127.0.0.1 unless a host IP is given
+func parsePortMapping(spec string) (PortMapping, error) {
+	mapping := PortMapping{
+		HostIP:   "127.0.0.1",
+		Protocol: "tcp",
+	}
+
+	ports := spec
+	if idx := strings.LastIndex(spec, "/"); idx != -1 {
+		ports = spec[:idx]
+		mapping.Protocol = strings.ToLower(spec[idx+1:])
+		if mapping.Protocol != "tcp" && mapping.Protocol != "udp" {
+			return mapping, fmt.Errorf("invalid port %q: unsupported protocol %s", spec, mapping.Protocol)
+		}
+	}
+
+	parts := strings.Split(ports, ":")
+	var hostPort, vmPort string
+	switch len(parts) {
+	case 1:
+		hostPort, vmPort = parts[0], parts[0]
+	case 2:
+		hostPort, vmPort = parts[0], parts[1]
+	case 3:
+		if net.ParseIP(parts[0]) == nil {
+			return mapping, fmt.Errorf("invalid port %q: invalid host IP %s", spec, parts[0])
+		}
+		mapping.HostIP = parts[0]
+		hostPort, vmPort = parts[1], parts[2]
+	default:
+		return mapping, fmt.Errorf("invalid port %q: expected [HOST_IP:]HOST_PORT:VM_PORT[/PROTOCOL]", spec)
+	}
+
+	var err error
+	if mapping.HostPort, err = parsePortNumber(hostPort); err != nil {
+		return mapping, fmt.Errorf("invalid port %q: %w", spec, err)
+	}
+	if mapping.VMPort, err = parsePortNumber(vmPort); err != nil {
+		return mapping, fmt.Errorf("invalid port %q: %w", spec, err)
+	}
+
+	return mapping, nil
+}
+
+// parsePortNumber parses a TCP/UDP port number
+func parsePortNumber(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port number: %s", value)
+	}
+	return port, nil
+}
+
+// parseVMPorts parses the published ports of a VM
+func parseVMPorts(vmName string, vm VM) ([]PortMapping, error) {
+	var mappings []PortMapping
+	for _, spec := range vm.Ports {
+		mapping, err := parsePortMapping(spec)
+		if err != nil {
+			return nil, fmt.Errorf("VM %s: %w", vmName, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// usesPortProxy returns true if the published ports of a VM are forwarded by the host proxy
+// This is the case when the VM's first network is a bridge managed by qemu-compose, so its IP is known;
+// other VMs publish their ports through the user-mode NIC (QEMU hostfwd)
+func usesPortProxy(vm VM, config *ComposeConfig) bool {
+	if len(vm.Ports) == 0 || len(vm.Networks) == 0 {
+		return false
+	}
+
+	network := config.Networks[vm.Networks[0].Name]
+	return getNetworkDriver(network) == "bridge" && !isExternalNetwork(network)
+}
+
+// getHostForwardOptions returns the hostfwd options of a user-mode netdev for published ports
+// For example: ",hostfwd=tcp:127.0.0.1:8080-:80"
+func getHostForwardOptions(mappings []PortMapping) string {
+	var options strings.Builder
+	for _, mapping := range mappings {
+		fmt.Fprintf(&options, ",hostfwd=%s:%s:%d-:%d", mapping.Protocol, mapping.HostIP, mapping.HostPort, mapping.VMPort)
+	}
+	return options.String()
+}
+
+// checkPublishedPorts verifies that the host side of published ports is free
+func checkPublishedPorts(vmName string, mappings []PortMapping) error {
+	for _, mapping := range mappings {
+		address := net.JoinHostPort(mapping.HostIP, strconv.Itoa(mapping.HostPort))
+
+		var err error
+		if mapping.Protocol == "udp" {
+			var conn net.PacketConn
+			if conn, err = net.ListenPacket("udp", address); err == nil {
+				conn.Close()
+			}
+		} else {
+			var listener net.Listener
+			if listener, err = net.Listen("tcp", address); err == nil {
+				listener.Close()
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("cannot publish port %s for VM %s: %s/%s is already in use", mapping.String(), vmName, address, mapping.Protocol)
+		}
+	}
+	return nil
+}
+
+// getPortProxyUnitName returns the systemd unit name of a VM's port proxy
+func getPortProxyUnitName(vmName string) string {
+	sanitizedVM := strings.ReplaceAll(vmName, " ", "-")
+	return fmt.Sprintf("qemu-compose-proxy-%s-%s", getProjectUnitID(), sanitizedVM)
+}
+
+// isPortProxyRunning checks if the port proxy of a VM is running
+func isPortProxyRunning(vmName string) bool {
+	unitName := getPortProxyUnitName(vmName)
+	cmd := exec.Command("systemctl", "--user", "is-active", unitName)
+	output, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(string(output)) == "active"
+}
+
+// startPortProxy starts the port proxy of a VM under systemd-run --user
+// The proxy listens on the host side of each mapping and forwards to the VM IP
+func startPortProxy(vmName string, targetIP string, mappings []PortMapping) error {
+	unitName := getPortProxyUnitName(vmName)
+
+	// A proxy left by a previous run may point to a stale address
+	if isPortProxyRunning(vmName) {
+		if err := stopPortProxy(vmName); err != nil {
+			return err
+		}
+	}
+
+	proxyArgs, err := getPortProxyCommand(targetIP, mappings)
+	if err != nil {
+		return err
+	}
+
+	logger.Printf("Starting port proxy for VM %s (target: %s, %d port(s))", vmName, targetIP, len(mappings))
+
+	args := []string{
+		"systemd-run",
+		"--user",
+		"--unit=" + unitName,
+		"--description=qemu-compose port proxy for VM: " + vmName,
+		"--collect",
+		"--property=KillMode=mixed",
+		"--property=Type=simple",
+	}
+	args = append(args, proxyArgs...)
+
+	logger.Printf("Executing: %s", strings.Join(args, " "))
+
+	cmd := exec.Command(args[0], args[1:]...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start port proxy: %w\nOutput: %s", err, string(output))
+	}
+
+	logger.Printf("Port proxy started successfully for VM: %s (unit: %s)", vmName, unitName)
+	return nil
+}
+
+// getPortProxyCommand returns the command line of a port proxy forwarding published ports to targetIP
+func getPortProxyCommand(targetIP string, mappings []PortMapping) ([]string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine executable path: %w", err)
+	}
+
+	args := []string{execPath}
+	if debug {
+		args = append(args, "--debug")
+	}
+	args = append(args, "port-proxy", "--target", targetIP)
+	for _, mapping := range mappings {
+		args = append(args, "--publish", mapping.String())
+	}
+	return args, nil
+}
+
+// stopPortProxy stops the port proxy of a VM
+func stopPortProxy(vmName string) error {
+	unitName := getPortProxyUnitName(vmName)
+	logger.Printf("Stopping port proxy for VM: %s (unit: %s)", vmName, unitName)
+
+	cmd := exec.Command("systemctl", "--user", "stop", unitName)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		// Don't fail if unit doesn't exist
+		if !strings.Contains(string(output), "not loaded") && !strings.Contains(string(output), "not found") {
+			return fmt.Errorf("failed to stop port proxy %s: %w\nOutput: %s", unitName, err, string(output))
+		}
+	}
+
+	return nil
+}
+
+// runPortProxy forwards published ports to targetIP until the process is terminated
+func runPortProxy(targetIP string, mappings []PortMapping) error {
+	var closers []io.Closer
+
+	for _, mapping := range mappings {
+		listenAddress := net.JoinHostPort(mapping.HostIP, strconv.Itoa(mapping.HostPort))
+		targetAddress := net.JoinHostPort(targetIP, strconv.Itoa(mapping.VMPort))
+
+		if mapping.Protocol == "udp" {
+			conn, err := net.ListenPacket("udp", listenAddress)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s/udp: %w", listenAddress, err)
+			}
+			closers = append(closers, conn)
+			go proxyUDP(conn, targetAddress)
+		} else {
+			listener, err := net.Listen("tcp", listenAddress)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s/tcp: %w", listenAddress, err)
+			}
+			closers = append(closers, listener)
+			go proxyTCP(listener, targetAddress)
+		}
+
+		logger.Printf("Forwarding %s/%s -> %s", listenAddress, mapping.Protocol, targetAddress)
+	}
+
+	// Run until systemd stops the unit
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Printf("Received %s, stopping port proxy", sig)
+
+	for _, closer := range closers {
+		closer.Close()
+	}
+	return nil
+}
+
+// proxyTCP accepts connections on listener and forwards each of them to targetAddress
+func proxyTCP(listener net.Listener, targetAddress string) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			logger.Printf("Stopped accepting on %s: %v", listener.Addr(), err)
+			return
+		}
+
+		go func() {
+			defer client.Close()
+
+			target, err := net.DialTimeout("tcp", targetAddress, 10*time.Second)
+			if err != nil {
+				logger.Printf("Failed to connect to %s: %v", targetAddress, err)
+				return
+			}
+			defer target.Close()
+
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(target, client)
+				if tcpConn, ok := target.(*net.TCPConn); ok {
+					tcpConn.CloseWrite()
+				}
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(client, target)
+				if tcpConn, ok := client.(*net.TCPConn); ok {
+					tcpConn.CloseWrite()
+				}
+				done <- struct{}{}
+			}()
+			<-done
+			<-done
+		}()
+	}
+}
+
+// udpSessionTimeout is how long an idle UDP client mapping is kept
+const udpSessionTimeout = 60 * time.Second
+
+// proxyUDP forwards datagrams received on conn to targetAddress, one upstream socket per client
+func proxyUDP(conn net.PacketConn, targetAddress string) {
+	var mu sync.Mutex
+	sessions := make(map[string]net.Conn)
+
+	buf := make([]byte, 65535)
+	for {
+		n, clientAddr, err := conn.ReadFrom(buf)
+		if err != nil {
+			logger.Printf("Stopped reading on %s: %v", conn.LocalAddr(), err)
+			return
+		}
+
+		mu.Lock()
+		upstream, exists := sessions[clientAddr.String()]
+		if !exists {
+			upstream, err = net.Dial("udp", targetAddress)
+			if err != nil {
+				mu.Unlock()
+				logger.Printf("Failed to connect to %s: %v", targetAddress, err)
+				continue
+			}
+			sessions[clientAddr.String()] = upstream
+
+			// Relay replies to the client until the session is idle
+			go func(clientAddr net.Addr, upstream net.Conn) {
+				reply := make([]byte, 65535)
+				for {
+					upstream.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+					n, err := upstream.Read(reply)
+					if err != nil {
+						break
+					}
+					conn.WriteTo(reply[:n], clientAddr)
+				}
+
+				mu.Lock()
+				delete(sessions, clientAddr.String())
+				mu.Unlock()
+				upstream.Close()
+			}(clientAddr, upstream)
+		}
+		mu.Unlock()
+
+		upstream.Write(buf[:n])
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// qmpTimeout bounds connecting to a VM's QMP socket and waiting for a command reply
+const qmpTimeout = 10 * time.Second
+
+// QMPClient is a connection to the QMP (QEMU Machine Protocol) socket of a VM
+type QMPClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	events []*qmpMessage // Events received while waiting for command replies
+}
+
+// qmpMessage is a message received from QEMU: a greeting, a command reply or an event
+type qmpMessage struct {
+	Raw      []byte          `json:"-"`
+	Greeting json.RawMessage `json:"QMP,omitempty"`
+	Return   json.RawMessage `json:"return,omitempty"`
+	Error    *struct {
+		Class       string `json:"class"`
+		Description string `json:"desc"`
+	} `json:"error,omitempty"`
+	Event     string          `json:"event,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp struct {
+		Seconds      int64 `json:"seconds"`
+		Microseconds int64 `json:"microseconds"`
+	} `json:"timestamp"`
+}
+
+// connectQMP connects to the QMP socket of a running VM, for commands that disconnect right away
+// QEMU serves one client per QMP socket: other connections wait until the current client leaves
+func connectQMP(vmName string) (*QMPClient, error) {
+	return dialQMP(getQMPSocketPath(vmName))
+}
+
+// withQMP runs fn on a QMP connection to a running VM, closed when fn returns
+func withQMP(vmName string, fn func(client *QMPClient) error) error {
+	client, err := connectQMP(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return fn(client)
+}
+
+// connectQMPSession connects to the session QMP socket of a running VM, for clients that stay
+// connected (event stream, interactive monitor) without blocking the commands of other invocations
+func connectQMPSession(vmName string) (*QMPClient, error) {
+	return dialQMP(getQMPSessionSocketPath(vmName))
+}
+
+// dialQMP connects to a QMP socket and negotiates capabilities
+func dialQMP(socketPath string) (*QMPClient, error) {
+	if _, err := os.Stat(socketPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("QMP socket not found: %s (VM may still be starting, or was started before QMP support)", socketPath)
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath, qmpTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to QMP socket: %w", err)
+	}
+
+	client := &QMPClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	// QEMU sends a greeting, then waits for qmp_capabilities before accepting commands
+	greeting, err := client.readMessage(time.Now().Add(qmpTimeout))
+	if err != nil {
+		conn.Close()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, fmt.Errorf("no QMP greeting within %s, another client is connected to %s", qmpTimeout, socketPath)
+		}
+		return nil, fmt.Errorf("failed to read QMP greeting: %w", err)
+	}
+	if greeting.Greeting == nil {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected QMP greeting from %s", socketPath)
+	}
+
+	if _, err := client.Execute("qmp_capabilities", nil); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to negotiate QMP capabilities: %w", err)
+	}
+
+	logger.Printf("Connected to QMP socket: %s", socketPath)
+	return client, nil
+}
+
+// Close closes the QMP connection
+func (c *QMPClient) Close() error {
+	return c.conn.Close()
+}
+
+// readMessage reads one JSON message from QEMU, waiting until deadline
+func (c *QMPClient) readMessage(deadline time.Time) (*qmpMessage, error) {
+	c.conn.SetReadDeadline(deadline)
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	message := qmpMessage{Raw: line}
+	if err := json.Unmarshal(line, &message); err != nil {
+		return nil, fmt.Errorf("invalid QMP message: %w", err)
+	}
+	return &message, nil
+}
+
+// Execute runs a QMP command and returns its result
+// Events received while waiting for the reply are kept for WaitForEvent
+func (c *QMPClient) Execute(command string, arguments interface{}) (json.RawMessage, error) {
+	request := map[string]interface{}{"execute": command}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QMP command %s: %w", command, err)
+	}
+
+	logger.Printf("QMP command: %s", string(data))
+	c.conn.SetWriteDeadline(time.Now().Add(qmpTimeout))
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send QMP command %s: %w", command, err)
+	}
+
+	for {
+		message, err := c.readMessage(time.Now().Add(qmpTimeout))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reply to QMP command %s: %w", command, err)
+		}
+		if message.Event != "" {
+			logger.Printf("QMP event: %s", message.Event)
+			c.events = append(c.events, message)
+			continue
+		}
+		if message.Error != nil {
+			return nil, fmt.Errorf("QMP command %s failed: %s", command, message.Error.Description)
+		}
+		return message.Return, nil
+	}
+}
+
+// ReadEvent returns the next event, waiting until deadline
+// io.EOF is returned if QEMU closes the connection, e.g. when it exits
+func (c *QMPClient) ReadEvent(deadline time.Time) (*qmpMessage, error) {
+	if len(c.events) > 0 {
+		message := c.events[0]
+		c.events = c.events[1:]
+		return message, nil
+	}
+
+	for {
+		message, err := c.readMessage(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if message.Event == "" {
+			continue
+		}
+		logger.Printf("QMP event: %s %s", message.Event, string(message.Data))
+		return message, nil
+	}
+}
+
+// WaitForEvent waits until deadline for one of the named events and returns it
+// Other events are discarded
+func (c *QMPClient) WaitForEvent(deadline time.Time, names ...string) (*qmpMessage, error) {
+	for {
+		message, err := c.ReadEvent(deadline)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if message.Event == name {
+				return message, nil
+			}
+		}
+	}
+}
+
+// QMPStatus is the run state of a VM, as returned by query-status
+type QMPStatus struct {
+	Running bool   `json:"running"`
+	Status  string `json:"status"` // e.g. "running", "paused", "shutdown", "inmigrate"
+}
+
+// QueryStatus returns the run state of the VM
+func (c *QMPClient) QueryStatus() (*QMPStatus, error) {
+	result, err := c.Execute("query-status", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var status QMPStatus
+	if err := json.Unmarshal(result, &status); err != nil {
+		return nil, fmt.Errorf("invalid query-status reply: %w", err)
+	}
+	return &status, nil
+}
+
+// SystemPowerdown presses the ACPI power button of the VM
+func (c *QMPClient) SystemPowerdown() error {
+	_, err := c.Execute("system_powerdown", nil)
+	return err
+}
+
+// SystemReset resets the VM, like pressing its reset button
+func (c *QMPClient) SystemReset() error {
+	_, err := c.Execute("system_reset", nil)
+	return err
+}
+
+// SetShutdownAction sets what QEMU does when the guest shuts down: "poweroff" (default) or "pause"
+// Requires QEMU 6.0 or later
+func (c *QMPClient) SetShutdownAction(action string) error {
+	_, err := c.Execute("set-action", map[string]interface{}{"shutdown": action})
+	return err
+}
+
+// Pause stops the vCPUs of the VM (QMP stop), memory and devices stay as they are
+func (c *QMPClient) Pause() error {
+	_, err := c.Execute("stop", nil)
+	return err
+}
+
+// Resume restarts the vCPUs of a paused VM (QMP cont)
+func (c *QMPClient) Resume() error {
+	_, err := c.Execute("cont", nil)
+	return err
+}
+
+// HumanMonitorCommand runs an HMP (human monitor) command, such as "info network", and returns its output
+func (c *QMPClient) HumanMonitorCommand(command string) (string, error) {
+	result, err := c.Execute("human-monitor-command", map[string]interface{}{"command-line": command})
+	if err != nil {
+		return "", err
+	}
+
+	var output string
+	if err := json.Unmarshal(result, &output); err != nil {
+		return "", fmt.Errorf("invalid human-monitor-command reply: %w", err)
+	}
+	return output, nil
+}
+
+// runQMPCommand runs a QMP command on a VM and prints its result as indented JSON
+// argumentsJSON is an optional JSON object with the command arguments
+func runQMPCommand(vmName string, command string, argumentsJSON string) error {
+	// A nil map stored in an interface is not nil: arguments stays untyped nil without JSON arguments,
+	// so that Execute sends no "arguments" member
+	var arguments interface{}
+	if argumentsJSON != "" {
+		var object map[string]interface{}
+		if err := json.Unmarshal([]byte(argumentsJSON), &object); err != nil {
+			return fmt.Errorf("invalid JSON arguments: %w", err)
+		}
+		arguments = object
+	}
+
+	client, err := connectQMP(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	result, err := client.Execute(command, arguments)
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	if err := json.Indent(&output, result, "", "  "); err != nil {
+		return fmt.Errorf("invalid QMP reply: %w", err)
+	}
+	fmt.Println(output.String())
+	return nil
+}
+
+// runHMPCommand runs a single HMP command on a VM and prints its output
+func runHMPCommand(vmName string, command string) error {
+	client, err := connectQMP(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	output, err := client.HumanMonitorCommand(command)
+	if err != nil {
+		return err
+	}
+	fmt.Print(output)
+	return nil
+}
+
+// runHMPShell reads HMP commands from stdin and prints their output until EOF or exit
+// "quit" is intercepted: in HMP it terminates QEMU, which is what "stop --force" is for
+func runHMPShell(vmName string) error {
+	client, err := connectQMPSession(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	interactive := term.IsTerminal(int(os.Stdin.Fd()))
+	if interactive {
+		fmt.Printf("Connected to VM monitor: %s\n", vmName)
+		fmt.Printf("Type help for HMP commands, exit or Ctrl+D to leave\n\n")
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if interactive {
+			fmt.Print("(qemu) ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case "":
+			continue
+		case "exit":
+			return nil
+		case "quit", "q":
+			fmt.Println("quit would terminate QEMU, use exit to leave or qemu-compose stop --force to kill the VM")
+			continue
+		}
+
+		output, err := client.HumanMonitorCommand(line)
+		if err != nil {
+			// A closed connection cannot be recovered, other errors concern the command only
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("VM monitor connection closed")
+			}
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			continue
+		}
+		fmt.Print(output)
+	}
+
+	if interactive {
+		fmt.Println()
+	}
+	return scanner.Err()
+}
+
+// streamQMPEvents prints the QMP events of a VM as JSON lines until interrupted or QEMU exits
+func streamQMPEvents(vmName string) error {
+	client, err := connectQMPSession(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		client.Close()
+	}()
+
+	for {
+		// Events have no deadline: wait until one arrives or the connection is closed
+		message, err := client.ReadEvent(time.Time{})
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		fmt.Println(strings.TrimSpace(string(message.Raw)))
+	}
+}
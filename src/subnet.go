@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// defaultSubnetPool is the pool used for "subnet: auto" when QEMU_COMPOSE_SUBNET_POOL is not set
+const defaultSubnetPool = "172.16.0.0/12"
+
+// SubnetRegistration records which project network owns a subnet in the user-level registry
+type SubnetRegistration struct {
+	Project string `json:"project"` // Project directory
+	Network string `json:"network"`
+}
+
+// getSubnetRegistryPath returns the path of the user-level subnet registry shared by all projects
+func getSubnetRegistryPath() (string, error) {
+	dataDir, err := getUserDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "subnets.json"), nil
+}
+
+// getUserDataDir returns the user-level data directory shared by all projects
+func getUserDataDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	dataDir := filepath.Join(homeDir, ".local", "share", "qemu-compose")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	return dataDir, nil
+}
+
+// updateSubnetRegistry loads the subnet registry, applies fn and saves it back
+// The registry is locked for the whole operation so concurrent projects cannot allocate the same subnet
+func updateSubnetRegistry(fn func(registry map[string]SubnetRegistration) error) error {
+	registryPath, err := getSubnetRegistryPath()
+	if err != nil {
+		return err
+	}
+
+	lockFile, err := os.OpenFile(registryPath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open subnet registry lock: %w", err)
+	}
+	defer lockFile.Close()
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock subnet registry: %w", err)
+	}
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	registry := make(map[string]SubnetRegistration)
+	data, err := os.ReadFile(registryPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read subnet registry: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &registry); err != nil {
+			return fmt.Errorf("failed to parse subnet registry: %w", err)
+		}
+	}
+
+	// Drop registrations of projects that no longer exist
+	for subnet, registration := range registry {
+		if _, err := os.Stat(registration.Project); os.IsNotExist(err) {
+			logger.Printf("Removing stale subnet registration %s (project %s no longer exists)", subnet, registration.Project)
+			delete(registry, subnet)
+		}
+	}
+
+	if err := fn(registry); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal subnet registry: %w", err)
+	}
+
+	if err := os.WriteFile(registryPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write subnet registry: %w", err)
+	}
+
+	return nil
+}
+
+// releaseNetworkSubnets removes the registrations of a network of the current project
+func releaseNetworkSubnets(networkName string) error {
+	return updateSubnetRegistry(func(registry map[string]SubnetRegistration) error {
+		forgetNetworkRegistrations(registry, networkName)
+		return nil
+	})
+}
+
+// forgetNetworkRegistrations removes the registrations of a network of the current project from registry
+func forgetNetworkRegistrations(registry map[string]SubnetRegistration, networkName string) {
+	projectID := getProjectID()
+	for subnet, registration := range registry {
+		if registration.Project == projectID && registration.Network == networkName {
+			logger.Printf("Released subnet %s (network: %s)", subnet, networkName)
+			delete(registry, subnet)
+		}
+	}
+}
+
+// registerSubnet records a subnet already allocated to a network of the current project
+// Used for allocations made before the registry existed
+func registerSubnet(networkName string, subnet string) error {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return fmt.Errorf("failed to parse subnet %s: %w", subnet, err)
+	}
+
+	return updateSubnetRegistry(func(registry map[string]SubnetRegistration) error {
+		if conflict := findOverlappingRegistration(registry, ipNet, networkName); conflict != "" {
+			return fmt.Errorf("subnet %s of network %s overlaps %s", subnet, networkName, conflict)
+		}
+
+		forgetNetworkRegistrations(registry, networkName)
+		registry[ipNet.String()] = SubnetRegistration{
+			Project: getProjectID(),
+			Network: networkName,
+		}
+		return nil
+	})
+}
+
+// getSubnetPools returns the pools used for automatic subnet allocation
+// Pools are read from QEMU_COMPOSE_SUBNET_POOL (comma-separated CIDRs), default 172.16.0.0/12
+func getSubnetPools() ([]*net.IPNet, error) {
+	poolSpec := os.Getenv("QEMU_COMPOSE_SUBNET_POOL")
+	if poolSpec == "" {
+		poolSpec = defaultSubnetPool
+	}
+
+	var pools []*net.IPNet
+	for _, spec := range strings.Split(poolSpec, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		_, pool, err := net.ParseCIDR(spec)
+		if err != nil || pool.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid subnet pool %q in QEMU_COMPOSE_SUBNET_POOL", spec)
+		}
+
+		ones, _ := pool.Mask.Size()
+		if ones > 24 {
+			return nil, fmt.Errorf("subnet pool %s is smaller than a /24", spec)
+		}
+
+		pools = append(pools, pool)
+	}
+
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("QEMU_COMPOSE_SUBNET_POOL does not contain any subnet")
+	}
+
+	return pools, nil
+}
+
+// subnetsOverlap returns true if two subnets share at least one address
+func subnetsOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// findOverlappingRoute returns a description of the first host route overlapping a subnet
+// Routes on ignoreLink (the network's own bridge) and default routes are not considered
+func findOverlappingRoute(subnet *net.IPNet, ignoreLink string) (string, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return "", fmt.Errorf("failed to list host routes: %w", err)
+	}
+
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+		if ones, _ := route.Dst.Mask.Size(); ones == 0 {
+			continue
+		}
+
+		linkName := ""
+		if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil {
+			linkName = link.Attrs().Name
+		}
+		if linkName != "" && linkName == ignoreLink {
+			continue
+		}
+
+		if subnetsOverlap(subnet, route.Dst) {
+			if linkName == "" {
+				return route.Dst.String(), nil
+			}
+			return fmt.Sprintf("%s (dev %s)", route.Dst.String(), linkName), nil
+		}
+	}
+
+	return "", nil
+}
+
+// findOverlappingRegistration returns a description of the first subnet registered by another
+// project network that overlaps subnet
+func findOverlappingRegistration(registry map[string]SubnetRegistration, subnet *net.IPNet, networkName string) string {
+	projectID := getProjectID()
+	for registered, registration := range registry {
+		if registration.Project == projectID && registration.Network == networkName {
+			continue
+		}
+
+		_, registeredNet, err := net.ParseCIDR(registered)
+		if err != nil {
+			continue
+		}
+
+		if subnetsOverlap(subnet, registeredNet) {
+			return fmt.Sprintf("%s (network %s of project %s)", registered, registration.Network, registration.Project)
+		}
+	}
+
+	return ""
+}
+
+// checkManualSubnet verifies that a manual subnet does not collide with host routes or other projects
+// and registers it for the network
+func checkManualSubnet(networkName string, network Network, subnet string) error {
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("subnet %s is not a valid IPv4 CIDR", subnet)
+	}
+
+	// Host ranges are derived from the mask, the subnet must leave room for them
+	if _, _, err := getSubnetHostRange(ipNet); err != nil {
+		return fmt.Errorf("network %s: %w", networkName, err)
+	}
+
+	// Rootless networks never reach the host routing table
+	if !isRootlessNetwork(network) {
+		route, err := findOverlappingRoute(ipNet, getBridgeName(networkName))
+		if err != nil {
+			return err
+		}
+		if route != "" {
+			return fmt.Errorf("subnet %s of network %s overlaps host route %s", subnet, networkName, route)
+		}
+	}
+
+	return updateSubnetRegistry(func(registry map[string]SubnetRegistration) error {
+		if conflict := findOverlappingRegistration(registry, ipNet, networkName); conflict != "" {
+			return fmt.Errorf("subnet %s of network %s overlaps %s", subnet, networkName, conflict)
+		}
+
+		forgetNetworkRegistrations(registry, networkName)
+		registry[ipNet.String()] = SubnetRegistration{
+			Project: getProjectID(),
+			Network: networkName,
+		}
+		return nil
+	})
+}
+
+// allocateSubnet allocates a free /24 from the subnet pools for a network
+// A subnet is free if it is not used by the project, not registered by another project
+// and does not overlap any host route (Docker, VPN, LAN...)
+func allocateSubnet(networkName string) (string, error) {
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return "", err
+	}
+
+	pools, err := getSubnetPools()
+	if err != nil {
+		return "", err
+	}
+
+	// Collect subnets allocated in this project
+	var projectSubnets []*net.IPNet
+	for name, netMeta := range metadata {
+		if name == networkName || netMeta.Subnet == "" {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(netMeta.Subnet); err == nil {
+			projectSubnets = append(projectSubnets, ipNet)
+		}
+	}
+
+	allocated := ""
+	err = updateSubnetRegistry(func(registry map[string]SubnetRegistration) error {
+		for _, pool := range pools {
+			ones, bits := pool.Mask.Size()
+			count := uint32(1) << uint(24-ones)
+			base := binary.BigEndian.Uint32(pool.IP.To4())
+
+			for i := uint32(0); i < count; i++ {
+				candidateIP := make(net.IP, net.IPv4len)
+				binary.BigEndian.PutUint32(candidateIP, base+i<<8)
+				candidate := &net.IPNet{IP: candidateIP, Mask: net.CIDRMask(24, bits)}
+
+				if overlapsAny(candidate, projectSubnets) {
+					continue
+				}
+				if conflict := findOverlappingRegistration(registry, candidate, networkName); conflict != "" {
+					continue
+				}
+				route, err := findOverlappingRoute(candidate, getBridgeName(networkName))
+				if err != nil {
+					return err
+				}
+				if route != "" {
+					logger.Printf("Skipping subnet %s: overlaps host route %s", candidate.String(), route)
+					continue
+				}
+
+				allocated = candidate.String()
+				registry[allocated] = SubnetRegistration{
+					Project: getProjectID(),
+					Network: networkName,
+				}
+				return nil
+			}
+		}
+
+		return fmt.Errorf("no available subnets in pool (%s)", describeSubnetPools(pools))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	logger.Printf("Allocated subnet: %s", allocated)
+	return allocated, nil
+}
+
+// overlapsAny returns true if subnet overlaps one of subnets
+func overlapsAny(subnet *net.IPNet, subnets []*net.IPNet) bool {
+	for _, other := range subnets {
+		if subnetsOverlap(subnet, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeSubnetPools formats subnet pools for error messages
+func describeSubnetPools(pools []*net.IPNet) string {
+	var parts []string
+	for _, pool := range pools {
+		parts = append(parts, pool.String())
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,742 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Ethernet and IP protocol constants used by the userspace switch
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+
+	ipProtoUDP = 17
+
+	dhcpServerPort = 67
+	dhcpClientPort = 68
+	dnsPort        = 53
+
+	dhcpLeaseTime = 12 * time.Hour
+)
+
+// DHCP message types (RFC 2132, option 53)
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpDecline  = 4
+	dhcpAck      = 5
+	dhcpNak      = 6
+	dhcpRelease  = 7
+)
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// switchPort represents a QEMU netdev connected to the switch
+type switchPort struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+// send writes a single Ethernet frame to the port using QEMU's stream framing
+// (4-byte big-endian length followed by the frame)
+func (p *switchPort) send(frame []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(frame)))
+	if _, err := p.conn.Write(append(header, frame...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// networkSwitch is a userspace Ethernet switch with built-in DHCP and DNS
+// used by rootless networks (driver: user or socket)
+type networkSwitch struct {
+	networkName string
+	subnet      *net.IPNet
+	gatewayIP   net.IP
+	firstHost   uint32 // DHCP range of the subnet, see getSubnetHostRange
+	lastHost    uint32
+	gatewayMAC  net.HardwareAddr
+	leasesPath  string
+	aliasesDir  string
+
+	mu     sync.Mutex
+	ports  map[*switchPort]bool
+	fdb    map[string]*switchPort // MAC address -> port
+	leases map[string]NetworkLease
+}
+
+// newNetworkSwitch creates a switch for the given subnet
+func newNetworkSwitch(networkName string, subnet string, stateDir string) (*networkSwitch, error) {
+	ip, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subnet %s: %w", subnet, err)
+	}
+
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("subnet %s is not a valid IPv4 address", subnet)
+	}
+
+	firstHost, lastHost, err := getSubnetHostRange(ipNet)
+	if err != nil {
+		return nil, err
+	}
+
+	// The gateway is the first address of the subnet
+	gateway := uint32ToIP(binary.BigEndian.Uint32(ipNet.IP.To4()) + 1)
+
+	// Locally administered MAC derived from the network name
+	hash := md5.Sum([]byte(networkName))
+	gatewayMAC := net.HardwareAddr{0x02, 0x51, 0x43, hash[0], hash[1], hash[2]}
+
+	s := &networkSwitch{
+		networkName: networkName,
+		subnet:      ipNet,
+		gatewayIP:   gateway,
+		firstHost:   firstHost,
+		lastHost:    lastHost,
+		gatewayMAC:  gatewayMAC,
+		leasesPath:  filepath.Join(stateDir, "leases"),
+		aliasesDir:  getDNSAliasesDir(stateDir),
+		ports:       make(map[*switchPort]bool),
+		fdb:         make(map[string]*switchPort),
+		leases:      make(map[string]NetworkLease),
+	}
+
+	// Reload leases from a previous run so VMs keep their addresses
+	existing, err := parseLeasesFile(s.leasesPath)
+	if err != nil {
+		logger.Printf("Warning: could not load leases: %v", err)
+	}
+	for _, lease := range existing {
+		if lease.Expiry.After(time.Now()) {
+			s.leases[lease.MAC] = lease
+		}
+	}
+
+	return s, nil
+}
+
+// runNetworkSwitch listens on a Unix socket and switches frames between connected VMs
+func runNetworkSwitch(networkName string, socketPath string, subnet string, stateDir string) error {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	s, err := newNetworkSwitch(networkName, subnet, stateDir)
+	if err != nil {
+		return err
+	}
+
+	// Remove stale socket from a previous run
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale socket %s: %w", socketPath, err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+
+	logger.Printf("Switch for network %s listening on %s (gateway: %s, MAC: %s)", networkName, socketPath, s.gatewayIP, s.gatewayMAC)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		logger.Printf("Switch for network %s shutting down", networkName)
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		port := &switchPort{conn: conn}
+		s.mu.Lock()
+		s.ports[port] = true
+		s.mu.Unlock()
+
+		logger.Printf("Port connected to switch %s", networkName)
+		go s.servePort(port)
+	}
+}
+
+// servePort reads frames from a port until it disconnects
+func (s *networkSwitch) servePort(port *switchPort) {
+	defer func() {
+		port.conn.Close()
+		s.mu.Lock()
+		delete(s.ports, port)
+		for mac, p := range s.fdb {
+			if p == port {
+				delete(s.fdb, mac)
+			}
+		}
+		s.mu.Unlock()
+		logger.Printf("Port disconnected from switch %s", s.networkName)
+	}()
+
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(port.conn, header); err != nil {
+			return
+		}
+
+		length := binary.BigEndian.Uint32(header)
+		if length > 65536 {
+			logger.Printf("Dropping oversized frame (%d bytes)", length)
+			return
+		}
+
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(port.conn, frame); err != nil {
+			return
+		}
+
+		s.handleFrame(port, frame)
+	}
+}
+
+// handleFrame learns the source MAC address and forwards or answers a frame
+func (s *networkSwitch) handleFrame(from *switchPort, frame []byte) {
+	if len(frame) < 14 {
+		return
+	}
+
+	dst := net.HardwareAddr(frame[0:6])
+	src := net.HardwareAddr(frame[6:12])
+
+	s.mu.Lock()
+	s.fdb[src.String()] = from
+	s.mu.Unlock()
+
+	isBroadcast := dst[0]&1 == 1
+	if isBroadcast || dst.String() == s.gatewayMAC.String() {
+		s.handleLocal(from, frame)
+		if !isBroadcast {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	target, known := s.fdb[dst.String()]
+	var targets []*switchPort
+	if !isBroadcast && known {
+		targets = append(targets, target)
+	} else {
+		for port := range s.ports {
+			if port != from {
+				targets = append(targets, port)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, port := range targets {
+		if err := port.send(frame); err != nil {
+			logger.Printf("Failed to forward frame: %v", err)
+		}
+	}
+}
+
+// handleLocal answers ARP, DHCP and DNS traffic addressed to the switch itself
+func (s *networkSwitch) handleLocal(from *switchPort, frame []byte) {
+	etherType := binary.BigEndian.Uint16(frame[12:14])
+	payload := frame[14:]
+
+	switch etherType {
+	case etherTypeARP:
+		s.handleARP(from, frame[6:12], payload)
+	case etherTypeIPv4:
+		if len(payload) < 20 {
+			return
+		}
+		headerLen := int(payload[0]&0x0f) * 4
+		if payload[9] != ipProtoUDP || len(payload) < headerLen+8 {
+			return
+		}
+
+		srcIP := net.IP(payload[12:16])
+		dstIP := net.IP(payload[16:20])
+		udp := payload[headerLen:]
+		srcPort := binary.BigEndian.Uint16(udp[0:2])
+		dstPort := binary.BigEndian.Uint16(udp[2:4])
+		data := udp[8:]
+
+		switch {
+		case dstPort == dhcpServerPort:
+			s.handleDHCP(from, data)
+		case dstPort == dnsPort && dstIP.Equal(s.gatewayIP):
+			// Resolution may hit the host resolver, do not block the port
+			clientMAC := net.HardwareAddr(append([]byte{}, frame[6:12]...))
+			query := append([]byte{}, data...)
+			go s.handleDNS(from, clientMAC, srcIP, srcPort, query)
+		}
+	}
+}
+
+// handleARP replies to ARP requests for the gateway address
+func (s *networkSwitch) handleARP(from *switchPort, srcMAC []byte, arp []byte) {
+	if len(arp) < 28 {
+		return
+	}
+
+	operation := binary.BigEndian.Uint16(arp[6:8])
+	targetIP := net.IP(arp[24:28])
+	if operation != 1 || !targetIP.Equal(s.gatewayIP) {
+		return
+	}
+
+	reply := make([]byte, 28)
+	copy(reply[0:6], arp[0:6]) // hardware type, protocol type, sizes
+	binary.BigEndian.PutUint16(reply[6:8], 2)
+	copy(reply[8:14], s.gatewayMAC)
+	copy(reply[14:18], s.gatewayIP.To4())
+	copy(reply[18:24], arp[8:14])
+	copy(reply[24:28], arp[14:18])
+
+	frame := buildEthernetFrame(net.HardwareAddr(srcMAC), s.gatewayMAC, etherTypeARP, reply)
+	if err := from.send(frame); err != nil {
+		logger.Printf("Failed to send ARP reply: %v", err)
+	}
+}
+
+// handleDHCP implements a minimal DHCPv4 server (DISCOVER/REQUEST/RELEASE)
+func (s *networkSwitch) handleDHCP(from *switchPort, packet []byte) {
+	if len(packet) < 240 || packet[0] != 1 {
+		return
+	}
+
+	xid := packet[4:8]
+	flags := packet[10:12]
+	clientMAC := net.HardwareAddr(append([]byte{}, packet[28:34]...))
+	options := parseDHCPOptions(packet[240:])
+
+	messageType := 0
+	if value, ok := options[53]; ok && len(value) == 1 {
+		messageType = int(value[0])
+	}
+
+	hostname := ""
+	if value, ok := options[12]; ok {
+		hostname = string(value)
+	}
+
+	var requestedIP net.IP
+	if value, ok := options[50]; ok && len(value) == 4 {
+		requestedIP = net.IP(value)
+	} else if ciaddr := net.IP(packet[12:16]); !ciaddr.IsUnspecified() {
+		requestedIP = ciaddr
+	}
+
+	logger.Printf("DHCP message type %d from %s (hostname: %q)", messageType, clientMAC, hostname)
+
+	switch messageType {
+	case dhcpDiscover:
+		ip, err := s.allocateAddress(clientMAC)
+		if err != nil {
+			logger.Printf("DHCP: %v", err)
+			return
+		}
+		s.sendDHCPReply(from, dhcpOffer, xid, flags, clientMAC, ip)
+
+	case dhcpRequest:
+		ip, err := s.allocateAddress(clientMAC)
+		if err != nil {
+			logger.Printf("DHCP: %v", err)
+			return
+		}
+		if requestedIP != nil && !requestedIP.Equal(ip) {
+			logger.Printf("DHCP: %s requested %s but is assigned %s, sending NAK", clientMAC, requestedIP, ip)
+			s.sendDHCPReply(from, dhcpNak, xid, flags, clientMAC, nil)
+			return
+		}
+		s.commitLease(clientMAC, ip, hostname)
+		s.sendDHCPReply(from, dhcpAck, xid, flags, clientMAC, ip)
+
+	case dhcpRelease, dhcpDecline:
+		s.mu.Lock()
+		delete(s.leases, clientMAC.String())
+		s.mu.Unlock()
+		s.saveLeases()
+	}
+}
+
+// getSubnetHostRange returns the host range of a subnet handed out by DHCP, as big-endian integers
+// Subnets of 64 addresses and more keep the first 10 and last 5 addresses out of the range
+// (.10-.250 in a /24), smaller ones hand out everything after the gateway
+func getSubnetHostRange(subnet *net.IPNet) (uint32, uint32, error) {
+	ones, bits := subnet.Mask.Size()
+	if bits != 32 {
+		return 0, 0, fmt.Errorf("subnet %s is not an IPv4 subnet", subnet)
+	}
+	if ones > 29 {
+		return 0, 0, fmt.Errorf("subnet %s is too small (the largest prefix length is /29)", subnet)
+	}
+
+	network := binary.BigEndian.Uint32(subnet.IP.To4())
+	broadcast := network | ^binary.BigEndian.Uint32(net.IP(subnet.Mask).To4())
+	if broadcast-network+1 >= 64 {
+		return network + 10, broadcast - 5, nil
+	}
+	return network + 2, broadcast - 1, nil
+}
+
+// uint32ToIP converts a big-endian integer to an IPv4 address
+func uint32ToIP(value uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, value)
+	return ip
+}
+
+// allocateAddress returns the address leased to a MAC, or the first free one in the DHCP range
+func (s *networkSwitch) allocateAddress(mac net.HardwareAddr) (net.IP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lease, exists := s.leases[mac.String()]; exists {
+		return net.ParseIP(lease.IP).To4(), nil
+	}
+
+	used := make(map[string]bool)
+	for _, lease := range s.leases {
+		used[lease.IP] = true
+	}
+
+	for host := s.firstHost; host <= s.lastHost; host++ {
+		candidate := uint32ToIP(host)
+		if !used[candidate.String()] {
+			return candidate, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no free address left in %s", s.subnet)
+}
+
+// commitLease records a lease and persists the lease table
+func (s *networkSwitch) commitLease(mac net.HardwareAddr, ip net.IP, hostname string) {
+	s.mu.Lock()
+	s.leases[mac.String()] = NetworkLease{
+		Expiry:   time.Now().Add(dhcpLeaseTime),
+		MAC:      mac.String(),
+		IP:       ip.String(),
+		Hostname: hostname,
+	}
+	s.mu.Unlock()
+
+	logger.Printf("DHCP: leased %s to %s (hostname: %q)", ip, mac, hostname)
+	s.saveLeases()
+}
+
+// saveLeases writes the lease table in dnsmasq lease file format
+func (s *networkSwitch) saveLeases() {
+	s.mu.Lock()
+	var builder strings.Builder
+	for _, lease := range s.leases {
+		hostname := lease.Hostname
+		if hostname == "" {
+			hostname = "*"
+		}
+		builder.WriteString(fmt.Sprintf("%d %s %s %s *\n", lease.Expiry.Unix(), lease.MAC, lease.IP, hostname))
+	}
+	s.mu.Unlock()
+
+	if err := os.WriteFile(s.leasesPath, []byte(builder.String()), 0644); err != nil {
+		logger.Printf("Warning: failed to write leases: %v", err)
+	}
+}
+
+// sendDHCPReply builds and sends a DHCP OFFER, ACK or NAK
+func (s *networkSwitch) sendDHCPReply(to *switchPort, messageType byte, xid []byte, flags []byte, clientMAC net.HardwareAddr, yourIP net.IP) {
+	packet := make([]byte, 240)
+	packet[0] = 2 // BOOTREPLY
+	packet[1] = 1 // Ethernet
+	packet[2] = 6 // hardware address length
+	copy(packet[4:8], xid)
+	copy(packet[10:12], flags)
+	if yourIP != nil {
+		copy(packet[16:20], yourIP.To4())
+	}
+	copy(packet[20:24], s.gatewayIP.To4())
+	copy(packet[28:34], clientMAC)
+	copy(packet[236:240], []byte{99, 130, 83, 99}) // magic cookie
+
+	packet = append(packet, 53, 1, messageType)
+	packet = append(packet, 54, 4)
+	packet = append(packet, s.gatewayIP.To4()...)
+
+	if messageType != dhcpNak {
+		leaseSeconds := make([]byte, 4)
+		binary.BigEndian.PutUint32(leaseSeconds, uint32(dhcpLeaseTime.Seconds()))
+		packet = append(packet, 51, 4)
+		packet = append(packet, leaseSeconds...)
+		packet = append(packet, 1, 4)
+		packet = append(packet, s.subnet.Mask...)
+		// No router option: rootless networks do not route to the outside
+		packet = append(packet, 6, 4)
+		packet = append(packet, s.gatewayIP.To4()...)
+	}
+	packet = append(packet, 255)
+
+	udp := buildUDPPacket(s.gatewayIP, net.IPv4bcast, dhcpServerPort, dhcpClientPort, packet)
+	frame := buildEthernetFrame(broadcastMAC, s.gatewayMAC, etherTypeIPv4, udp)
+	if err := to.send(frame); err != nil {
+		logger.Printf("Failed to send DHCP reply: %v", err)
+	}
+}
+
+// handleDNS answers A/AAAA queries for leased hostnames and forwards the rest to the host resolver
+func (s *networkSwitch) handleDNS(to *switchPort, clientMAC net.HardwareAddr, clientIP net.IP, clientPort uint16, query []byte) {
+	if len(query) < 12 {
+		return
+	}
+
+	// Only a single question is supported
+	if binary.BigEndian.Uint16(query[4:6]) != 1 {
+		return
+	}
+
+	name, offset, err := parseDNSName(query, 12)
+	if err != nil || len(query) < offset+4 {
+		return
+	}
+	qtype := binary.BigEndian.Uint16(query[offset : offset+2])
+	question := query[12 : offset+4]
+
+	var answers []net.IP
+	rcode := 0
+
+	switch qtype {
+	case 1, 28: // A, AAAA
+		answers = s.resolveName(name, qtype == 28)
+		if len(answers) == 0 && qtype == 1 {
+			rcode = 3 // NXDOMAIN
+		}
+	default:
+		rcode = 4 // NOTIMP
+	}
+
+	response := make([]byte, 12)
+	copy(response[0:2], query[0:2])
+	flags := uint16(0x8180) | uint16(rcode)
+	binary.BigEndian.PutUint16(response[2:4], flags)
+	binary.BigEndian.PutUint16(response[4:6], 1)
+	binary.BigEndian.PutUint16(response[6:8], uint16(len(answers)))
+	response = append(response, question...)
+
+	for _, ip := range answers {
+		rdata := ip.To4()
+		rtype := uint16(1)
+		if qtype == 28 {
+			rdata = ip.To16()
+			rtype = 28
+		}
+		record := []byte{0xc0, 0x0c, 0, 0, 0, 1, 0, 0, 0, 60, 0, 0}
+		binary.BigEndian.PutUint16(record[2:4], rtype)
+		binary.BigEndian.PutUint16(record[10:12], uint16(len(rdata)))
+		response = append(response, record...)
+		response = append(response, rdata...)
+	}
+
+	udp := buildUDPPacket(s.gatewayIP, clientIP, dnsPort, clientPort, response)
+	frame := buildEthernetFrame(clientMAC, s.gatewayMAC, etherTypeIPv4, udp)
+	if err := to.send(frame); err != nil {
+		logger.Printf("Failed to send DNS reply: %v", err)
+	}
+}
+
+// resolveName looks up a name among the leased hostnames, then on the host
+func (s *networkSwitch) resolveName(name string, ipv6 bool) []net.IP {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	s.mu.Lock()
+	for _, lease := range s.leases {
+		if strings.ToLower(lease.Hostname) == name {
+			s.mu.Unlock()
+			if ipv6 {
+				return nil
+			}
+			return []net.IP{net.ParseIP(lease.IP)}
+		}
+	}
+	s.mu.Unlock()
+
+	// VM names and aliases registered for this network
+	if ip := s.resolveAlias(name); ip != nil {
+		if ipv6 {
+			return nil
+		}
+		return []net.IP{ip}
+	}
+
+	// Unqualified names that are not VMs are not forwarded
+	if !strings.Contains(name, ".") {
+		return nil
+	}
+
+	network := "ip4"
+	if ipv6 {
+		network = "ip6"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, name)
+	if err != nil {
+		logger.Printf("DNS: lookup of %s failed: %v", name, err)
+		return nil
+	}
+	return ips
+}
+
+// resolveAlias looks up a name in the alias files of the network and returns the leased address
+// of the matching MAC address
+func (s *networkSwitch) resolveAlias(name string) net.IP {
+	entries, err := os.ReadDir(s.aliasesDir)
+	if err != nil {
+		return nil
+	}
+
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(s.aliasesDir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+
+			for _, alias := range fields[1:] {
+				if strings.ToLower(alias) != name {
+					continue
+				}
+
+				s.mu.Lock()
+				lease, exists := s.leases[strings.ToLower(fields[0])]
+				s.mu.Unlock()
+				if exists {
+					return net.ParseIP(lease.IP)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseDHCPOptions parses DHCP options into a map of code -> value
+func parseDHCPOptions(data []byte) map[byte][]byte {
+	options := make(map[byte][]byte)
+	for i := 0; i < len(data); {
+		code := data[i]
+		if code == 255 {
+			break
+		}
+		if code == 0 {
+			i++
+			continue
+		}
+		if i+1 >= len(data) {
+			break
+		}
+		length := int(data[i+1])
+		if i+2+length > len(data) {
+			break
+		}
+		options[code] = data[i+2 : i+2+length]
+		i += 2 + length
+	}
+	return options
+}
+
+// parseDNSName decodes an uncompressed DNS name starting at offset
+func parseDNSName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	for {
+		if offset >= len(data) {
+			return "", 0, fmt.Errorf("truncated DNS name")
+		}
+		length := int(data[offset])
+		if length == 0 {
+			offset++
+			break
+		}
+		if length&0xc0 != 0 {
+			return "", 0, fmt.Errorf("compressed names are not supported in questions")
+		}
+		if offset+1+length > len(data) {
+			return "", 0, fmt.Errorf("truncated DNS label")
+		}
+		labels = append(labels, string(data[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+	return strings.Join(labels, "."), offset, nil
+}
+
+// buildEthernetFrame prepends an Ethernet header to a payload
+func buildEthernetFrame(dst, src net.HardwareAddr, etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14, 14+len(payload))
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+	return append(frame, payload...)
+}
+
+// buildUDPPacket builds an IPv4/UDP packet (UDP checksum is left at zero, which IPv4 allows)
+func buildUDPPacket(srcIP, dstIP net.IP, srcPort, dstPort uint16, payload []byte) []byte {
+	udpLength := 8 + len(payload)
+	totalLength := 20 + udpLength
+
+	packet := make([]byte, 28, totalLength)
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(totalLength))
+	packet[8] = 64
+	packet[9] = ipProtoUDP
+	copy(packet[12:16], srcIP.To4())
+	copy(packet[16:20], dstIP.To4())
+	binary.BigEndian.PutUint16(packet[10:12], ipChecksum(packet[0:20]))
+
+	binary.BigEndian.PutUint16(packet[20:22], srcPort)
+	binary.BigEndian.PutUint16(packet[22:24], dstPort)
+	binary.BigEndian.PutUint16(packet[24:26], uint16(udpLength))
+
+	return append(packet, payload...)
+}
+
+// ipChecksum computes the Internet checksum of an IPv4 header
+func ipChecksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i : i+2]))
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// systemdUnitFile is a unit file written by "systemd generate"
+type systemdUnitFile struct {
+	Name    string // File name, e.g. qemu-compose-myproject-web.service
+	Content string
+}
+
+// systemdProjectHeader marks the unit files of a project, so that they can be found again
+const systemdProjectHeader = "# Project directory: "
+
+// getUserUnitDir returns the directory of the user's systemd unit files (~/.config/systemd/user)
+func getUserUnitDir() (string, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		configDir = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configDir, "systemd", "user"), nil
+}
+
+// isUserUnitInstalled checks if "systemd generate" wrote a unit file for a service
+// Installed units are started with systemctl instead of systemd-run
+func isUserUnitInstalled(unitName string) bool {
+	unitDir, err := getUserUnitDir()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(unitDir, unitName+".service"))
+	return err == nil
+}
+
+// getProjectTargetName returns the systemd target grouping the VMs of the project
+func getProjectTargetName() string {
+	return fmt.Sprintf("qemu-compose-%s.target", getProjectUnitID())
+}
+
+// escapeSystemdSpecifiers escapes % so that systemd does not expand it as a specifier
+func escapeSystemdSpecifiers(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// quoteSystemdArg quotes an argument of an Exec line
+// $ is escaped to prevent environment variable expansion, % to prevent specifier expansion
+func quoteSystemdArg(arg string) string {
+	arg = strings.ReplaceAll(escapeSystemdSpecifiers(arg), "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	return `"` + arg + `"`
+}
+
+// formatSystemdCommand formats a command line for an Exec line, with an absolute executable path
+func formatSystemdCommand(args []string) (string, error) {
+	executable := args[0]
+	if !filepath.IsAbs(executable) {
+		path, err := exec.LookPath(executable)
+		if err != nil {
+			return "", fmt.Errorf("%s not found: %w", executable, err)
+		}
+		executable = path
+	}
+
+	quoted := []string{quoteSystemdArg(executable)}
+	for _, arg := range args[1:] {
+		quoted = append(quoted, quoteSystemdArg(arg))
+	}
+	return strings.Join(quoted, " "), nil
+}
+
+// getSystemdHelperCommand returns the Exec line running a qemu-compose subcommand on the compose file
+func getSystemdHelperCommand(composeFilePath string, args ...string) (string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine executable path: %w", err)
+	}
+	return formatSystemdCommand(append([]string{execPath, "--file", composeFilePath}, args...))
+}
+
+// newSystemdUnit starts the content of a unit file with the header of the project
+func newSystemdUnit(projectDir string) *strings.Builder {
+	var unit strings.Builder
+	unit.WriteString("# Generated by qemu-compose systemd generate, changes are overwritten\n")
+	unit.WriteString(systemdProjectHeader + projectDir + "\n\n")
+	return &unit
+}
+
+// generateSystemdUnits builds the unit files of a project: one service per VM, dnsmasq instance
+// and port proxy, and a target starting all VMs
+// VM instances must exist (created by up), their QEMU command is generated from them
+func generateSystemdUnits(config *ComposeConfig, composeFilePath string) ([]systemdUnitFile, error) {
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	composeFilePath, err = filepath.Abs(composeFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve compose file path: %w", err)
+	}
+
+	vmNames, err := getVMStartOrder(config, getSortedVMNames(config))
+	if err != nil {
+		return nil, err
+	}
+
+	var units []systemdUnitFile
+	dnsmasqNetworks := make(map[string]bool)
+	var vmUnits []string
+
+	for _, vmName := range vmNames {
+		vm := config.VMs[vmName]
+
+		for _, networkName := range getVMNetworkNames(vm) {
+			network := config.Networks[networkName]
+			if isMacvtapNetwork(network) {
+				return nil, fmt.Errorf("VM %s: macvtap network %s is not supported by systemd units (its device changes across reboots)", vmName, networkName)
+			}
+			if getNetworkDriver(network) == "bridge" && !isExternalNetwork(network) && !dnsmasqNetworks[networkName] {
+				dnsmasqNetworks[networkName] = true
+				unit, err := generateDnsmasqUnit(networkName, config, composeFilePath, projectDir)
+				if err != nil {
+					return nil, err
+				}
+				units = append(units, *unit)
+			}
+		}
+
+		vmUnit, err := generateVMUnit(vmName, vm, config, composeFilePath, projectDir)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, *vmUnit)
+		vmUnits = append(vmUnits, vmUnit.Name)
+
+		consoleLoggerUnit, err := generateConsoleLoggerUnit(vmName, projectDir)
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, *consoleLoggerUnit)
+
+		if usesPortProxy(vm, config) {
+			proxyUnit, err := generatePortProxyUnit(vmName, vm, projectDir)
+			if err != nil {
+				return nil, err
+			}
+			if proxyUnit != nil {
+				units = append(units, *proxyUnit)
+			}
+		}
+	}
+
+	target := newSystemdUnit(projectDir)
+	target.WriteString("[Unit]\n")
+	fmt.Fprintf(target, "Description=%s\n", escapeSystemdSpecifiers("qemu-compose project: "+getProjectName()))
+	if len(vmUnits) > 0 {
+		fmt.Fprintf(target, "Wants=%s\n", strings.Join(vmUnits, " "))
+	}
+	target.WriteString("\n[Install]\n")
+	target.WriteString("WantedBy=default.target\n")
+	units = append(units, systemdUnitFile{Name: getProjectTargetName(), Content: target.String()})
+
+	return units, nil
+}
+
+// generateVMUnit builds the service of a VM, with the QEMU command of buildQEMUCommand in the console multiplexer
+// Networks are set up before QEMU starts (systemd prepare) and torn down after it exits (systemd cleanup)
+func generateVMUnit(vmName string, vm VM, config *ComposeConfig, composeFilePath string, projectDir string) (*systemdUnitFile, error) {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		return nil, err
+	}
+	instanceDiskPath := filepath.Join(instanceDir, "disk.qcow2")
+	if _, err := os.Stat(instanceDiskPath); err != nil {
+		return nil, fmt.Errorf("instance disk of VM %s not found, run qemu-compose up first", vmName)
+	}
+
+	launch, err := prepareVMLaunch(vmName, vm, instanceDiskPath, config, composeFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	execStart, err := formatSystemdCommand(launch.Command)
+	if err != nil {
+		return nil, err
+	}
+	execStartPre, err := getSystemdHelperCommand(composeFilePath, "systemd", "prepare", vmName)
+	if err != nil {
+		return nil, err
+	}
+	execStopPost, err := getSystemdHelperCommand(composeFilePath, "systemd", "cleanup", vmName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dependencies: dnsmasq of the VM networks, then the VMs of depends_on
+	var dependencies []string
+	for _, networkName := range getVMNetworkNames(vm) {
+		network := config.Networks[networkName]
+		if getNetworkDriver(network) == "bridge" && !isExternalNetwork(network) {
+			dependencies = append(dependencies, getDnsmasqUnitName(networkName)+".service")
+		}
+	}
+	for _, dependency := range vm.DependsOn {
+		dependencies = append(dependencies, getVMUnitName(dependency)+".service")
+	}
+
+	// StartLimit* belong to [Unit] in unit files, the other restart properties to [Service]
+	var startLimits, restartProperties []string
+	for _, property := range launch.RestartProperties {
+		if strings.HasPrefix(property, "StartLimit") {
+			startLimits = append(startLimits, property)
+		} else {
+			restartProperties = append(restartProperties, property)
+		}
+	}
+
+	unit := newSystemdUnit(projectDir)
+	unit.WriteString("[Unit]\n")
+	fmt.Fprintf(unit, "Description=%s\n", escapeSystemdSpecifiers("qemu-compose VM: "+vmName))
+	fmt.Fprintf(unit, "PartOf=%s\n", getProjectTargetName())
+	if len(dependencies) > 0 {
+		fmt.Fprintf(unit, "Wants=%s\n", strings.Join(dependencies, " "))
+		fmt.Fprintf(unit, "After=%s\n", strings.Join(dependencies, " "))
+	}
+	if usesPortProxy(vm, config) && len(launch.PortMappings) > 0 {
+		fmt.Fprintf(unit, "Wants=%s.service\n", getPortProxyUnitName(vmName))
+	}
+	fmt.Fprintf(unit, "Wants=%s.service\n", getConsoleLoggerUnitName(vmName))
+	for _, property := range startLimits {
+		unit.WriteString(property + "\n")
+	}
+
+	unit.WriteString("\n[Service]\n")
+	unit.WriteString("Type=simple\n")
+	unit.WriteString("KillMode=mixed\n")
+	fmt.Fprintf(unit, "WorkingDirectory=%s\n", escapeSystemdSpecifiers(projectDir))
+	fmt.Fprintf(unit, "ExecStartPre=%s\n", execStartPre)
+	fmt.Fprintf(unit, "ExecStart=%s\n", execStart)
+	fmt.Fprintf(unit, "ExecStopPost=%s\n", execStopPost)
+	for _, property := range restartProperties {
+		unit.WriteString(property + "\n")
+	}
+	for _, property := range launch.ResourceProperties {
+		unit.WriteString(property + "\n")
+	}
+
+	return &systemdUnitFile{Name: getVMUnitName(vmName) + ".service", Content: unit.String()}, nil
+}
+
+// generateDnsmasqUnit builds the dnsmasq service of a bridge network
+// The bridge, NAT and firewall rules are set up before dnsmasq starts (systemd prepare-network)
+func generateDnsmasqUnit(networkName string, config *ComposeConfig, composeFilePath string, projectDir string) (*systemdUnitFile, error) {
+	network := config.Networks[networkName]
+
+	subnet, err := resolveNetworkSubnet(networkName, network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve subnet for network %s: %w", networkName, err)
+	}
+	subnetV6, err := resolveNetworkSubnetV6(networkName, network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve IPv6 subnet for network %s: %w", networkName, err)
+	}
+
+	dnsmasqArgs, err := getDnsmasqCommand(networkName, subnet, subnetV6)
+	if err != nil {
+		return nil, err
+	}
+
+	// dnsmasq binds to port 67 (DHCP): it runs through sudo, without password prompt
+	execStart, err := formatSystemdCommand(append([]string{"sudo", "-n"}, dnsmasqArgs...))
+	if err != nil {
+		return nil, err
+	}
+	execStartPre, err := getSystemdHelperCommand(composeFilePath, "systemd", "prepare-network", networkName)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := newSystemdUnit(projectDir)
+	unit.WriteString("[Unit]\n")
+	fmt.Fprintf(unit, "Description=%s\n", escapeSystemdSpecifiers("qemu-compose dnsmasq for network: "+networkName))
+	fmt.Fprintf(unit, "PartOf=%s\n", getProjectTargetName())
+	unit.WriteString("\n[Service]\n")
+	unit.WriteString("Type=simple\n")
+	unit.WriteString("KillMode=mixed\n")
+	fmt.Fprintf(unit, "WorkingDirectory=%s\n", escapeSystemdSpecifiers(projectDir))
+	fmt.Fprintf(unit, "ExecStartPre=%s\n", execStartPre)
+	fmt.Fprintf(unit, "ExecStart=%s\n", execStart)
+	unit.WriteString("Restart=on-failure\n")
+	unit.WriteString("RestartSec=5s\n")
+
+	return &systemdUnitFile{Name: getDnsmasqUnitName(networkName) + ".service", Content: unit.String()}, nil
+}
+
+// generatePortProxyUnit builds the port proxy service of a VM, bound to the VM service
+// Returns nil if the VM publishes no ports
+func generatePortProxyUnit(vmName string, vm VM, projectDir string) (*systemdUnitFile, error) {
+	mappings, err := parseVMPorts(vmName, vm)
+	if err != nil {
+		return nil, err
+	}
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+
+	targetIP := getReservedVMAddress(vm.Networks[0].Name, vmName)
+	if targetIP == "" {
+		return nil, fmt.Errorf("no address reserved for VM %s on network %s, run qemu-compose up first", vmName, vm.Networks[0].Name)
+	}
+
+	proxyArgs, err := getPortProxyCommand(targetIP, mappings)
+	if err != nil {
+		return nil, err
+	}
+	execStart, err := formatSystemdCommand(proxyArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	vmUnit := getVMUnitName(vmName) + ".service"
+
+	unit := newSystemdUnit(projectDir)
+	unit.WriteString("[Unit]\n")
+	fmt.Fprintf(unit, "Description=%s\n", escapeSystemdSpecifiers("qemu-compose port proxy for VM: "+vmName))
+	fmt.Fprintf(unit, "BindsTo=%s\n", vmUnit)
+	fmt.Fprintf(unit, "After=%s\n", vmUnit)
+	unit.WriteString("\n[Service]\n")
+	unit.WriteString("Type=simple\n")
+	unit.WriteString("KillMode=mixed\n")
+	fmt.Fprintf(unit, "WorkingDirectory=%s\n", escapeSystemdSpecifiers(projectDir))
+	fmt.Fprintf(unit, "ExecStart=%s\n", execStart)
+
+	return &systemdUnitFile{Name: getPortProxyUnitName(vmName) + ".service", Content: unit.String()}, nil
+}
+
+// generateConsoleLoggerUnit builds the console logger service of a VM, started with the VM service
+// The logger exits by itself once the VM service stops for good
+func generateConsoleLoggerUnit(vmName string, projectDir string) (*systemdUnitFile, error) {
+	loggerArgs, err := getConsoleLoggerCommand(vmName, -1)
+	if err != nil {
+		return nil, err
+	}
+	execStart, err := formatSystemdCommand(loggerArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := newSystemdUnit(projectDir)
+	unit.WriteString("[Unit]\n")
+	fmt.Fprintf(unit, "Description=%s\n", escapeSystemdSpecifiers("qemu-compose console logger for VM: "+vmName))
+	fmt.Fprintf(unit, "After=%s.service\n", getVMUnitName(vmName))
+	unit.WriteString("\n[Service]\n")
+	unit.WriteString("Type=simple\n")
+	fmt.Fprintf(unit, "WorkingDirectory=%s\n", escapeSystemdSpecifiers(projectDir))
+	fmt.Fprintf(unit, "ExecStart=%s\n", execStart)
+
+	return &systemdUnitFile{Name: getConsoleLoggerUnitName(vmName) + ".service", Content: unit.String()}, nil
+}
+
+// findProjectUnitFiles returns the names of the unit files generated for the project in a directory
+func findProjectUnitFiles(unitDir string) ([]string, error) {
+	projectDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(unitDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", unitDir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), "qemu-compose-") {
+			continue
+		}
+		if readSystemdProjectDir(filepath.Join(unitDir, entry.Name())) == projectDir {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// readSystemdProjectDir returns the project directory recorded in the header of a generated unit file
+func readSystemdProjectDir(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for i := 0; i < 2 && scanner.Scan(); i++ {
+		if strings.HasPrefix(scanner.Text(), systemdProjectHeader) {
+			return strings.TrimPrefix(scanner.Text(), systemdProjectHeader)
+		}
+	}
+	return ""
+}
+
+// writeSystemdUnits writes unit files to a directory
+// Files previously generated for the project and not part of units (e.g. removed VMs) are deleted
+func writeSystemdUnits(units []systemdUnitFile, unitDir string) error {
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", unitDir, err)
+	}
+
+	previous, err := findProjectUnitFiles(unitDir)
+	if err != nil {
+		return err
+	}
+
+	written := make(map[string]bool)
+	for _, unit := range units {
+		path := filepath.Join(unitDir, unit.Name)
+		logger.Printf("Writing unit file: %s", path)
+		if err := os.WriteFile(path, []byte(unit.Content), 0644); err != nil {
+			return fmt.Errorf("failed to write unit file %s: %w", path, err)
+		}
+		written[unit.Name] = true
+	}
+
+	for _, name := range previous {
+		if written[name] {
+			continue
+		}
+		logger.Printf("Removing stale unit file: %s", name)
+		if err := os.Remove(filepath.Join(unitDir, name)); err != nil {
+			return fmt.Errorf("failed to remove stale unit file %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// runUserSystemctl runs systemctl --user with the given arguments
+func runUserSystemctl(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl --user %s failed: %w\nOutput: %s", strings.Join(args, " "), err, string(output))
+	}
+	return nil
+}
+
+// uninstallSystemdUnits disables the project target and removes the unit files generated for the project
+// Returns the names of the removed files
+func uninstallSystemdUnits() ([]string, error) {
+	unitDir, err := getUserUnitDir()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := findProjectUnitFiles(unitDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	targetName := getProjectTargetName()
+	for _, name := range names {
+		if name == targetName {
+			if err := runUserSystemctl("disable", targetName); err != nil {
+				logger.Printf("Warning: failed to disable %s: %v", targetName, err)
+			}
+		}
+	}
+
+	for _, name := range names {
+		logger.Printf("Removing unit file: %s", name)
+		if err := os.Remove(filepath.Join(unitDir, name)); err != nil {
+			return nil, fmt.Errorf("failed to remove unit file %s: %w", name, err)
+		}
+	}
+
+	if err := runUserSystemctl("daemon-reload"); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// systemdPrepareVM sets up the networks of a VM before its installed unit starts QEMU
+func systemdPrepareVM(vmName string, config *ComposeConfig) error {
+	vm, exists := config.VMs[vmName]
+	if !exists {
+		return fmt.Errorf("VM not found in compose file: %s", vmName)
+	}
+	return setupVMNetworks(vmName, vm, config)
+}
+
+// systemdCleanupVM removes the networks of a VM after QEMU exited in its installed unit
+func systemdCleanupVM(vmName string, config *ComposeConfig) error {
+	vm, exists := config.VMs[vmName]
+	if !exists {
+		return fmt.Errorf("VM not found in compose file: %s", vmName)
+	}
+	return cleanupVMNetworks(vmName, vm)
+}
+
+// systemdPrepareNetwork sets up the bridge of a network before its installed unit starts dnsmasq
+func systemdPrepareNetwork(networkName string, config *ComposeConfig) error {
+	if err := createBridge(networkName, config, false); err != nil {
+		return err
+	}
+
+	if err := stopSystemDnsmasq(networkName); err != nil {
+		return err
+	}
+
+	metadata, err := loadNetworkMetadata()
+	if err != nil {
+		return err
+	}
+	if netMeta, exists := metadata[networkName]; exists {
+		netMeta.DnsmasqUnit = getDnsmasqUnitName(networkName)
+		netMeta.DnsmasqActive = true
+		metadata[networkName] = netMeta
+		if err := saveNetworkMetadata(metadata); err != nil {
+			logger.Printf("Warning: failed to save dnsmasq metadata: %v", err)
+		}
+	}
+	return nil
+}
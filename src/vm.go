@@ -2,11 +2,14 @@ package main
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -22,11 +25,186 @@ func getProjectName() string {
 
 // getVMUnitName returns the systemd unit name for a VM
 func getVMUnitName(vmName string) string {
-	projectName := getProjectName()
 	// Sanitize names for systemd (replace invalid characters)
-	sanitizedProject := strings.ReplaceAll(projectName, " ", "-")
 	sanitizedVM := strings.ReplaceAll(vmName, " ", "-")
-	return fmt.Sprintf("qemu-compose-%s-%s", sanitizedProject, sanitizedVM)
+	return fmt.Sprintf("qemu-compose-%s-%s", getProjectUnitID(), sanitizedVM)
+}
+
+// defaultStopGracePeriod is how long a graceful stop waits for the guest to shut down
+const defaultStopGracePeriod = 60 * time.Second
+
+// restartPolicies maps VM restart policies to the systemd Restart= setting of the VM unit
+// unless-stopped behaves like always for a running unit: systemctl stop never triggers a restart
+var restartPolicies = map[string]string{
+	"no":             "no",
+	"on-failure":     "on-failure",
+	"always":         "always",
+	"unless-stopped": "always",
+}
+
+// getRestartPolicy returns the restart policy of a VM, defaulting to "no"
+func getRestartPolicy(vm VM) (string, error) {
+	if vm.Restart == "" {
+		return "no", nil
+	}
+	if _, exists := restartPolicies[vm.Restart]; !exists {
+		return "", fmt.Errorf("invalid restart policy %q (expected no, on-failure, always or unless-stopped)", vm.Restart)
+	}
+	return vm.Restart, nil
+}
+
+// getRestartProperties returns the systemd properties implementing the restart policy of a VM
+// Restarts are spaced by 5 seconds and limited to 5 within 5 minutes, after which the unit fails
+func getRestartProperties(vm VM) ([]string, error) {
+	policy, err := getRestartPolicy(vm)
+	if err != nil {
+		return nil, err
+	}
+	if policy == "no" {
+		return nil, nil
+	}
+
+	return []string{
+		"Restart=" + restartPolicies[policy],
+		"RestartSec=5s",
+		"StartLimitIntervalSec=300",
+		"StartLimitBurst=5",
+	}, nil
+}
+
+// parseMemorySize parses a memory size in bytes, with an optional K, M, G or T suffix (powers of 1024)
+func parseMemorySize(value string) (int64, error) {
+	number := strings.TrimSpace(value)
+	multiplier := int64(1)
+	if number != "" {
+		switch strings.ToUpper(number[len(number)-1:]) {
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		case "T":
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			number = number[:len(number)-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 512M or 4G)", value)
+	}
+	return size * multiplier, nil
+}
+
+// getResourceProperties returns the systemd cgroup properties limiting the QEMU process of a VM
+func getResourceProperties(vm VM) ([]string, error) {
+	var properties []string
+
+	if vm.CPUQuota != "" {
+		percent, err := strconv.Atoi(strings.TrimSuffix(vm.CPUQuota, "%"))
+		if err != nil || !strings.HasSuffix(vm.CPUQuota, "%") || percent <= 0 {
+			return nil, fmt.Errorf("invalid cpu_quota %q (expected a percentage of one CPU, e.g. 150%%)", vm.CPUQuota)
+		}
+		properties = append(properties, fmt.Sprintf("CPUQuota=%d%%", percent))
+	}
+
+	if vm.CPUWeight != 0 {
+		if vm.CPUWeight < 1 || vm.CPUWeight > 10000 {
+			return nil, fmt.Errorf("invalid cpu_weight %d (expected 1 to 10000)", vm.CPUWeight)
+		}
+		properties = append(properties, fmt.Sprintf("CPUWeight=%d", vm.CPUWeight))
+	}
+
+	if vm.MemoryMax != "" {
+		memoryMax, err := parseMemorySize(vm.MemoryMax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory_max: %w", err)
+		}
+		// The cap includes QEMU's own overhead, below the guest RAM the kernel kills QEMU
+		if memoryMax <= int64(vm.Memory)<<20 {
+			return nil, fmt.Errorf("memory_max %s must be above memory (%d MB) to leave room for QEMU overhead", vm.MemoryMax, vm.Memory)
+		}
+		properties = append(properties, fmt.Sprintf("MemoryMax=%d", memoryMax))
+	}
+
+	if vm.IOWeight != 0 {
+		if vm.IOWeight < 1 || vm.IOWeight > 10000 {
+			return nil, fmt.Errorf("invalid io_weight %d (expected 1 to 10000)", vm.IOWeight)
+		}
+		properties = append(properties, fmt.Sprintf("IOWeight=%d", vm.IOWeight))
+	}
+
+	if vm.TasksMax != 0 {
+		if vm.TasksMax < 0 {
+			return nil, fmt.Errorf("invalid tasks_max %d (expected a positive number)", vm.TasksMax)
+		}
+		properties = append(properties, fmt.Sprintf("TasksMax=%d", vm.TasksMax))
+	}
+
+	return properties, nil
+}
+
+// VMResourceLimits are the cgroup limits systemd applies to the unit of a VM
+// Values are as reported by systemctl show, unset limits are "infinity" or "[not set]"
+type VMResourceLimits struct {
+	CPUQuota  string `json:"cpu_quota"`
+	CPUWeight string `json:"cpu_weight"`
+	MemoryMax string `json:"memory_max"`
+	IOWeight  string `json:"io_weight"`
+	TasksMax  string `json:"tasks_max"`
+}
+
+// getVMResourceLimits reads the effective cgroup limits of a VM unit back from systemd
+func getVMResourceLimits(vmName string) (*VMResourceLimits, error) {
+	cmd := exec.Command("systemctl", "--user", "show", getVMUnitName(vmName),
+		"--property=CPUQuotaPerSecUSec,CPUWeight,MemoryMax,IOWeight,TasksMax")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get resource limits: %w", err)
+	}
+
+	values := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if key, value, found := strings.Cut(line, "="); found {
+			values[key] = value
+		}
+	}
+
+	limits := &VMResourceLimits{
+		CPUQuota:  values["CPUQuotaPerSecUSec"],
+		CPUWeight: values["CPUWeight"],
+		MemoryMax: values["MemoryMax"],
+		IOWeight:  values["IOWeight"],
+		TasksMax:  values["TasksMax"],
+	}
+
+	// CPU time per second of wall clock time, e.g. 1.5s is 150% of one CPU
+	if quota, err := time.ParseDuration(limits.CPUQuota); err == nil {
+		limits.CPUQuota = fmt.Sprintf("%g%%", quota.Seconds()*100)
+	}
+	if memoryMax, err := strconv.ParseInt(limits.MemoryMax, 10, 64); err == nil {
+		limits.MemoryMax = formatBytes(memoryMax)
+	}
+
+	return limits, nil
+}
+
+// getVMRestartCount returns how many times systemd restarted the unit of a VM (NRestarts)
+func getVMRestartCount(vmName string) (int, error) {
+	cmd := exec.Command("systemctl", "--user", "show", getVMUnitName(vmName), "--property=NRestarts", "--value")
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get restart count: %w", err)
+	}
+
+	value := strings.TrimSpace(string(output))
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
 }
 
 // getConsoleSocketPath returns the path to the console Unix socket
@@ -39,6 +217,36 @@ func getConsoleSocketPath(vmName string) string {
 	return filepath.Join(instanceDir, "console.sock")
 }
 
+// getConsoleLogPath returns the path of the file QEMU writes the serial console output of a VM to
+func getConsoleLogPath(vmName string) string {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		// Fallback to /tmp if we can't get instance dir
+		return fmt.Sprintf("/tmp/qemu-compose-%s-console.log", vmName)
+	}
+	return filepath.Join(instanceDir, "console.log")
+}
+
+// getQMPSocketPath returns the path to the QMP (QEMU Machine Protocol) socket for a VM
+func getQMPSocketPath(vmName string) string {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		// Fallback to /tmp if we can't get instance dir
+		return fmt.Sprintf("/tmp/qemu-compose-%s-qmp.sock", vmName)
+	}
+	return filepath.Join(instanceDir, "qmp.sock")
+}
+
+// getQMPSessionSocketPath returns the path to the second QMP socket of a VM, for long-lived sessions
+func getQMPSessionSocketPath(vmName string) string {
+	instanceDir, err := getInstanceDir(vmName)
+	if err != nil {
+		// Fallback to /tmp if we can't get instance dir
+		return fmt.Sprintf("/tmp/qemu-compose-%s-qmp-session.sock", vmName)
+	}
+	return filepath.Join(instanceDir, "qmp-session.sock")
+}
+
 // isPortAvailable checks if a TCP port is available
 func isPortAvailable(port int) bool {
 	addr := fmt.Sprintf("127.0.0.1:%d", port)
@@ -105,9 +313,12 @@ func getAllocatedPorts() map[int]string {
 // allocateSSHPort allocates an SSH port for a VM
 func allocateSSHPort(vmName string, vm VM) (int, error) {
 	// Check if user specified a manual port
+	// A running VM holds its own port, e.g. while "systemd generate" builds its QEMU command
+	running, _ := isVMRunning(vmName)
+
 	if vm.SSH != nil && vm.SSH.Port > 0 {
 		logger.Printf("Using manual SSH port: %d", vm.SSH.Port)
-		if !isPortAvailable(vm.SSH.Port) {
+		if !running && !isPortAvailable(vm.SSH.Port) {
 			return 0, fmt.Errorf("specified SSH port %d is already in use", vm.SSH.Port)
 		}
 		return vm.SSH.Port, nil
@@ -121,7 +332,7 @@ func allocateSSHPort(vmName string, vm VM) (int, error) {
 
 	if portMetadata != nil && portMetadata.SSH > 0 {
 		// Verify the port is still available
-		if isPortAvailable(portMetadata.SSH) {
+		if running || isPortAvailable(portMetadata.SSH) {
 			logger.Printf("Reusing existing SSH port: %d", portMetadata.SSH)
 			return portMetadata.SSH, nil
 		}
@@ -173,6 +384,40 @@ func getSSHPort(vmName string) (int, error) {
 	return portMetadata.SSH, nil
 }
 
+// usesManagementNIC returns true if a VM gets the extra user-mode NIC used for SSH access
+// VMs without networks always use it, bridge VMs can opt out with ssh.via: bridge or management_nic: false
+func usesManagementNIC(vm VM) bool {
+	if len(vm.Networks) == 0 {
+		return true
+	}
+	if vm.SSH != nil && vm.SSH.Via == "bridge" {
+		return false
+	}
+	if vm.ManagementNIC != nil {
+		return *vm.ManagementNIC
+	}
+	return true
+}
+
+// getSSHEndpoint returns the host and port to reach a VM over SSH
+// VMs with the management NIC are reached on localhost through the forwarded port,
+// others on port 22 of their address on the first network
+func getSSHEndpoint(vmName string, vm VM, config *ComposeConfig) (string, int, error) {
+	if usesManagementNIC(vm) {
+		sshPort, err := getSSHPort(vmName)
+		if err != nil {
+			return "", 0, err
+		}
+		return "localhost", sshPort, nil
+	}
+
+	ip := getVMIPAddress(vmName, vm, config)
+	if ip == "" {
+		return "", 0, fmt.Errorf("IP address of VM %s on network %s is not known yet", vmName, vm.Networks[0].Name)
+	}
+	return ip, 22, nil
+}
+
 // generateMACAddress generates a unique MAC address for a VM network interface
 func generateMACAddress(vmName string, networkIndex int) string {
 	// Use a hash of the project name, VM name, and network index
@@ -187,6 +432,175 @@ func generateMACAddress(vmName string, networkIndex int) string {
 	return fmt.Sprintf("52:54:00:%02x:%02x:%02x", hash[0], hash[1], hash[2])
 }
 
+// getVMMACAddress returns the MAC address of a VM network interface
+// The mac_address of the network entry wins over the generated address
+func getVMMACAddress(vmName string, vm VM, networkIndex int) string {
+	if networkIndex < len(vm.Networks) && vm.Networks[networkIndex].MACAddress != "" {
+		return strings.ToLower(vm.Networks[networkIndex].MACAddress)
+	}
+	return generateMACAddress(vmName, networkIndex)
+}
+
+// nicModels maps the model of a VM network entry to its QEMU device
+var nicModels = map[string]string{
+	"virtio":  "virtio-net-pci",
+	"e1000e":  "e1000e",
+	"rtl8139": "rtl8139",
+}
+
+// getNICModel returns the model of a VM network interface (default: virtio)
+func getNICModel(vmNetwork VMNetwork) string {
+	if vmNetwork.Model == "" {
+		return "virtio"
+	}
+	return vmNetwork.Model
+}
+
+// getNICQueues returns the number of queue pairs of a VM network interface (default: 1)
+func getNICQueues(vmNetwork VMNetwork) int {
+	if vmNetwork.Queues < 1 {
+		return 1
+	}
+	return vmNetwork.Queues
+}
+
+// validateVMNetworks checks the per-interface options of a VM's networks
+func validateVMNetworks(vmName string, vm VM, config *ComposeConfig) error {
+	seenMACs := make(map[string]string)
+
+	for i, vmNetwork := range vm.Networks {
+		network, exists := config.Networks[vmNetwork.Name]
+		if !exists {
+			return fmt.Errorf("VM %s: network not found in config: %s", vmName, vmNetwork.Name)
+		}
+
+		if _, ok := nicModels[getNICModel(vmNetwork)]; !ok {
+			return fmt.Errorf("VM %s: unsupported model %q on network %s (expected virtio, e1000e or rtl8139)", vmName, vmNetwork.Model, vmNetwork.Name)
+		}
+
+		if vmNetwork.MACAddress != "" {
+			mac, err := net.ParseMAC(vmNetwork.MACAddress)
+			if err != nil || len(mac) != 6 {
+				return fmt.Errorf("VM %s: invalid mac_address %q on network %s", vmName, vmNetwork.MACAddress, vmNetwork.Name)
+			}
+			if mac[0]&1 != 0 {
+				return fmt.Errorf("VM %s: mac_address %s on network %s is a multicast address", vmName, vmNetwork.MACAddress, vmNetwork.Name)
+			}
+		}
+
+		macAddr := getVMMACAddress(vmName, vm, i)
+		if other, exists := seenMACs[macAddr]; exists {
+			return fmt.Errorf("VM %s: MAC address %s is used on networks %s and %s", vmName, macAddr, other, vmNetwork.Name)
+		}
+		seenMACs[macAddr] = vmNetwork.Name
+
+		if vmNetwork.MTU != 0 && (vmNetwork.MTU < 68 || vmNetwork.MTU > 65535) {
+			return fmt.Errorf("VM %s: invalid mtu %d on network %s (expected 68-65535)", vmName, vmNetwork.MTU, vmNetwork.Name)
+		}
+
+		if len(vmNetwork.Aliases) > 0 && (isExternalNetwork(network) || isMacvtapNetwork(network)) {
+			return fmt.Errorf("VM %s: aliases are not supported on network %s (no qemu-compose DNS on external and macvtap networks)", vmName, vmNetwork.Name)
+		}
+		for _, alias := range vmNetwork.Aliases {
+			if !isValidDNSName(alias) {
+				return fmt.Errorf("VM %s: invalid alias %q on network %s", vmName, alias, vmNetwork.Name)
+			}
+			if owner := findAliasOwner(config, vmNetwork.Name, alias, vmName); owner != "" {
+				return fmt.Errorf("VM %s: alias %s on network %s is already used by %s", vmName, alias, vmNetwork.Name, owner)
+			}
+		}
+
+		if vmNetwork.Queues < 0 || vmNetwork.Queues > 16 {
+			return fmt.Errorf("VM %s: invalid queues %d on network %s (expected 1-16)", vmName, vmNetwork.Queues, vmNetwork.Name)
+		}
+		if getNICQueues(vmNetwork) > 1 {
+			if getNICModel(vmNetwork) != "virtio" {
+				return fmt.Errorf("VM %s: queues on network %s requires the virtio model", vmName, vmNetwork.Name)
+			}
+			if isRootlessNetwork(network) {
+				return fmt.Errorf("VM %s: queues is not supported on rootless network %s", vmName, vmNetwork.Name)
+			}
+		}
+	}
+
+	if vm.SSH != nil && vm.SSH.Via == "user" && vm.ManagementNIC != nil && !*vm.ManagementNIC {
+		return fmt.Errorf("VM %s: ssh.via: user needs the management NIC, which management_nic: false removes", vmName)
+	}
+
+	// Without the management NIC, SSH goes to the reserved address of the VM on its first network:
+	// the host has none on rootless switches, cannot reach its own macvtap children and knows no
+	// lease on external bridges
+	if !usesManagementNIC(vm) {
+		firstNetwork := config.Networks[vm.Networks[0].Name]
+		if getNetworkDriver(firstNetwork) != "bridge" || isExternalNetwork(firstNetwork) {
+			return fmt.Errorf("VM %s: ssh.via: bridge and management_nic: false need a bridge network managed by qemu-compose as first network (%s is not)", vmName, vm.Networks[0].Name)
+		}
+	}
+
+	return nil
+}
+
+// isValidDNSName checks that a name only contains letters, digits, hyphens and dots
+func isValidDNSName(name string) bool {
+	if name == "" || len(name) > 253 || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// findAliasOwner returns the other VM using a name on a network (as VM name or alias), if any
+func findAliasOwner(config *ComposeConfig, networkName, alias, vmName string) string {
+	for otherName, other := range config.VMs {
+		if otherName == vmName {
+			continue
+		}
+		for _, otherNetwork := range other.Networks {
+			if otherNetwork.Name != networkName {
+				continue
+			}
+			if strings.EqualFold(otherName, alias) {
+				return otherName
+			}
+			for _, otherAlias := range otherNetwork.Aliases {
+				if strings.EqualFold(otherAlias, alias) {
+					return otherName
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// getNICDeviceOption returns the -device value of a VM network interface
+// For example: "virtio-net-pci,netdev=net0,mac=52:54:00:12:34:56,host_mtu=9000,mq=on,vectors=10"
+func getNICDeviceOption(vmNetwork VMNetwork, netdevID string, macAddr string) string {
+	model := getNICModel(vmNetwork)
+	option := fmt.Sprintf("%s,netdev=%s,mac=%s", nicModels[model], netdevID, macAddr)
+
+	if model == "virtio" {
+		// host_mtu advertises the MTU to the guest driver
+		if vmNetwork.MTU != 0 {
+			option += fmt.Sprintf(",host_mtu=%d", vmNetwork.MTU)
+		}
+		// One MSI-X vector per queue (rx and tx) plus config and control
+		if queues := getNICQueues(vmNetwork); queues > 1 {
+			option += fmt.Sprintf(",mq=on,vectors=%d", 2*queues+2)
+		}
+	}
+
+	return option
+}
+
 // VMVolumeMount represents a volume mount for a VM
 type VMVolumeMount struct {
 	VolumeName   string
@@ -265,9 +679,10 @@ func parseVMVolumes(vmName string, vm VM, config *ComposeConfig, composeFilePath
 }
 
 // buildQEMUCommand builds the QEMU command line arguments
-func buildQEMUCommand(vmName string, vm VM, instanceDiskPath string, cloudInitISOPath string, sshPort int, volumeMounts []VMVolumeMount) []string {
-	// Get console socket path
-	socketPath := getConsoleSocketPath(vmName)
+// hostForwards are published ports forwarded by the user-mode NIC (VMs without a managed bridge)
+func buildQEMUCommand(vmName string, vm VM, config *ComposeConfig, instanceDiskPath string, cloudInitISOPath string, sshPort int, volumeMounts []VMVolumeMount, hostForwards []PortMapping) []string {
+	// The serial socket is served to console clients by the console multiplexer
+	socketPath := getSerialSocketPath(vmName)
 
 	args := []string{
 		"qemu-system-x86_64",
@@ -278,10 +693,19 @@ func buildQEMUCommand(vmName string, vm VM, instanceDiskPath string, cloudInitIS
 		"-smp", fmt.Sprintf("%d", vm.CPU),
 		"-drive", fmt.Sprintf("file=%s,format=qcow2,if=virtio", instanceDiskPath),
 		"-nographic",
-		"-serial", fmt.Sprintf("unix:%s,server,nowait", socketPath),
+		"-chardev", fmt.Sprintf("socket,id=serial0,path=%s,server,nowait,logfile=%s,logappend=on", socketPath, getConsoleLogPath(vmName)),
+		"-serial", "chardev:serial0",
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", getQMPSocketPath(vmName)),
+		"-qmp", fmt.Sprintf("unix:%s,server,nowait", getQMPSessionSocketPath(vmName)),
 		"-device", "virtio-balloon",
 	}
 
+	// With a restart policy, a guest kernel panic (reported through pvpanic) makes QEMU exit with an
+	// error so that systemd restarts the VM instead of leaving a dead guest running
+	if policy, err := getRestartPolicy(vm); err == nil && policy != "no" {
+		args = append(args, "-device", "pvpanic", "-action", "panic=exit-failure")
+	}
+
 	// Add volume disks and bind mounts
 	virtfsIndex := 0
 	for _, mount := range volumeMounts {
@@ -299,16 +723,69 @@ func buildQEMUCommand(vmName string, vm VM, instanceDiskPath string, cloudInitIS
 		}
 	}
 
+	// Character devices QEMU opens as file descriptors (macvtap)
+	var fdRedirects []string
+
 	// Add network configuration
 	if len(vm.Networks) > 0 {
 		// Use TAP/bridge networking for VM-to-VM communication
 		logger.Printf("Configuring TAP/bridge networking for VM: %s", vmName)
-		for i, networkName := range vm.Networks {
+		for i, vmNetwork := range vm.Networks {
+			networkName := vmNetwork.Name
+			netdevID := fmt.Sprintf("net%d", i)
+			macAddr := getVMMACAddress(vmName, vm, i)
+			queues := getNICQueues(vmNetwork)
+
+			// Rootless networks connect to the userspace switch over a Unix socket
+			if isRootlessNetwork(config.Networks[networkName]) {
+				switchSocket, err := getSwitchSocketPath(networkName)
+				if err != nil {
+					logger.Printf("Warning: could not get switch socket for network %s: %v", networkName, err)
+					continue
+				}
+				args = append(args,
+					"-netdev", fmt.Sprintf("stream,id=%s,server=off,addr.type=unix,addr.path=%s", netdevID, switchSocket),
+					"-device", getNICDeviceOption(vmNetwork, netdevID, macAddr),
+				)
+				logger.Printf("Added switch network interface: %s (network: %s, MAC: %s)", switchSocket, networkName, macAddr)
+				continue
+			}
+
 			tapName := getTAPName(vmName, i)
-			macAddr := generateMACAddress(vmName, i)
+
+			// Macvtap devices are opened through their character device and passed as file descriptors,
+			// one per queue
+			if isMacvtapNetwork(config.Networks[networkName]) {
+				devicePath, err := getMacvtapDevicePath(tapName)
+				if err != nil {
+					logger.Printf("Warning: could not get macvtap device for network %s: %v", networkName, err)
+					continue
+				}
+				var fds []string
+				for q := 0; q < queues; q++ {
+					fd := 3 + len(fdRedirects)
+					fdRedirects = append(fdRedirects, fmt.Sprintf("%d<>%s", fd, devicePath))
+					fds = append(fds, strconv.Itoa(fd))
+				}
+				netdev := fmt.Sprintf("tap,id=%s,fd=%s", netdevID, fds[0])
+				if queues > 1 {
+					netdev = fmt.Sprintf("tap,id=%s,fds=%s", netdevID, strings.Join(fds, ":"))
+				}
+				args = append(args,
+					"-netdev", netdev,
+					"-device", getNICDeviceOption(vmNetwork, netdevID, macAddr),
+				)
+				logger.Printf("Added macvtap network interface: %s (network: %s, MAC: %s)", devicePath, networkName, macAddr)
+				continue
+			}
+
+			netdev := fmt.Sprintf("tap,id=%s,ifname=%s,script=no,downscript=no", netdevID, tapName)
+			if queues > 1 {
+				netdev += fmt.Sprintf(",queues=%d", queues)
+			}
 			args = append(args,
-				"-netdev", fmt.Sprintf("tap,id=net%d,ifname=%s,script=no,downscript=no", i, tapName),
-				"-device", fmt.Sprintf("virtio-net-pci,netdev=net%d,mac=%s", i, macAddr),
+				"-netdev", netdev,
+				"-device", getNICDeviceOption(vmNetwork, netdevID, macAddr),
 			)
 			logger.Printf("Added TAP network interface: %s (network: %s, MAC: %s)", tapName, networkName, macAddr)
 		}
@@ -318,7 +795,7 @@ func buildQEMUCommand(vmName string, vm VM, instanceDiskPath string, cloudInitIS
 			netIndex := len(vm.Networks) // Use next available network index
 			macAddr := generateMACAddress(vmName, netIndex)
 			args = append(args,
-				"-netdev", fmt.Sprintf("user,id=net%d,hostfwd=tcp:127.0.0.1:%d-:22", netIndex, sshPort),
+				"-netdev", fmt.Sprintf("user,id=net%d,hostfwd=tcp:127.0.0.1:%d-:22%s", netIndex, sshPort, getHostForwardOptions(hostForwards)),
 				"-device", fmt.Sprintf("virtio-net-pci,netdev=net%d,mac=%s", netIndex, macAddr),
 			)
 			logger.Printf("Added user-mode network for SSH access: port %d (MAC: %s)", sshPort, macAddr)
@@ -329,7 +806,7 @@ func buildQEMUCommand(vmName string, vm VM, instanceDiskPath string, cloudInitIS
 		if sshPort > 0 {
 			macAddr := generateMACAddress(vmName, 0)
 			args = append(args,
-				"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp:127.0.0.1:%d-:22", sshPort),
+				"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp:127.0.0.1:%d-:22%s", sshPort, getHostForwardOptions(hostForwards)),
 				"-device", fmt.Sprintf("virtio-net-pci,netdev=net0,mac=%s", macAddr),
 			)
 			logger.Printf("Added user-mode network with SSH port forwarding: %d (MAC: %s)", sshPort, macAddr)
@@ -341,58 +818,189 @@ func buildQEMUCommand(vmName string, vm VM, instanceDiskPath string, cloudInitIS
 		args = append(args, "-drive", fmt.Sprintf("file=%s,format=raw,if=virtio,media=cdrom", cloudInitISOPath))
 	}
 
+	// Open macvtap character devices in a shell wrapper so QEMU inherits them
+	if len(fdRedirects) > 0 {
+		script := fmt.Sprintf(`exec "$0" "$@" %s`, strings.Join(fdRedirects, " "))
+		args = append([]string{"sh", "-c", script}, args...)
+	}
+
 	return args
 }
 
-// startVM starts a VM using systemd-run
-func startVM(vmName string, vm VM, instanceDiskPath string, config *ComposeConfig, composeFilePath string) error {
-	logger.Printf("Starting VM: %s", vmName)
+// vmLaunch is everything needed to run the QEMU process of a VM
+type vmLaunch struct {
+	Command            []string // QEMU command wrapped in the console multiplexer, main process of the unit
+	QEMUArgs           []string
+	RestartProperties  []string      // systemd properties of the restart policy
+	ResourceProperties []string      // systemd cgroup properties of the resource limits
+	PortMappings       []PortMapping // Published ports
+}
 
-	// Setup networks if configured
-	if len(vm.Networks) > 0 {
-		logger.Printf("VM %s uses bridge networking, setting up network infrastructure", vmName)
-		if err := setupVMNetworks(vmName, vm, config); err != nil {
-			return fmt.Errorf("failed to setup networks: %w", err)
-		}
+// validateVMLaunch checks the settings of a VM that do not depend on host state: SSH, stop grace
+// period, restart policy, resource limits, network interfaces and published ports
+// It runs before networks are set up, so that an invalid VM leaves nothing behind on the host
+// Returns the published ports
+func validateVMLaunch(vmName string, vm VM, config *ComposeConfig) ([]PortMapping, error) {
+	if vm.SSH != nil && vm.SSH.Via != "" && vm.SSH.Via != "user" && vm.SSH.Via != "bridge" {
+		return nil, fmt.Errorf("invalid ssh.via for VM %s: %s (expected \"user\" or \"bridge\")", vmName, vm.SSH.Via)
+	}
+
+	if _, err := getStopGracePeriod(vm); err != nil {
+		return nil, fmt.Errorf("VM %s: %w", vmName, err)
+	}
+
+	if _, err := getRestartProperties(vm); err != nil {
+		return nil, fmt.Errorf("VM %s: %w", vmName, err)
+	}
+
+	if _, err := getResourceProperties(vm); err != nil {
+		return nil, fmt.Errorf("VM %s: %w", vmName, err)
+	}
+
+	if err := validateVMNetworks(vmName, vm, config); err != nil {
+		return nil, err
+	}
+
+	portMappings, err := parseVMPorts(vmName, vm)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(portMappings) > 0 && !usesPortProxy(vm, config) && !usesManagementNIC(vm) {
+		return nil, fmt.Errorf("VM %s publishes ports without a management NIC: its first network must be a bridge managed by qemu-compose", vmName)
 	}
 
+	return portMappings, nil
+}
+
+// prepareVMLaunch validates a VM and prepares its QEMU command: volumes, SSH port, published ports
+// and cloud-init ISO. Networks must be set up first, the QEMU command refers to their devices
+func prepareVMLaunch(vmName string, vm VM, instanceDiskPath string, config *ComposeConfig, composeFilePath string) (*vmLaunch, error) {
 	// Parse and setup volumes
 	volumeMounts, err := parseVMVolumes(vmName, vm, config, composeFilePath)
 	if err != nil {
-		return fmt.Errorf("failed to parse volumes: %w", err)
+		return nil, fmt.Errorf("failed to parse volumes: %w", err)
 	}
 
-	// Allocate SSH port for all VMs (needed for SSH access)
-	sshPort, err := allocateSSHPort(vmName, vm)
+	portMappings, err := validateVMLaunch(vmName, vm, config)
 	if err != nil {
-		return fmt.Errorf("failed to allocate SSH port: %w", err)
+		return nil, err
 	}
 
-	// Generate MAC addresses for all network interfaces
-	var macAddresses []string
+	restartProperties, err := getRestartProperties(vm)
+	if err != nil {
+		return nil, fmt.Errorf("VM %s: %w", vmName, err)
+	}
 
-	// Add MAC addresses for bridge networks
-	for i := range vm.Networks {
-		macAddr := generateMACAddress(vmName, i)
-		macAddresses = append(macAddresses, macAddr)
+	resourceProperties, err := getResourceProperties(vm)
+	if err != nil {
+		return nil, fmt.Errorf("VM %s: %w", vmName, err)
+	}
+
+	// Allocate SSH port for VMs reached through the user-mode NIC
+	sshPort := 0
+	if usesManagementNIC(vm) {
+		sshPort, err = allocateSSHPort(vmName, vm)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate SSH port: %w", err)
+		}
+	} else {
+		logger.Printf("VM %s has no management NIC, SSH goes through network %s", vmName, vm.Networks[0].Name)
 	}
 
-	// Add MAC address for user-mode networking (SSH access)
+	// Published ports: a proxy for VMs on a managed bridge, hostfwd on the user-mode NIC otherwise
+	var hostForwards []PortMapping
+	if !usesPortProxy(vm, config) {
+		hostForwards = portMappings
+	}
+
+	// Describe all network interfaces (MAC-based matching in cloud-init)
+	var interfaces []CloudInitInterface
+
+	// Add interfaces for bridge networks
+	for i, vmNetwork := range vm.Networks {
+		network := config.Networks[vmNetwork.Name]
+		interfaces = append(interfaces, CloudInitInterface{
+			MAC:   getVMMACAddress(vmName, vm, i),
+			MTU:   vmNetwork.MTU,
+			DHCP6: isIPv6Enabled(network) && !isRootlessNetwork(network),
+		})
+	}
+
+	// Add interface for user-mode networking (SSH access)
 	if sshPort > 0 {
 		netIndex := len(vm.Networks)
-		macAddr := generateMACAddress(vmName, netIndex)
-		macAddresses = append(macAddresses, macAddr)
+		interfaces = append(interfaces, CloudInitInterface{
+			MAC: generateMACAddress(vmName, netIndex),
+		})
 	}
 
 	// Generate cloud-init ISO with MAC-based network configuration and volume mounts
-	cloudInitISOPath, err := generateCloudInitISOWithVolumes(vmName, vm.Image, macAddresses, volumeMounts)
+	cloudInitISOPath, err := generateCloudInitISOWithVolumes(vmName, vm.Image, interfaces, volumeMounts)
 	if err != nil {
 		logger.Printf("Warning: failed to generate cloud-init ISO: %v", err)
 		cloudInitISOPath = "" // Continue without cloud-init
 	}
 
+	qemuArgs := buildQEMUCommand(vmName, vm, config, instanceDiskPath, cloudInitISOPath, sshPort, volumeMounts, hostForwards)
+	command, err := getConsoleMuxCommand(vmName, qemuArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vmLaunch{
+		Command:            command,
+		QEMUArgs:           qemuArgs,
+		RestartProperties:  restartProperties,
+		ResourceProperties: resourceProperties,
+		PortMappings:       portMappings,
+	}, nil
+}
+
+// startVM starts a VM using systemd-run, or its unit if one was installed by "systemd generate"
+func startVM(vmName string, vm VM, instanceDiskPath string, config *ComposeConfig, composeFilePath string) error {
+	logger.Printf("Starting VM: %s", vmName)
+
 	unitName := getVMUnitName(vmName)
-	qemuArgs := buildQEMUCommand(vmName, vm, instanceDiskPath, cloudInitISOPath, sshPort, volumeMounts)
+
+	// Installed units set up networks, the port proxy and QEMU themselves
+	if isUserUnitInstalled(unitName) {
+		logger.Printf("VM %s has an installed unit, starting it", vmName)
+		cmd := exec.Command("systemctl", "--user", "start", unitName)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to start VM: %w\nOutput: %s", err, string(output))
+		}
+		return nil
+	}
+
+	// Validate before touching the host: TAP devices, addresses and netem are not rolled back
+	portMappings, err := validateVMLaunch(vmName, vm, config)
+	if err != nil {
+		return err
+	}
+
+	// A proxy left by a previous run holds the published ports
+	if usesPortProxy(vm, config) && isPortProxyRunning(vmName) {
+		if err := stopPortProxy(vmName); err != nil {
+			logger.Printf("Warning: failed to stop stale port proxy: %v", err)
+		}
+	}
+	if err := checkPublishedPorts(vmName, portMappings); err != nil {
+		return err
+	}
+
+	// Setup networks if configured
+	if len(vm.Networks) > 0 {
+		logger.Printf("VM %s uses project networks, setting up network infrastructure", vmName)
+		if err := setupVMNetworks(vmName, vm, config); err != nil {
+			return fmt.Errorf("failed to setup networks: %w", err)
+		}
+	}
+
+	launch, err := prepareVMLaunch(vmName, vm, instanceDiskPath, config, composeFilePath)
+	if err != nil {
+		return err
+	}
 
 	// Build systemd-run command
 	systemdArgs := []string{
@@ -404,9 +1012,18 @@ func startVM(vmName string, vm VM, instanceDiskPath string, config *ComposeConfi
 		"--property=KillMode=mixed",
 		"--property=Type=simple",
 	}
+	for _, property := range launch.RestartProperties {
+		systemdArgs = append(systemdArgs, "--property="+property)
+	}
+	for _, property := range launch.ResourceProperties {
+		systemdArgs = append(systemdArgs, "--property="+property)
+	}
 
 	// Append QEMU command
-	systemdArgs = append(systemdArgs, qemuArgs...)
+	systemdArgs = append(systemdArgs, launch.Command...)
+
+	// The console logger follows what this run appends to the console log
+	consoleLogOffset := getConsoleLogSize(vmName)
 
 	logger.Printf("Executing: %s", strings.Join(systemdArgs, " "))
 
@@ -417,6 +1034,23 @@ func startVM(vmName string, vm VM, instanceDiskPath string, config *ComposeConfi
 	}
 
 	logger.Printf("VM started successfully: %s (unit: %s)", vmName, unitName)
+
+	// Console output goes to the journal for "logs"
+	if err := startConsoleLogger(vmName, consoleLogOffset); err != nil {
+		logger.Printf("Warning: failed to start console logger: %v", err)
+	}
+
+	// Forward published ports to the VM reserved address
+	if usesPortProxy(vm, config) {
+		targetIP := getReservedVMAddress(vm.Networks[0].Name, vmName)
+		if targetIP == "" {
+			return fmt.Errorf("no address reserved for VM %s on network %s", vmName, vm.Networks[0].Name)
+		}
+		if err := startPortProxy(vmName, targetIP, launch.PortMappings); err != nil {
+			return fmt.Errorf("failed to publish ports: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -436,14 +1070,21 @@ func isVMRunning(vmName string) (bool, error) {
 	return status == "active", nil
 }
 
-// stopVMGraceful attempts to gracefully shutdown a VM via SSH
-func stopVMGraceful(vmName string, vm VM) error {
-	logger.Printf("Attempting graceful shutdown of VM: %s", vmName)
+// isVMRestartPending returns true if QEMU exited and systemd is about to start it again (restart policy)
+func isVMRestartPending(vmName string) bool {
+	cmd := exec.Command("systemctl", "--user", "show", getVMUnitName(vmName), "--property=ActiveState", "--value")
+	output, err := cmd.Output()
+	return err == nil && strings.TrimSpace(string(output)) == "activating"
+}
 
-	// Get SSH port
-	sshPort, err := getSSHPort(vmName)
+// stopVMSSH shuts down a VM by running "sudo systemctl poweroff" in the guest over SSH
+func stopVMSSH(vmName string, vm VM, config *ComposeConfig, gracePeriod time.Duration) error {
+	logger.Printf("Attempting shutdown of VM %s via SSH", vmName)
+
+	// Get SSH endpoint
+	sshHost, sshPort, err := getSSHEndpoint(vmName, vm, config)
 	if err != nil {
-		return fmt.Errorf("failed to get SSH port: %w", err)
+		return fmt.Errorf("failed to get SSH endpoint: %w", err)
 	}
 
 	// Get SSH key path
@@ -462,7 +1103,7 @@ func stopVMGraceful(vmName string, vm VM) error {
 	// Detect default user for the OS
 	defaultUser := getDefaultUserForOS(detectOSFromImage(vm.Image))
 
-	logger.Printf("Sending shutdown command via SSH (port: %d, user: %s)", sshPort, defaultUser)
+	logger.Printf("Sending shutdown command via SSH (host: %s, port: %d, user: %s)", sshHost, sshPort, defaultUser)
 
 	// Execute shutdown command via SSH
 	sshArgs := []string{
@@ -472,7 +1113,7 @@ func stopVMGraceful(vmName string, vm VM) error {
 		"-o", "BatchMode=yes",
 		"-o", "StrictHostKeyChecking=no",
 		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@localhost", defaultUser),
+		fmt.Sprintf("%s@%s", defaultUser, sshHost),
 		"sudo", "systemctl", "poweroff",
 	}
 
@@ -485,15 +1126,89 @@ func stopVMGraceful(vmName string, vm VM) error {
 
 	logger.Printf("Shutdown command sent successfully, waiting for VM to stop...")
 
-	// Wait for VM to stop (up to 60 seconds)
+	return waitForVMStopped(vmName, gracePeriod)
+}
+
+// stopVMPowerdown shuts down a VM by pressing its ACPI power button over QMP (system_powerdown)
+// and waiting for the SHUTDOWN event, which QEMU sends when the guest powers off
+// The event is awaited on the session socket, so that qmp.sock stays available to other commands
+func stopVMPowerdown(vmName string, gracePeriod time.Duration) error {
+	logger.Printf("Attempting ACPI shutdown of VM %s via QMP", vmName)
+
+	deadline := time.Now().Add(gracePeriod)
+
+	// Connected before pressing the button, so that the event cannot be missed
+	session, err := connectQMPSession(vmName)
+	if err != nil {
+		logger.Printf("Warning: %v, waiting for the VM unit to stop instead", err)
+	} else {
+		defer session.Close()
+	}
+
+	if err := pressPowerButton(vmName); err != nil {
+		return err
+	}
+
+	logger.Printf("Power button pressed, waiting up to %s for the guest to shut down...", gracePeriod)
+
+	if session == nil {
+		if err := waitForVMStopped(vmName, gracePeriod); err != nil {
+			return fmt.Errorf("guest did not shut down within %s", gracePeriod)
+		}
+		return nil
+	}
+
+	// QEMU exits right after the guest powers off: a closed connection means the same as SHUTDOWN
+	event, err := session.WaitForEvent(deadline, "SHUTDOWN")
+	if err != nil && !errors.Is(err, io.EOF) {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("guest did not shut down within %s", gracePeriod)
+		}
+		return fmt.Errorf("failed to wait for shutdown: %w", err)
+	}
+	if event != nil {
+		logger.Printf("Guest shut down: %s", string(event.Data))
+	}
+
+	// Give QEMU a moment to exit even if the grace period is almost over
+	remaining := time.Until(deadline)
+	if remaining < 10*time.Second {
+		remaining = 10 * time.Second
+	}
+	return waitForVMStopped(vmName, remaining)
+}
+
+// pressPowerButton presses the ACPI power button of a VM over QMP, resuming it first if paused
+// The QMP socket is released right away: status queries, pause and capture use it while the
+// guest shuts down
+func pressPowerButton(vmName string) error {
+	client, err := connectQMP(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// A paused guest cannot handle the power button
+	if status, err := client.QueryStatus(); err == nil && status.Status == "paused" {
+		logger.Printf("VM %s is paused, resuming it before shutdown", vmName)
+		if err := client.Resume(); err != nil {
+			return err
+		}
+	}
+
+	return client.SystemPowerdown()
+}
+
+// waitForVMStopped waits until the systemd unit of a VM is no longer active
+func waitForVMStopped(vmName string, timeout time.Duration) error {
 	unitName := getVMUnitName(vmName)
-	timeout := time.After(60 * time.Second)
+	deadline := time.After(timeout)
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-timeout:
+		case <-deadline:
 			return fmt.Errorf("timeout waiting for VM to stop gracefully")
 
 		case <-ticker.C:
@@ -517,6 +1232,39 @@ func stopVMGraceful(vmName string, vm VM) error {
 	}
 }
 
+// getStopGracePeriod returns how long a graceful stop may take before falling back
+func getStopGracePeriod(vm VM) (time.Duration, error) {
+	if vm.StopGracePeriod == "" {
+		return defaultStopGracePeriod, nil
+	}
+
+	gracePeriod, err := time.ParseDuration(vm.StopGracePeriod)
+	if err != nil || gracePeriod <= 0 {
+		return 0, fmt.Errorf("invalid stop_grace_period %q (expected a duration like 30s or 2m)", vm.StopGracePeriod)
+	}
+	return gracePeriod, nil
+}
+
+// stopVMGraceful gracefully shuts down a VM: ACPI power button over QMP first, then SSH for guests
+// that ignore it (or VMs started without a QMP socket), each within the stop grace period
+func stopVMGraceful(vmName string, vm VM, config *ComposeConfig) error {
+	logger.Printf("Attempting graceful shutdown of VM: %s", vmName)
+
+	gracePeriod, err := getStopGracePeriod(vm)
+	if err != nil {
+		logger.Printf("Warning: %v, using %s", err, defaultStopGracePeriod)
+		gracePeriod = defaultStopGracePeriod
+	}
+
+	err = stopVMPowerdown(vmName, gracePeriod)
+	if err == nil {
+		return nil
+	}
+	logger.Printf("ACPI shutdown failed: %v, falling back to SSH", err)
+
+	return stopVMSSH(vmName, vm, config, gracePeriod)
+}
+
 // stopVMForced forcefully stops a VM by sending SIGTERM to the QEMU process
 func stopVMForced(vmName string) error {
 	logger.Printf("Forcefully stopping VM: %s", vmName)
@@ -534,9 +1282,9 @@ func stopVMForced(vmName string) error {
 }
 
 // stopVM stops a running VM
-// If force is false, attempts graceful shutdown via SSH first, then falls back to forced stop
+// If force is false, attempts graceful shutdown (QMP, then SSH) first, then falls back to forced stop
 // If force is true, immediately sends SIGTERM to the QEMU process
-func stopVM(vmName string, vm VM, force bool) error {
+func stopVM(vmName string, vm VM, config *ComposeConfig, force bool) error {
 	logger.Printf("Stopping VM: %s (force: %v)", vmName, force)
 
 	if force {
@@ -546,13 +1294,18 @@ func stopVM(vmName string, vm VM, force bool) error {
 		}
 	} else {
 		// Try graceful shutdown first
-		err := stopVMGraceful(vmName, vm)
+		err := stopVMGraceful(vmName, vm, config)
 		if err != nil {
 			logger.Printf("Graceful shutdown failed: %v, falling back to forced stop", err)
 			// Fall back to forced stop
 			if err := stopVMForced(vmName); err != nil {
 				return err
 			}
+		} else if policy, _ := getRestartPolicy(vm); policy == "always" || policy == "unless-stopped" {
+			// QEMU exited on its own after the guest powered off: cancel the restart systemd scheduled
+			if err := stopVMForced(vmName); err != nil {
+				logger.Printf("Warning: failed to cancel restart of VM %s: %v", vmName, err)
+			}
 		}
 	}
 
@@ -567,6 +1320,188 @@ func stopVM(vmName string, vm VM, force bool) error {
 	return nil
 }
 
+// rebootVM reboots the guest of a running VM without restarting QEMU
+// By default the guest shuts down cleanly on an ACPI power button press: QEMU pauses instead of
+// exiting, then the VM is reset and resumed. Guests that do not shut down within the stop grace
+// period are reset. With reset, the VM is reset immediately, like pressing its reset button
+// Each step uses its own QMP connection, the socket is not held while the guest shuts down
+func rebootVM(vmName string, vm VM, reset bool) error {
+	logger.Printf("Rebooting VM: %s (reset: %v)", vmName, reset)
+
+	err := withQMP(vmName, func(client *QMPClient) error {
+		// A paused guest cannot handle the power button, and stays paused after a reset
+		if status, err := client.QueryStatus(); err == nil && status.Status == "paused" {
+			logger.Printf("VM %s is paused, resuming it before reboot", vmName)
+			if err := client.Resume(); err != nil {
+				return err
+			}
+		}
+
+		if reset {
+			return client.SystemReset()
+		}
+
+		if err := client.SetShutdownAction("pause"); err != nil {
+			return fmt.Errorf("clean reboot requires QEMU 6.0 or later (use --reset or --hard): %w", err)
+		}
+		return nil
+	})
+	if err != nil || reset {
+		return err
+	}
+
+	defer func() {
+		if err := withQMP(vmName, func(client *QMPClient) error { return client.SetShutdownAction("poweroff") }); err != nil {
+			logger.Printf("Warning: failed to restore shutdown action of VM %s: %v", vmName, err)
+		}
+	}()
+
+	gracePeriod, err := getStopGracePeriod(vm)
+	if err != nil {
+		logger.Printf("Warning: %v, using %s", err, defaultStopGracePeriod)
+		gracePeriod = defaultStopGracePeriod
+	}
+
+	if err := withQMP(vmName, (*QMPClient).SystemPowerdown); err != nil {
+		return err
+	}
+
+	logger.Printf("Power button pressed, waiting up to %s for the guest to shut down...", gracePeriod)
+
+	// With the pause shutdown action, QEMU reports the shutdown run state once the guest is off
+	shutDown := true
+	if err := waitForVMStatus(vmName, "shutdown", gracePeriod); err != nil {
+		logger.Printf("Guest did not shut down within %s, resetting it", gracePeriod)
+		shutDown = false
+	}
+
+	return withQMP(vmName, func(client *QMPClient) error {
+		if err := client.SystemReset(); err != nil {
+			return err
+		}
+
+		// The guest shut down and QEMU paused the VM: resume it to boot again
+		if shutDown {
+			return client.Resume()
+		}
+		return nil
+	})
+}
+
+// waitForVMStatus waits until QMP query-status reports a run state, such as "shutdown"
+// Each query uses its own QMP connection, so that other commands can use the socket meanwhile
+func waitForVMStatus(vmName string, status string, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf("timeout waiting for VM status %s", status)
+
+		case <-ticker.C:
+			var current string
+			err := withQMP(vmName, func(client *QMPClient) error {
+				result, err := client.QueryStatus()
+				if err != nil {
+					return err
+				}
+				current = result.Status
+				return nil
+			})
+			if err != nil {
+				logger.Printf("Could not query run state of VM %s: %v", vmName, err)
+				continue
+			}
+			if current == status {
+				return nil
+			}
+
+			logger.Printf("Waiting for VM %s to be %s... (status: %s)", vmName, status, current)
+		}
+	}
+}
+
+// startVMInstance starts a stopped VM like up does, with fresh networks, cloud-init ISO and QEMU process
+func startVMInstance(vmName string, vm VM, config *ComposeConfig, composeFilePath string) error {
+	baseImagePath, err := getBaseImagePath(vm.Image)
+	if err != nil {
+		return err
+	}
+
+	instanceDiskPath, err := createInstanceDisk(vmName, baseImagePath, vm.Disk)
+	if err != nil {
+		return fmt.Errorf("failed to create instance disk: %w", err)
+	}
+
+	return startVM(vmName, vm, instanceDiskPath, config, composeFilePath)
+}
+
+// waitForVMReady waits until a VM accepts SSH connections (see isVMReady)
+func waitForVMReady(vmName string, vm VM, config *ComposeConfig, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf("timeout waiting for VM %s to be ready", vmName)
+
+		case <-ticker.C:
+			if isVMReady(vmName, vm, config) {
+				return nil
+			}
+		}
+	}
+}
+
+// pauseVM freezes the vCPUs of a running VM through QMP
+func pauseVM(vmName string) error {
+	logger.Printf("Pausing VM: %s", vmName)
+
+	client, err := connectQMP(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Pause()
+}
+
+// unpauseVM resumes the vCPUs of a paused VM through QMP
+func unpauseVM(vmName string) error {
+	logger.Printf("Unpausing VM: %s", vmName)
+
+	client, err := connectQMP(vmName)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Resume()
+}
+
+// isVMPaused returns true if the vCPUs of a running VM are paused
+// VMs whose QMP socket cannot be reached are reported as not paused
+func isVMPaused(vmName string) bool {
+	client, err := connectQMP(vmName)
+	if err != nil {
+		logger.Printf("Could not query run state of VM %s: %v", vmName, err)
+		return false
+	}
+	defer client.Close()
+
+	status, err := client.QueryStatus()
+	if err != nil {
+		logger.Printf("Could not query run state of VM %s: %v", vmName, err)
+		return false
+	}
+
+	return status.Status == "paused"
+}
+
 // vmInstanceExists checks if a VM instance has been created (disk exists)
 func vmInstanceExists(vmName string) bool {
 	instanceDir, err := getInstanceDir(vmName)
@@ -580,13 +1515,13 @@ func vmInstanceExists(vmName string) bool {
 }
 
 // isVMReady checks if a VM is ready by testing SSH connectivity
-func isVMReady(vmName string, imageURL string) bool {
+func isVMReady(vmName string, vm VM, config *ComposeConfig) bool {
 	logger.Printf("Checking SSH readiness for VM: %s", vmName)
 
-	// Get SSH port
-	sshPort, err := getSSHPort(vmName)
+	// Get SSH endpoint
+	sshHost, sshPort, err := getSSHEndpoint(vmName, vm, config)
 	if err != nil {
-		logger.Printf("Could not get SSH port for VM %s: %v", vmName, err)
+		logger.Printf("Could not get SSH endpoint for VM %s: %v", vmName, err)
 		return false
 	}
 
@@ -606,7 +1541,7 @@ func isVMReady(vmName string, imageURL string) bool {
 	}
 
 	// Detect default user for the OS
-	defaultUser := getDefaultUserForOS(detectOSFromImage(imageURL))
+	defaultUser := getDefaultUserForOS(detectOSFromImage(vm.Image))
 
 	// Quick SSH connectivity test
 	cmd := exec.Command("ssh",
@@ -616,7 +1551,7 @@ func isVMReady(vmName string, imageURL string) bool {
 		"-o", "BatchMode=yes",
 		"-o", "StrictHostKeyChecking=no",
 		"-o", "UserKnownHostsFile=/dev/null",
-		fmt.Sprintf("%s@localhost", defaultUser),
+		fmt.Sprintf("%s@%s", defaultUser, sshHost),
 		"exit",
 	)
 
@@ -631,7 +1566,7 @@ func isVMReady(vmName string, imageURL string) bool {
 }
 
 // getVMStatus returns the status of a VM
-func getVMStatus(vmName string, imageURL string) (string, error) {
+func getVMStatus(vmName string, vm VM, config *ComposeConfig) (string, error) {
 	// First check if the VM instance has been created
 	if !vmInstanceExists(vmName) {
 		return "not-created", nil
@@ -653,9 +1588,17 @@ func getVMStatus(vmName string, imageURL string) (string, error) {
 		return "stopped", nil
 	}
 
-	// If VM is active, check if SSH is ready
+	// QEMU exited and systemd waits RestartSec before starting it again (restart policy)
+	if status == "activating" {
+		return "restarting", nil
+	}
+
+	// If VM is active, check if it is paused, then if SSH is ready
 	if status == "active" {
-		if isVMReady(vmName, imageURL) {
+		if isVMPaused(vmName) {
+			return "paused", nil
+		}
+		if isVMReady(vmName, vm, config) {
 			return "ready", nil
 		}
 		return "starting", nil